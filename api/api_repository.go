@@ -15,8 +15,8 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
-	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
 type RepositoryInfoSnapshots struct {
@@ -255,10 +255,8 @@ func (ui *uiserver) repositoryImporterTypes(w http.ResponseWriter, r *http.Reque
 	return json.NewEncoder(w).Encode(items)
 }
 
-type TimelineLocation struct {
-	Snapshot header.Header `json:"snapshot"`
-	Entry    vfs.Entry     `json:"vfs_entry"`
-}
+// TimelineLocation is shared with the JSON output of plakar locate.
+type TimelineLocation = utils.SnapshotEntry
 
 func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
 	offset, err := QueryParamToUint32(r, "offset", 0, 0)
@@ -21,6 +21,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
@@ -31,11 +32,18 @@ import (
 
 type downloadSignedUrl struct {
 	snapshotID [32]byte
+	source     string
 	rebase     bool
 	files      []string
 }
 
+type sourceKey struct {
+	snapshotID [32]byte
+	source     int
+}
+
 var snapcache = lru.New[[32]byte, *snapshot.Snapshot](30, nil)
+var sourcecache = lru.New[sourceKey, *snapshot.Snapshot](30, nil)
 
 var downloadSignedUrls = ttlmap.New[string, downloadSignedUrl](1 * time.Hour)
 
@@ -57,6 +65,41 @@ func loadsnap(repo *repository.Repository, id [32]byte) (*snapshot.Snapshot, err
 	return snap, nil
 }
 
+// loadsource is like loadsnap but returns a view on the source designated
+// by selector in multi-source snapshots.  An empty selector designates
+// the first source.
+func loadsource(repo *repository.Repository, id [32]byte, selector string) (*snapshot.Snapshot, error) {
+	snap, err := loadsnap(repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if selector == "" {
+		return snap, nil
+	}
+
+	idx, err := utils.FindSource(snap.Header, selector)
+	if err != nil {
+		return nil, parameterError("source", InvalidArgument, err)
+	}
+	if idx == 0 {
+		return snap, nil
+	}
+
+	key := sourceKey{snapshotID: id, source: idx}
+	if view, ok := sourcecache.Get(key); ok {
+		return view, nil
+	}
+
+	view, err := utils.SnapshotSource(repo, snap, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcecache.Put(key, view)
+	return view, nil
+}
+
 func (ui *uiserver) snapshotHeader(w http.ResponseWriter, r *http.Request) error {
 	snapshotID32, err := PathParamToID(r, "snapshot")
 	if err != nil {
@@ -93,7 +136,7 @@ func (ui *uiserver) snapshotReader(w http.ResponseWriter, r *http.Request) error
 		return parameterError("render", InvalidArgument, errors.New("valid values are code, text, auto"))
 	}
 
-	snap, err := loadsnap(ui.repository, snapshotID32)
+	snap, err := loadsource(ui.repository, snapshotID32, r.URL.Query().Get("source"))
 	if err != nil {
 		return err
 	}
@@ -236,6 +279,7 @@ func NewSnapshotReaderURLSigner(ui *uiserver, token string) SnapshotReaderURLSig
 type SnapshotSignedURLClaims struct {
 	SnapshotID string `json:"snapshot_id"`
 	Path       string `json:"path"`
+	Source     string `json:"source,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -246,7 +290,8 @@ func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Reques
 	}
 	snapshotId := fmt.Sprintf("%0x", snapshotID32[:])
 
-	snap, err := loadsnap(signer.ui.repository, snapshotID32)
+	source := r.URL.Query().Get("source")
+	snap, err := loadsource(signer.ui.repository, snapshotID32, source)
 	if err != nil {
 		return err
 	}
@@ -273,6 +318,7 @@ func (signer SnapshotReaderURLSigner) Sign(w http.ResponseWriter, r *http.Reques
 	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, SnapshotSignedURLClaims{
 		SnapshotID: snapshotId,
 		Path:       path,
+		Source:     source,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(2 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -339,6 +385,10 @@ func (signer SnapshotReaderURLSigner) VerifyMiddleware(next http.Handler) http.H
 				handleError(w, r, authError("invalid URL snapshot"))
 				return
 			}
+			if claims.Source != r.URL.Query().Get("source") {
+				handleError(w, r, authError("invalid URL source"))
+				return
+			}
 		} else {
 			handleError(w, r, authError("invalid URL signature"))
 			return
@@ -354,7 +404,7 @@ func (ui *uiserver) snapshotVFSBrowse(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 
-	snap, err := loadsnap(ui.repository, snapshotID32)
+	snap, err := loadsource(ui.repository, snapshotID32, r.URL.Query().Get("source"))
 	if err != nil {
 		return err
 	}
@@ -435,7 +485,7 @@ func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request)
 	}
 	_ = sortKeys
 
-	snap, err := loadsnap(ui.repository, snapshotID32)
+	snap, err := loadsource(ui.repository, snapshotID32, r.URL.Query().Get("source"))
 	if err != nil {
 		return err
 	}
@@ -545,7 +595,7 @@ func (ui *uiserver) snapshotVFSChunks(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 
-	snap, err := loadsnap(ui.repository, snapshotID32)
+	snap, err := loadsource(ui.repository, snapshotID32, r.URL.Query().Get("source"))
 	if err != nil {
 		return err
 	}
@@ -609,7 +659,7 @@ func (ui *uiserver) snapshotVFSSearch(w http.ResponseWriter, r *http.Request) er
 		pattern = str
 	}
 
-	snap, err := loadsnap(ui.repository, snapshotID32)
+	snap, err := loadsource(ui.repository, snapshotID32, r.URL.Query().Get("source"))
 	if err != nil {
 		return err
 	}
@@ -684,7 +734,7 @@ func (ui *uiserver) snapshotVFSErrors(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 
-	snap, err := loadsnap(ui.repository, snapshotID32)
+	snap, err := loadsource(ui.repository, snapshotID32, r.URL.Query().Get("source"))
 	if err != nil {
 		return err
 	}
@@ -752,7 +802,8 @@ func (ui *uiserver) snapshotVFSDownloader(w http.ResponseWriter, r *http.Request
 		return parameterError("BODY", InvalidArgument, err)
 	}
 
-	if _, err = loadsnap(ui.repository, snapshotID32); err != nil {
+	source := r.URL.Query().Get("source")
+	if _, err = loadsource(ui.repository, snapshotID32, source); err != nil {
 		return nil
 	}
 
@@ -764,6 +815,7 @@ func (ui *uiserver) snapshotVFSDownloader(w http.ResponseWriter, r *http.Request
 
 		url := downloadSignedUrl{
 			snapshotID: snapshotID32,
+			source:     source,
 			rebase:     query.Rebase,
 		}
 
@@ -793,7 +845,7 @@ func (ui *uiserver) snapshotVFSDownloaderSigned(w http.ResponseWriter, r *http.R
 		}
 	}
 
-	snap, err := loadsnap(ui.repository, link.snapshotID)
+	snap, err := loadsource(ui.repository, link.snapshotID, link.source)
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@ import (
 	"github.com/PlakarKorp/pkg"
 	"github.com/PlakarKorp/plakar/config"
 	"github.com/PlakarKorp/plakar/cookies"
+	"github.com/PlakarKorp/plakar/ratelimit"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -21,6 +22,13 @@ type AppContext struct {
 
 	StoreConfig map[string]string
 
+	// Limits are the default bandwidth limits of the stores.
+	Limits *ratelimit.Limits
+
+	// Retention is the retention policy of the store, applied after the
+	// backups and by the maintenance.
+	Retention string
+
 	Quiet  bool
 	Silent bool
 }
@@ -38,6 +46,7 @@ func NewAppContextFrom(ctx *AppContext) *AppContext {
 		cookies:   ctx.cookies,
 		pkgmgr:    ctx.pkgmgr,
 		ConfigDir: ctx.ConfigDir,
+		Limits:    ctx.Limits,
 	}
 }
 
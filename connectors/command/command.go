@@ -0,0 +1,302 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package command implements an importer backing up the output of a
+// command, such as a database dump, either as a single file or as the
+// tree of a tar stream.
+package command
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// The command can only run once and its output is read record by record.
+const flags = location.FLAG_STREAM | location.FLAG_NEEDACK
+
+const (
+	FormatFile = "file"
+	FormatTar  = "tar"
+)
+
+func init() {
+	importer.Register("command", flags, NewImporter)
+}
+
+type Importer struct {
+	opts *connectors.Options
+	name string
+
+	pathname string
+	command  string
+	format   string
+}
+
+// NewImporter returns an importer for a location of the form
+// command:///path, the command to run is set by the command option.
+func NewImporter(ctx context.Context, opts *connectors.Options, name string, config map[string]string) (importer.Importer, error) {
+	pathname := strings.TrimPrefix(config["location"], name+"://")
+	pathname = path.Clean("/" + pathname)
+
+	command := config["command"]
+	if command == "" {
+		return nil, fmt.Errorf("%s: missing command", name)
+	}
+
+	format := config["format"]
+	switch format {
+	case "":
+		format = FormatFile
+	case FormatFile, FormatTar:
+	default:
+		return nil, fmt.Errorf("%s: invalid format %q, must be %s or %s",
+			name, format, FormatFile, FormatTar)
+	}
+
+	if format == FormatFile && pathname == "/" {
+		return nil, fmt.Errorf("%s: missing the path of the file", name)
+	}
+
+	return &Importer{
+		opts:     opts,
+		name:     name,
+		pathname: pathname,
+		command:  command,
+		format:   format,
+	}, nil
+}
+
+func (p *Importer) Type() string          { return p.name }
+func (p *Importer) Root() string          { return "/" }
+func (p *Importer) Origin() string        { return p.opts.Hostname }
+func (p *Importer) Flags() location.Flags { return flags }
+
+func (p *Importer) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (p *Importer) Close(ctx context.Context) error {
+	return nil
+}
+
+// Import runs the command and fails if it didn't exit successfully, so
+// that the snapshot isn't committed with a truncated dump.
+func (p *Importer) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.CommandContext(ctx, "cmd", "/C", p.command)
+	default: // assume unix-esque
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", p.command)
+	}
+	cmd.Stderr = p.opts.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run %q: %w", p.command, err)
+	}
+
+	imp := importRun{ctx: ctx, records: records, results: results, start: time.Now()}
+	if p.format == FormatTar {
+		err = imp.tree(p.pathname, stdout)
+	} else {
+		err = imp.file(p.pathname, stdout)
+	}
+
+	// whatever was not read must not leave the command blocked
+	io.Copy(io.Discard, stdout)
+	if werr := cmd.Wait(); werr != nil {
+		return fmt.Errorf("command %q failed: %w", p.command, werr)
+	}
+	return err
+}
+
+type importRun struct {
+	ctx     context.Context
+	records chan<- *connectors.Record
+	results <-chan *connectors.Result
+	start   time.Time
+}
+
+// send passes a record to the snapshot and waits until it's been
+// processed, the output of the command can only be read in order.
+func (r *importRun) send(record *connectors.Record) error {
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case r.records <- record:
+	}
+
+	if r.results == nil {
+		return nil
+	}
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-r.results:
+		return nil
+	}
+}
+
+func (r *importRun) directory(pathname string) error {
+	fi := objects.FileInfo{
+		Lname:    path.Base(pathname),
+		Lmode:    0755 | os.ModeDir,
+		LmodTime: r.start,
+		Lnlink:   1,
+	}
+	return r.send(connectors.NewRecord(pathname, "", fi, nil, nil))
+}
+
+// parents records the directories leading to pathname, excluding it.
+func (r *importRun) parents(pathname string) error {
+	var dirs []string
+	for dir := path.Dir(pathname); dir != pathname; pathname, dir = dir, path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := r.directory(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// file records the whole output as a single file, its size is only known
+// once it's been read.
+func (r *importRun) file(pathname string, rd io.Reader) error {
+	if err := r.parents(pathname); err != nil {
+		return err
+	}
+
+	fi := objects.FileInfo{
+		Lname:    path.Base(pathname),
+		Lmode:    0600,
+		Lsize:    -1,
+		LmodTime: r.start,
+		Lnlink:   1,
+	}
+	return r.send(&connectors.Record{
+		Pathname: pathname,
+		FileInfo: fi,
+		Reader:   io.NopCloser(rd),
+	})
+}
+
+// tree expands the tar stream of the output below root.
+func (r *importRun) tree(root string, rd io.Reader) error {
+	if err := r.parents(root); err != nil {
+		return err
+	}
+	if err := r.directory(root); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar stream: %w", err)
+		}
+
+		// the names are cleaned as absolute paths first so that none
+		// can escape root
+		pathname := path.Join(root, path.Clean("/"+hdr.Name))
+		if pathname == root {
+			continue
+		}
+
+		record := &connectors.Record{
+			Pathname: pathname,
+			FileInfo: fileinfo(hdr),
+		}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			record.Target = hdr.Linkname
+		case tar.TypeLink:
+			// The content of the target was already consumed, the hard
+			// link is recorded as a symbolic link to it.
+			record.Target = relativeTarget(pathname, path.Join(root, path.Clean("/"+hdr.Linkname)))
+		case tar.TypeReg:
+			record.Reader = io.NopCloser(tr)
+		}
+		if err := r.send(record); err != nil {
+			return err
+		}
+	}
+}
+
+// relativeTarget returns target relative to the directory of pathname,
+// as a symbolic link would name it.
+func relativeTarget(pathname, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(pathname)), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
+
+func fileinfo(hdr *tar.Header) objects.FileInfo {
+	fi := objects.FileInfo{
+		Lname:      path.Base(hdr.Name),
+		Lsize:      hdr.Size,
+		Lmode:      fs.FileMode(hdr.Mode).Perm(),
+		LmodTime:   hdr.ModTime,
+		Luid:       uint64(hdr.Uid),
+		Lgid:       uint64(hdr.Gid),
+		Lnlink:     1,
+		Lusername:  hdr.Uname,
+		Lgroupname: hdr.Gname,
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fi.Lmode |= fs.ModeDir
+	case tar.TypeSymlink, tar.TypeLink:
+		fi.Lmode |= fs.ModeSymlink
+	case tar.TypeChar:
+		fi.Lmode |= fs.ModeDevice | fs.ModeCharDevice
+	case tar.TypeBlock:
+		fi.Lmode |= fs.ModeDevice
+	case tar.TypeFifo:
+		fi.Lmode |= fs.ModeNamedPipe
+	}
+	return fi
+}
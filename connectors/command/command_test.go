@@ -0,0 +1,144 @@
+package command
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/stretchr/testify/require"
+)
+
+type imported struct {
+	mode    os.FileMode
+	target  string
+	content string
+}
+
+// runImport imports the output of the command and acks the records as the
+// snapshot builder would.
+func runImport(t *testing.T, config map[string]string) (map[string]imported, error) {
+	t.Helper()
+	imp, err := NewImporter(context.Background(), &connectors.Options{Hostname: "host"}, "command", config)
+	require.NoError(t, err)
+
+	records := make(chan *connectors.Record)
+	results := make(chan *connectors.Result, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- imp.Import(context.Background(), records, results)
+	}()
+
+	tree := make(map[string]imported)
+	for record := range records {
+		entry := imported{mode: record.FileInfo.Mode(), target: record.Target}
+		if record.Reader != nil && record.FileInfo.Mode().IsRegular() {
+			data, err := io.ReadAll(record.Reader)
+			require.NoError(t, err)
+			entry.content = string(data)
+		}
+		tree[record.Pathname] = entry
+		results <- record.Ok()
+	}
+	return tree, <-done
+}
+
+func TestNewImporter(t *testing.T) {
+	opts := &connectors.Options{Hostname: "host"}
+
+	imp, err := NewImporter(context.Background(), opts, "command",
+		map[string]string{"location": "command:///dumps/db.sql", "command": "true"})
+	require.NoError(t, err)
+	require.Equal(t, "command", imp.Type())
+	require.Equal(t, "host", imp.Origin())
+
+	cases := map[string]map[string]string{
+		"missing command": {"location": "command:///dumps/db.sql"},
+		"invalid format":  {"location": "command:///dumps/db.sql", "command": "true", "format": "zip"},
+		"missing path":    {"location": "command://", "command": "true"},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewImporter(context.Background(), opts, "command", config)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	tree, err := runImport(t, map[string]string{
+		"location": "command:///dumps/db.sql",
+		"command":  "echo 'CREATE TABLE t;'",
+	})
+	require.NoError(t, err)
+
+	require.True(t, tree["/"].mode.IsDir())
+	require.True(t, tree["/dumps"].mode.IsDir())
+	require.Equal(t, "CREATE TABLE t;\n", tree["/dumps/db.sql"].content)
+	require.Equal(t, os.FileMode(0600), tree["/dumps/db.sql"].mode)
+	require.Len(t, tree, 3)
+}
+
+func TestImportFailure(t *testing.T) {
+	_, err := runImport(t, map[string]string{
+		"location": "command:///dumps/db.sql",
+		"command":  "echo partial; exit 3",
+	})
+	require.ErrorContains(t, err, "exit status 3")
+
+	// the status is checked even if the output wasn't read
+	_, err = runImport(t, map[string]string{
+		"location": "command:///dumps",
+		"command":  "exit 4",
+		"format":   "tar",
+	})
+	require.ErrorContains(t, err, "exit status 4")
+}
+
+func TestImportTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	modTime := time.Now().Truncate(time.Second)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0700, ModTime: modTime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data/base.db", Typeflag: tar.TypeReg, Mode: 0640, Size: 5, ModTime: modTime}))
+	_, err := tw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "current", Typeflag: tar.TypeSymlink, Linkname: "data/base.db", ModTime: modTime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data/backup/base.db", Typeflag: tar.TypeLink, Linkname: "./data/base.db", ModTime: modTime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../../escaped", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}))
+	require.NoError(t, tw.Close())
+
+	archive := filepath.Join(t.TempDir(), "dump.tar")
+	require.NoError(t, os.WriteFile(archive, buf.Bytes(), 0600))
+
+	tree, err := runImport(t, map[string]string{
+		"location": "command:///dumps/app",
+		"command":  "cat " + archive,
+		"format":   "tar",
+	})
+	require.NoError(t, err)
+
+	require.True(t, tree["/dumps/app"].mode.IsDir())
+	require.Equal(t, os.ModeDir|0700, tree["/dumps/app/data"].mode)
+	require.Equal(t, "hello", tree["/dumps/app/data/base.db"].content)
+	require.Equal(t, os.FileMode(0640), tree["/dumps/app/data/base.db"].mode)
+	require.Equal(t, "data/base.db", tree["/dumps/app/current"].target)
+	require.Equal(t, "../base.db", tree["/dumps/app/data/backup/base.db"].target)
+	require.Contains(t, tree, "/dumps/app/escaped")
+	require.Len(t, tree, 8)
+}
+
+func TestImportInvalidTar(t *testing.T) {
+	_, err := runImport(t, map[string]string{
+		"location": "command:///dumps",
+		"command":  "echo not a tar archive",
+		"format":   "tar",
+	})
+	require.ErrorContains(t, err, "invalid tar stream")
+}
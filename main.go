@@ -28,6 +28,8 @@ import (
 	"github.com/PlakarKorp/plakar/cached"
 	"github.com/PlakarKorp/plakar/cookies"
 	"github.com/PlakarKorp/plakar/exitcodes"
+	"github.com/PlakarKorp/plakar/ratelimit"
+	"github.com/PlakarKorp/plakar/retention"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/task"
 	"github.com/PlakarKorp/plakar/ui"
@@ -50,6 +52,7 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/digest"
 	_ "github.com/PlakarKorp/plakar/subcommands/dup"
 	_ "github.com/PlakarKorp/plakar/subcommands/help"
+	_ "github.com/PlakarKorp/plakar/subcommands/hold"
 	_ "github.com/PlakarKorp/plakar/subcommands/info"
 	_ "github.com/PlakarKorp/plakar/subcommands/locate"
 	_ "github.com/PlakarKorp/plakar/subcommands/login"
@@ -62,9 +65,12 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/repair"
 	_ "github.com/PlakarKorp/plakar/subcommands/restore"
 	_ "github.com/PlakarKorp/plakar/subcommands/rm"
+	_ "github.com/PlakarKorp/plakar/subcommands/scheduler"
 	_ "github.com/PlakarKorp/plakar/subcommands/server"
 	_ "github.com/PlakarKorp/plakar/subcommands/service"
+	_ "github.com/PlakarKorp/plakar/subcommands/snapshot"
 	_ "github.com/PlakarKorp/plakar/subcommands/sync"
+	_ "github.com/PlakarKorp/plakar/subcommands/tag"
 	_ "github.com/PlakarKorp/plakar/subcommands/ui"
 	_ "github.com/PlakarKorp/plakar/subcommands/version"
 
@@ -76,6 +82,8 @@ import (
 	_ "github.com/PlakarKorp/integrations/stdio/exporter"
 	_ "github.com/PlakarKorp/integrations/stdio/importer"
 	_ "github.com/PlakarKorp/integrations/tar/importer"
+
+	_ "github.com/PlakarKorp/plakar/connectors/command"
 )
 
 var ErrCantUnlock = errors.New("failed to unlock repository")
@@ -144,6 +152,8 @@ func entryPoint() int {
 	var opt_enableSecurityCheck bool
 	var opt_disableSecurityCheck bool
 	var opt_maxConcurrency int
+	var opt_limitUpload string
+	var opt_limitDownload string
 
 	flag.StringVar(&opt_config, "config", opt_configDefault, "configuration directory (deprecated, use -configdir instead)")
 	flag.StringVar(&opt_configdir, "configdir", opt_configDefault, "configuration directory")
@@ -151,6 +161,8 @@ func entryPoint() int {
 	flag.StringVar(&opt_datadir, "datadir", opt_dataDefault, "data directory")
 	flag.IntVar(&opt_cpuCount, "cpu", opt_cpuDefault, "limit the number of usable cores")
 	flag.IntVar(&opt_maxConcurrency, "concurrency", -1, "limit the number of concurrent operations")
+	flag.StringVar(&opt_limitUpload, "limit-upload", "", "limit the upload rate to the store, e.g. 1MiB or 1MiB@08:00-18:00,10MiB")
+	flag.StringVar(&opt_limitDownload, "limit-download", "", "limit the download rate from the store, e.g. 1MiB or 1MiB@08:00-18:00,10MiB")
 	flag.StringVar(&opt_cpuProfile, "profile-cpu", "", "profile CPU usage")
 	flag.StringVar(&opt_memProfile, "profile-mem", "", "profile MEM usage")
 	flag.BoolVar(&opt_time, "time", false, "display command execution time")
@@ -288,6 +300,12 @@ func entryPoint() int {
 		opt_maxConcurrency = opt_cpuCount
 	}
 
+	limits, err := ratelimit.Parse(opt_limitUpload, opt_limitDownload)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.CommandLine.Name(), err)
+		return 1
+	}
+
 	if opt_cpuProfile != "" {
 		f, err := os.Create(opt_cpuProfile)
 		if err != nil {
@@ -321,6 +339,7 @@ func entryPoint() int {
 	ctx.KeyFromFile = secretFromKeyfile
 	ctx.ProcessID = os.Getpid()
 	ctx.MaxConcurrency = opt_maxConcurrency
+	ctx.Limits = limits
 
 	if flag.NArg() == 0 {
 		fmt.Fprintf(os.Stderr, "%s: a subcommand must be provided\n", filepath.Base(flag.CommandLine.Name()))
@@ -376,6 +395,13 @@ func entryPoint() int {
 		return 1
 	}
 
+	storeLimits, err := ratelimit.FromConfig(storeConfig, ctx.Limits)
+	if err != nil {
+		logger.Stderr("%s: %s\n", flag.CommandLine.Name(), err)
+		return 1
+	}
+	ctx.Retention = retention.FromConfig(storeConfig)
+
 	cmd, _, args := subcommands.Lookup(args)
 	if cmd == nil {
 		logger.Stderr("command not found: %s\n", args[0])
@@ -412,6 +438,7 @@ func entryPoint() int {
 			logger.Stderr("To specify an alternative repository, please use \"plakar at <location> <command>\".")
 			return exitcodes.RepoNotFound
 		}
+		store = storeLimits.Wrap(store)
 
 		repoConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
 		if err != nil {
@@ -443,6 +470,9 @@ func entryPoint() int {
 
 	t0 := time.Now()
 	if err := cmd.Parse(ctx, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
 		logger.Stderr("%s: %s\n", flag.CommandLine.Name(), err)
 		return 1
 	}
@@ -450,6 +480,10 @@ func entryPoint() int {
 	c := make(chan os.Signal, 1)
 	go func() {
 		<-c
+		if i, ok := cmd.(subcommands.Interrupter); ok && i.Interrupt() {
+			logger.Stderr("%s: received interrupt signal, finishing the current work, interrupt again to abort...\n", flag.CommandLine.Name())
+			<-c
+		}
 		ctx.Cancel(fmt.Errorf("interrupted"))
 		interrupted = true
 	}()
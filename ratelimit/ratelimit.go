@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package ratelimit caps the bandwidth used to transfer data to and from
+// a store, possibly depending on the time of the day.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// rule limits the rate to rate bytes per second, between from and to
+// minutes after midnight if the rule has a window.
+type rule struct {
+	rate     int64
+	from, to int
+	window   bool
+}
+
+func (r rule) match(t time.Time) bool {
+	if !r.window {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if r.from <= r.to {
+		return minute >= r.from && minute < r.to
+	}
+	// the window spans midnight
+	return minute >= r.from || minute < r.to
+}
+
+// Schedule is a list of rates, each one optionally restricted to a time
+// window, the first one matching applies.  It is written as a
+// comma-separated list of RATE or RATE@HH:MM-HH:MM, for example
+// 1MiB@08:00-18:00,10MiB.  Outside of the windows listed, and if no
+// rate without window is given, the bandwidth isn't limited.
+type Schedule struct {
+	rules []rule
+}
+
+// ParseSchedule parses a schedule, the empty string means no limit.
+func ParseSchedule(value string) (*Schedule, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	s := &Schedule{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		rate, window, hasWindow := strings.Cut(item, "@")
+
+		bytes, err := humanize.ParseBytes(rate)
+		if err != nil || bytes == 0 {
+			return nil, fmt.Errorf("invalid rate %q", rate)
+		}
+		r := rule{rate: int64(bytes)}
+
+		if hasWindow {
+			from, to, ok := strings.Cut(window, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid time window %q, must be HH:MM-HH:MM", window)
+			}
+			if r.from, err = parseTime(from); err != nil {
+				return nil, err
+			}
+			if r.to, err = parseTime(to); err != nil {
+				return nil, err
+			}
+			r.window = true
+		}
+		s.rules = append(s.rules, r)
+	}
+	return s, nil
+}
+
+// parseTime returns the number of minutes after midnight of HH:MM.
+func parseTime(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Rate returns the rate in bytes per second at time t, or 0 if it isn't
+// limited.
+func (s *Schedule) Rate(t time.Time) int64 {
+	if s == nil {
+		return 0
+	}
+	for _, r := range s.rules {
+		if r.match(t) {
+			return r.rate
+		}
+	}
+	return 0
+}
+
+// Limiter is a token bucket refilled at the rate of its schedule, holding
+// at most a second worth of tokens.  Transfers larger than what's
+// available go in debt, making the following ones wait.
+type Limiter struct {
+	schedule *Schedule
+	now      func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter following schedule, nil if there's no
+// schedule.
+func NewLimiter(schedule *Schedule) *Limiter {
+	if schedule == nil {
+		return nil
+	}
+	return &Limiter{schedule: schedule, now: time.Now}
+}
+
+// Rate returns the current rate in bytes per second, or 0 if it isn't
+// limited.
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.schedule.Rate(l.now())
+}
+
+// WaitN waits until n bytes may be transferred.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	rate := float64(l.schedule.Rate(now))
+	if rate == 0 {
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	l.tokens = min(l.tokens, rate) - float64(n)
+	l.last = now
+	wait := time.Duration(-l.tokens / rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// chunk is the largest read done at once, so that the transfer is
+// spread over time rather than bursty.
+const chunk = 32 * 1024
+
+type reader struct {
+	ctx     context.Context
+	rd      io.Reader
+	limiter *Limiter
+}
+
+// Reader returns a reader reading from rd at the rate of the limiter.
+func (l *Limiter) Reader(ctx context.Context, rd io.Reader) io.Reader {
+	if l == nil {
+		return rd
+	}
+	return &reader{ctx: ctx, rd: rd, limiter: l}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.rd.Read(p)
+	if werr := r.limiter.WaitN(r.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2025, 1, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("")
+	require.NoError(t, err)
+	require.Nil(t, s)
+	require.Equal(t, int64(0), s.Rate(at(12, 0)))
+
+	s, err = ParseSchedule("1MiB")
+	require.NoError(t, err)
+	require.Equal(t, int64(1<<20), s.Rate(at(3, 0)))
+
+	s, err = ParseSchedule("512KiB@08:00-18:00, 10MiB")
+	require.NoError(t, err)
+	require.Equal(t, int64(512<<10), s.Rate(at(8, 0)))
+	require.Equal(t, int64(512<<10), s.Rate(at(17, 59)))
+	require.Equal(t, int64(10<<20), s.Rate(at(18, 0)))
+	require.Equal(t, int64(10<<20), s.Rate(at(7, 59)))
+
+	// outside of the only window the rate isn't limited
+	s, err = ParseSchedule("1MB@22:00-06:00")
+	require.NoError(t, err)
+	require.Equal(t, int64(1000000), s.Rate(at(23, 0)))
+	require.Equal(t, int64(1000000), s.Rate(at(5, 0)))
+	require.Equal(t, int64(0), s.Rate(at(12, 0)))
+
+	for _, value := range []string{"fast", "0", "1MiB@08:00", "1MiB@8h-18h", "1MiB@08:00-25:00"} {
+		_, err := ParseSchedule(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestLimiterReader(t *testing.T) {
+	s, err := ParseSchedule("1MiB")
+	require.NoError(t, err)
+	l := NewLimiter(s)
+	require.Equal(t, int64(1<<20), l.Rate())
+
+	data := bytes.Repeat([]byte("x"), 256<<10)
+	begin := time.Now()
+	read, err := io.ReadAll(l.Reader(context.Background(), bytes.NewReader(data)))
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	require.GreaterOrEqual(t, time.Since(begin), 200*time.Millisecond)
+}
+
+func TestLimiterCanceled(t *testing.T) {
+	s, err := ParseSchedule("1KiB")
+	require.NoError(t, err)
+	l := NewLimiter(s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, l.WaitN(ctx, 1<<20), context.Canceled)
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	var l *Limiter
+	require.Equal(t, int64(0), l.Rate())
+	require.NoError(t, l.WaitN(context.Background(), 1<<30))
+
+	rd := bytes.NewReader(nil)
+	require.Same(t, rd, l.Reader(context.Background(), rd))
+
+	// outside of its window the limiter doesn't wait
+	s, err := ParseSchedule("1B@00:00-00:00")
+	require.NoError(t, err)
+	l = NewLimiter(s)
+	l.now = func() time.Time { return at(12, 0) }
+	begin := time.Now()
+	require.NoError(t, l.WaitN(context.Background(), 1<<30))
+	require.Less(t, time.Since(begin), time.Second)
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/kcontext"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// Keys of a store configuration setting its limits, they are not passed
+// to the storage backend.
+const (
+	UploadKey   = "limit_upload"
+	DownloadKey = "limit_download"
+)
+
+// Limits are the schedules of the uploads to and of the downloads from a
+// store, a nil schedule doesn't limit the transfers.
+type Limits struct {
+	Upload   *Schedule
+	Download *Schedule
+}
+
+// Parse returns the limits given as schedules, nil if there's none.
+func Parse(upload, download string) (*Limits, error) {
+	up, err := ParseSchedule(upload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload limit: %w", err)
+	}
+	down, err := ParseSchedule(download)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download limit: %w", err)
+	}
+	if up == nil && down == nil {
+		return nil, nil
+	}
+	return &Limits{Upload: up, Download: down}, nil
+}
+
+// FromConfig extracts the limits from the configuration of a store, they
+// override the defaults for this store.
+func FromConfig(storeConfig map[string]string, defaults *Limits) (*Limits, error) {
+	up, hasUp := storeConfig[UploadKey]
+	down, hasDown := storeConfig[DownloadKey]
+	delete(storeConfig, UploadKey)
+	delete(storeConfig, DownloadKey)
+
+	limits, err := Parse(up, down)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", storeConfig["location"], err)
+	}
+	if defaults == nil {
+		return limits, nil
+	}
+	if limits == nil {
+		limits = &Limits{}
+	}
+	if !hasUp {
+		limits.Upload = defaults.Upload
+	}
+	if !hasDown {
+		limits.Download = defaults.Download
+	}
+	return limits, nil
+}
+
+// Open opens the store with its transfers limited as set in its
+// configuration, or by defaults.
+func Open(ctx *kcontext.KContext, storeConfig map[string]string, defaults *Limits) (storage.Store, []byte, error) {
+	limits, err := FromConfig(storeConfig, defaults)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, serializedConfig, err := storage.Open(ctx, storeConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return limits.Wrap(store), serializedConfig, nil
+}
+
+// Wrap returns store with its transfers limited, or store itself if
+// there's no limit.
+func (l *Limits) Wrap(store storage.Store) storage.Store {
+	if l == nil || (l.Upload == nil && l.Download == nil) {
+		return store
+	}
+	return &Store{
+		Store:    store,
+		upload:   NewLimiter(l.Upload),
+		download: NewLimiter(l.Download),
+	}
+}
+
+// Store limits the rate of the data put to and got from a store.
+type Store struct {
+	storage.Store
+	upload   *Limiter
+	download *Limiter
+}
+
+// Limits returns the current upload and download rates in bytes per
+// second, 0 if they're not limited.
+func (s *Store) Limits() (upload, download int64) {
+	return s.upload.Rate(), s.download.Rate()
+}
+
+func (s *Store) Put(ctx context.Context, res storage.StorageResource, mac objects.MAC, rd io.Reader) (int64, error) {
+	return s.Store.Put(ctx, res, mac, s.upload.Reader(ctx, rd))
+}
+
+func (s *Store) Get(ctx context.Context, res storage.StorageResource, mac objects.MAC, rg *storage.Range) (io.ReadCloser, error) {
+	rd, err := s.Store.Get(ctx, res, mac, rg)
+	if err != nil || s.download == nil {
+		return rd, err
+	}
+	limited := s.download.Reader(ctx, rd)
+	if st, ok := rd.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		return &statReader{limited, rd, st.Stat}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{limited, rd}, nil
+}
+
+// statReader is a resource whose reads are limited, it keeps the Stat
+// method of the resource so that its size and age can still be known.
+type statReader struct {
+	io.Reader
+	io.Closer
+	stat func() (fs.FileInfo, error)
+}
+
+func (r *statReader) Stat() (fs.FileInfo, error) {
+	return r.stat()
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore holds a single object.
+type memStore struct {
+	storage.Store
+	data []byte
+}
+
+func (m *memStore) Put(ctx context.Context, res storage.StorageResource, mac objects.MAC, rd io.Reader) (int64, error) {
+	data, err := io.ReadAll(rd)
+	m.data = data
+	return int64(len(data)), err
+}
+
+func (m *memStore) Get(ctx context.Context, res storage.StorageResource, mac objects.MAC, rg *storage.Range) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(m.data)), nil
+}
+
+func TestFromConfig(t *testing.T) {
+	defaults, err := Parse("1MiB", "2MiB")
+	require.NoError(t, err)
+
+	config := map[string]string{"location": "s3://bucket", UploadKey: "512KiB"}
+	limits, err := FromConfig(config, defaults)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"location": "s3://bucket"}, config)
+	require.Equal(t, int64(512<<10), limits.Upload.Rate(at(12, 0)))
+	require.Equal(t, int64(2<<20), limits.Download.Rate(at(12, 0)))
+
+	// an empty value lifts the default limit
+	limits, err = FromConfig(map[string]string{DownloadKey: ""}, defaults)
+	require.NoError(t, err)
+	require.Nil(t, limits.Download)
+	require.NotNil(t, limits.Upload)
+
+	limits, err = FromConfig(map[string]string{"location": "fs:///tmp"}, nil)
+	require.NoError(t, err)
+	require.Nil(t, limits)
+
+	_, err = FromConfig(map[string]string{"location": "fs:///tmp", UploadKey: "fast"}, nil)
+	require.ErrorContains(t, err, "invalid upload limit")
+}
+
+func TestWrap(t *testing.T) {
+	inner := &memStore{}
+	var none *Limits
+	require.Same(t, storage.Store(inner), none.Wrap(inner))
+
+	limits, err := Parse("", "1GiB")
+	require.NoError(t, err)
+	store := limits.Wrap(inner)
+	limited, ok := store.(*Store)
+	require.True(t, ok)
+
+	upload, download := limited.Limits()
+	require.Equal(t, int64(0), upload)
+	require.Equal(t, int64(1<<30), download)
+
+	n, err := store.Put(context.Background(), storage.StorageResource(0), objects.MAC{}, bytes.NewReader([]byte("packfile")))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), n)
+
+	rd, err := store.Get(context.Background(), storage.StorageResource(0), objects.MAC{}, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.NoError(t, rd.Close())
+	require.Equal(t, "packfile", string(data))
+}
+
+// fileStore serves its object from a file, as the fs store does.
+type fileStore struct {
+	storage.Store
+	path string
+}
+
+func (f *fileStore) Get(ctx context.Context, res storage.StorageResource, mac objects.MAC, rg *storage.Range) (io.ReadCloser, error) {
+	return os.Open(f.path)
+}
+
+func TestWrapKeepsStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "packfile")
+	require.NoError(t, os.WriteFile(path, []byte("packfile"), 0600))
+
+	limits, err := Parse("", "1GiB")
+	require.NoError(t, err)
+	store := limits.Wrap(&fileStore{path: path})
+
+	rd, err := store.Get(context.Background(), storage.StorageResource(0), objects.MAC{}, nil)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	st, ok := rd.(interface{ Stat() (fs.FileInfo, error) })
+	require.True(t, ok)
+	fi, err := st.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(8), fi.Size())
+
+	// the reads are still limited
+	_, ok = rd.(io.Seeker)
+	require.False(t, ok)
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "packfile", string(data))
+
+	// nor is there one to keep
+	rd, err = limits.Wrap(&memStore{}).Get(context.Background(), storage.StorageResource(0), objects.MAC{}, nil)
+	require.NoError(t, err)
+	_, ok = rd.(interface{ Stat() (fs.FileInfo, error) })
+	require.False(t, ok)
+}
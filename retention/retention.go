@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package retention
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// Key of a store configuration naming the retention policy applied to the
+// store, it is not passed to the storage backend.
+const Key = "retention"
+
+// FromConfig extracts the name of the retention policy from the
+// configuration of a store, an empty string if it has none.
+func FromConfig(storeConfig map[string]string) string {
+	name := storeConfig[Key]
+	delete(storeConfig, Key)
+	return name
+}
+
+// Load returns the options of the policy name, as found in the
+// policies.yml of configDir.  A policy selecting every snapshot for
+// deletion is refused.
+func Load(configDir, name string) (*locate.LocateOptions, error) {
+	cfg, err := utils.LoadPolicyConfigFile(filepath.Join(configDir, "policies.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policies config: %w", err)
+	}
+	if !cfg.Has(name) {
+		return nil, fmt.Errorf("policy %q not found", name)
+	}
+
+	opts := locate.NewDefaultLocateOptions()
+	cfg.ApplyConfig(name, opts)
+	if opts.Empty() {
+		return nil, fmt.Errorf("policy %q has no filter, not going to prune everything", name)
+	}
+	return opts, nil
+}
+
+// Decision is what a policy decides for a snapshot.  The snapshots the
+// filters of the policy don't select are kept, Matched is false for them.
+// The held snapshots are kept whatever the policy decides.
+type Decision struct {
+	ID        objects.MAC
+	Timestamp time.Time
+	Name      string
+	Matched   bool
+	Reason    locate.Reason
+	Hold      *utils.Hold
+}
+
+// Delete returns whether the policy removes the snapshot.
+func (d Decision) Delete() bool {
+	return d.Matched && d.Reason.Action == "delete" && d.Hold == nil
+}
+
+// Held returns whether the snapshot is only kept by its hold.
+func (d Decision) Held() bool {
+	return d.Matched && d.Reason.Action == "delete" && d.Hold != nil
+}
+
+// Explain describes the reason of the decision, the rule and bucket of the
+// retention window the snapshot falls in if any.
+func (d Decision) Explain() string {
+	if d.Held() {
+		return "held: " + d.Hold.String()
+	}
+	r := d.Reason
+	if r.Rule == "" {
+		return r.Note
+	}
+	if r.Cap == 0 {
+		return fmt.Sprintf("%s:%s #%d", r.Rule, r.Bucket, r.Rank)
+	}
+	return fmt.Sprintf("%s:%s #%d/%d", r.Rule, r.Bucket, r.Rank, r.Cap)
+}
+
+// Evaluate returns the decisions of the policy opts for the snapshots of
+// repo, newest first.  The checkpoints of the backups in progress are kept
+// and don't count in the retention windows, unless opts selects them.
+func Evaluate(repo *repository.Repository, opts *locate.LocateOptions) ([]Decision, error) {
+	filters := *opts
+	utils.IgnoreCheckpoints(&filters)
+	_, reasons, err := locate.Match(repo, &filters)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	decisions := make([]Decision, 0, len(reasons))
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+		}
+		d := Decision{
+			ID:        snapshotID,
+			Timestamp: snap.Header.Timestamp,
+			Name:      snap.Header.Name,
+			Reason:    locate.Reason{Action: "keep", Note: "not matched by the filters"},
+		}
+		if snap.Header.HasTag(utils.CheckpointTag) {
+			d.Reason.Note = "checkpoint of a backup in progress"
+		}
+		snap.Close()
+
+		if d.Hold, err = utils.LoadHold(repo, snapshotID, now); err != nil {
+			return nil, fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+		}
+
+		if r, found := reasons[snapshotID]; found {
+			d.Matched = true
+			d.Reason = r
+		}
+		decisions = append(decisions, d)
+	}
+
+	slices.SortFunc(decisions, func(a, b Decision) int {
+		return b.Timestamp.Compare(a.Timestamp)
+	})
+	return decisions, nil
+}
+
+// Apply removes the snapshots of repo the policy name decides to delete,
+// and returns how many were.
+func Apply(ctx *appcontext.AppContext, repo *repository.Repository, name string) (int, error) {
+	opts, err := Load(ctx.ConfigDir, name)
+	if err != nil {
+		return 0, err
+	}
+
+	decisions, err := Evaluate(repo, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	removed, failed := 0, 0
+	for _, d := range decisions {
+		if d.Held() {
+			ctx.GetLogger().Info("retention: keeping %x, held: %s", d.ID[:4], d.Hold)
+		}
+		if !d.Delete() {
+			continue
+		}
+		if err := repo.DeleteSnapshot(d.ID); err != nil {
+			ctx.GetLogger().Error("retention: failed to remove %x: %s", d.ID[:4], err)
+			failed++
+			continue
+		}
+		ctx.GetLogger().Info("retention: removal of %x completed successfully (%s)", d.ID[:4], d.Explain())
+		removed++
+	}
+
+	ctx.GetLogger().Info("retention: policy %s removed %d snapshots from %s", name, removed, repo.Origin())
+	if failed != 0 {
+		return removed, fmt.Errorf("failed to remove %d snapshots", failed)
+	}
+	return removed, nil
+}
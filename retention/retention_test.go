@@ -0,0 +1,216 @@
+package retention
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// addPolicy saves the policy name with the given settings in the
+// policies.yml of ctx.
+func addPolicy(t *testing.T, ctx *appcontext.AppContext, name string, settings map[string]string) {
+	configFile := filepath.Join(ctx.ConfigDir, "policies.yml")
+	cfg, err := utils.LoadPolicyConfigFile(configFile)
+	require.NoError(t, err)
+	cfg.Add(name)
+	for key, value := range settings {
+		require.NoError(t, cfg.Set(name, key, value))
+	}
+	require.NoError(t, cfg.SaveToFile(configFile))
+}
+
+// timeline creates a snapshot now, one a day ago, one ten days ago and an
+// old one with another name, and returns their identifiers in that order.
+func timeline(t *testing.T) (*repository.Repository, *appcontext.AppContext, []objects.MAC) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	ctx.ConfigDir = t.TempDir()
+
+	files := []ptesting.MockFile{ptesting.NewMockFile("file.txt", 0644, "content")}
+	now := time.Now()
+	var ids []objects.MAC
+	for _, opts := range [][]ptesting.TestingOptions{
+		{ptesting.WithTimestamp(now)},
+		{ptesting.WithTimestamp(now.Add(-24 * time.Hour))},
+		{ptesting.WithTimestamp(now.Add(-10 * 24 * time.Hour))},
+		{ptesting.WithTimestamp(now.Add(-10 * 24 * time.Hour)), ptesting.WithName("other")},
+	} {
+		snap := ptesting.GenerateSnapshot(t, repo, files, opts...)
+		ids = append(ids, snap.Header.Identifier)
+		snap.Close()
+	}
+	require.NoError(t, repo.RebuildState())
+	return repo, ctx, ids
+}
+
+func TestFromConfig(t *testing.T) {
+	config := map[string]string{"location": "fs:///var/backups", Key: "weekly"}
+	require.Equal(t, "weekly", FromConfig(config))
+	require.Equal(t, map[string]string{"location": "fs:///var/backups"}, config)
+
+	require.Empty(t, FromConfig(map[string]string{"location": "fs:///var/backups"}))
+}
+
+func TestLoad(t *testing.T) {
+	configDir := t.TempDir()
+	ctx := &appcontext.AppContext{ConfigDir: configDir}
+
+	_, err := Load(configDir, "missing")
+	require.ErrorContains(t, err, `policy "missing" not found`)
+
+	addPolicy(t, ctx, "empty", nil)
+	_, err = Load(configDir, "empty")
+	require.ErrorContains(t, err, "not going to prune everything")
+
+	addPolicy(t, ctx, "daily", map[string]string{"days": "7", "per-day": "1"})
+	opts, err := Load(configDir, "daily")
+	require.NoError(t, err)
+	require.Equal(t, 7, opts.Periods.Day.Keep)
+	require.Equal(t, 1, opts.Periods.Day.Cap)
+}
+
+func TestEvaluate(t *testing.T) {
+	repo, ctx, ids := timeline(t)
+	addPolicy(t, ctx, "recent", map[string]string{"name": "test_backup", "days": "2"})
+
+	opts, err := Load(ctx.ConfigDir, "recent")
+	require.NoError(t, err)
+	decisions, err := Evaluate(repo, opts)
+	require.NoError(t, err)
+	require.Len(t, decisions, 4)
+
+	byID := make(map[objects.MAC]Decision)
+	for i, d := range decisions {
+		if i > 0 {
+			require.False(t, d.Timestamp.After(decisions[i-1].Timestamp), "newest first")
+		}
+		byID[d.ID] = d
+	}
+
+	kept := byID[ids[0]]
+	require.False(t, kept.Delete())
+	require.Equal(t, "day", kept.Reason.Rule)
+	require.Equal(t, kept.Timestamp.UTC().Format("2006-01-02"), kept.Reason.Bucket)
+	require.Equal(t, "day:"+kept.Reason.Bucket+" #1", kept.Explain())
+	require.False(t, byID[ids[1]].Delete())
+
+	old := byID[ids[2]]
+	require.True(t, old.Delete())
+	require.Equal(t, "outside retention windows", old.Explain())
+
+	other := byID[ids[3]]
+	require.False(t, other.Matched)
+	require.False(t, other.Delete())
+	require.Equal(t, "not matched by the filters", other.Explain())
+}
+
+func TestEvaluateCheckpoint(t *testing.T) {
+	repo, ctx, ids := timeline(t)
+	addPolicy(t, ctx, "recent", map[string]string{"days": "2"})
+
+	files := []ptesting.MockFile{ptesting.NewMockFile("file.txt", 0644, "content")}
+	snap := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithTags(utils.CheckpointTag))
+	checkpoint := snap.Header.Identifier
+	snap.Close()
+	require.NoError(t, repo.RebuildState())
+
+	opts, err := Load(ctx.ConfigDir, "recent")
+	require.NoError(t, err)
+	decisions, err := Evaluate(repo, opts)
+	require.NoError(t, err)
+	require.Len(t, decisions, 5)
+	require.Empty(t, opts.Filters.IgnoreTags)
+
+	for _, d := range decisions {
+		switch d.ID {
+		case checkpoint:
+			require.False(t, d.Matched)
+			require.Equal(t, "checkpoint of a backup in progress", d.Explain())
+		case ids[0]:
+			// the checkpoint doesn't take the place of the newest snapshot
+			require.Equal(t, "day:"+d.Reason.Bucket+" #1", d.Explain())
+		}
+	}
+
+	opts.Filters.Tags = []string{utils.CheckpointTag}
+	decisions, err = Evaluate(repo, opts)
+	require.NoError(t, err)
+	for _, d := range decisions {
+		require.Equal(t, d.ID == checkpoint, d.Matched)
+	}
+}
+
+func TestApply(t *testing.T) {
+	repo, ctx, ids := timeline(t)
+	addPolicy(t, ctx, "recent", map[string]string{"name": "test_backup", "days": "2"})
+
+	removed, err := Apply(ctx, repo, "recent")
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+
+	require.NoError(t, repo.RebuildState())
+	remaining := make(map[objects.MAC]struct{})
+	for id, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		remaining[id] = struct{}{}
+	}
+	require.Len(t, remaining, 3)
+	require.NotContains(t, remaining, ids[2])
+
+	_, err = Apply(ctx, repo, "missing")
+	require.ErrorContains(t, err, "not found")
+}
+
+func TestApplyHeld(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	ctx.ConfigDir = t.TempDir()
+	addPolicy(t, ctx, "recent", map[string]string{"days": "2"})
+
+	files := []ptesting.MockFile{ptesting.NewMockFile("file.txt", 0644, "content")}
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	var ids []objects.MAC
+	for _, opts := range [][]ptesting.TestingOptions{
+		{ptesting.WithTimestamp(old), ptesting.WithHold("case 42", time.Time{})},
+		{ptesting.WithTimestamp(old), ptesting.WithHold("expired", old)},
+		{ptesting.WithTimestamp(old)},
+	} {
+		snap := ptesting.GenerateSnapshot(t, repo, files, opts...)
+		ids = append(ids, snap.Header.Identifier)
+		snap.Close()
+	}
+	require.NoError(t, repo.RebuildState())
+
+	opts, err := Load(ctx.ConfigDir, "recent")
+	require.NoError(t, err)
+	decisions, err := Evaluate(repo, opts)
+	require.NoError(t, err)
+	for _, d := range decisions {
+		if d.ID == ids[0] {
+			require.True(t, d.Held())
+			require.False(t, d.Delete())
+			require.Equal(t, "held: case 42", d.Explain())
+		} else {
+			require.False(t, d.Held())
+			require.True(t, d.Delete())
+		}
+	}
+
+	removed, err := Apply(ctx, repo, "recent")
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+
+	require.NoError(t, repo.RebuildState())
+	var remaining []objects.MAC
+	for id, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		remaining = append(remaining, id)
+	}
+	require.Equal(t, []objects.MAC{ids[0]}, remaining)
+}
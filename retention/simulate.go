@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package retention
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// MaxSimulatedBackups bounds the number of backups of a simulation.
+const MaxSimulatedBackups = 1_000_000
+
+// Timeline describes the backups of a simulation: one every Every over
+// Span, up to End.  The policy is applied every Prune, after each backup
+// if zero, and the surviving snapshots are sampled every Report.
+type Timeline struct {
+	End    time.Time
+	Every  time.Duration
+	Span   time.Duration
+	Prune  time.Duration
+	Report time.Duration
+}
+
+// Sample describes the snapshots surviving at a point of a simulation.
+type Sample struct {
+	At         time.Time
+	Kept       int
+	Oldest     time.Time
+	LargestGap time.Duration
+}
+
+// Tier is a run of consecutive surviving snapshots kept by the same rule.
+type Tier struct {
+	Rule       string
+	Kept       int
+	Newest     time.Time
+	Oldest     time.Time
+	LargestGap time.Duration
+}
+
+// Simulation is the outcome of a policy applied to a timeline.
+type Simulation struct {
+	Backups int
+	Samples []Sample
+	Final   Sample
+
+	// Tiers are the surviving snapshots at the end, newest first.
+	Tiers []Tier
+}
+
+// Simulate applies the retention periods of opts to the backups of tl, the
+// way prune does.  The filters of opts are ignored: the simulated
+// snapshots have none of the attributes they select on.
+func Simulate(opts *locate.LocateOptions, tl *Timeline) (*Simulation, error) {
+	if !opts.HasPeriods() {
+		return nil, fmt.Errorf("no retention period to simulate")
+	}
+	if tl.Every <= 0 || tl.Span < tl.Every {
+		return nil, fmt.Errorf("the timeline must be longer than the interval between backups")
+	}
+	if tl.Span/tl.Every >= MaxSimulatedBackups {
+		return nil, fmt.Errorf("more than %d backups to simulate", MaxSimulatedBackups)
+	}
+
+	periods := locate.NewDefaultLocateOptions()
+	periods.Periods = opts.Periods
+
+	end := tl.End.UTC()
+	start := end.Add(-tl.Span)
+	sim := &Simulation{}
+
+	var items []locate.Item
+	var last time.Time
+	nextPrune := start
+	nextReport := start.Add(tl.Report)
+	for at := start; !at.After(end); at = at.Add(tl.Every) {
+		sim.Backups++
+		items = append(items, simulatedItem(sim.Backups, at))
+		last = at
+
+		if !at.Before(nextPrune) {
+			items, _ = prune(periods, items, at)
+			nextPrune = at.Add(tl.Prune)
+		}
+		if tl.Report > 0 && !at.Before(nextReport) {
+			sim.Samples = append(sim.Samples, sample(items, at))
+			for !at.Before(nextReport) {
+				nextReport = nextReport.Add(tl.Report)
+			}
+		}
+	}
+
+	items, reasons := prune(periods, items, last)
+	sim.Final = sample(items, last)
+	sim.Tiers = tiers(items, reasons)
+	return sim, nil
+}
+
+// simulatedItem returns the n-th snapshot of a simulation.
+func simulatedItem(n int, at time.Time) locate.Item {
+	var id objects.MAC
+	binary.BigEndian.PutUint64(id[:], uint64(n))
+	return locate.Item{ItemID: id, Timestamp: at}
+}
+
+// prune returns the items, oldest first, the policy keeps at now.
+func prune(opts *locate.LocateOptions, items []locate.Item, now time.Time) ([]locate.Item, map[objects.MAC]locate.Reason) {
+	kept, reasons := opts.Match(items, now)
+	survivors := items[:0]
+	for _, it := range items {
+		if _, found := kept[it.ItemID]; found {
+			survivors = append(survivors, it)
+		}
+	}
+	return survivors, reasons
+}
+
+func sample(items []locate.Item, at time.Time) Sample {
+	s := Sample{At: at, Kept: len(items)}
+	if len(items) == 0 {
+		return s
+	}
+	s.Oldest = items[0].Timestamp
+	for i := 1; i < len(items); i++ {
+		s.LargestGap = max(s.LargestGap, items[i].Timestamp.Sub(items[i-1].Timestamp))
+	}
+	return s
+}
+
+// tiers groups the items, oldest first, by the rule keeping them.
+func tiers(items []locate.Item, reasons map[objects.MAC]locate.Reason) []Tier {
+	var out []Tier
+	for i := len(items) - 1; i >= 0; i-- {
+		it := items[i]
+		rule := reasons[it.ItemID].Rule
+		if len(out) == 0 || out[len(out)-1].Rule != rule {
+			out = append(out, Tier{Rule: rule, Newest: it.Timestamp})
+		}
+		t := &out[len(out)-1]
+		if t.Kept != 0 {
+			t.LargestGap = max(t.LargestGap, t.Oldest.Sub(it.Timestamp))
+		}
+		t.Oldest = it.Timestamp
+		t.Kept++
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/stretchr/testify/require"
+)
+
+const day = 24 * time.Hour
+
+// periods keeps a snapshot a day for days and a snapshot a week for weeks.
+func periods(days, weeks int) *locate.LocateOptions {
+	opts := locate.NewDefaultLocateOptions()
+	opts.Periods.Day = locate.LocatePeriod{Keep: days, Cap: 1}
+	if weeks != 0 {
+		opts.Periods.Week = locate.LocatePeriod{Keep: weeks, Cap: 1}
+	}
+	return opts
+}
+
+func TestSimulateRejects(t *testing.T) {
+	end := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	opts := locate.NewDefaultLocateOptions()
+	opts.Filters.Name = "test"
+	_, err := Simulate(opts, &Timeline{End: end, Every: day, Span: 30 * day})
+	require.ErrorContains(t, err, "no retention period")
+
+	_, err = Simulate(periods(7, 0), &Timeline{End: end, Every: day, Span: time.Hour})
+	require.ErrorContains(t, err, "longer than the interval")
+
+	_, err = Simulate(periods(7, 0), &Timeline{End: end, Every: time.Second, Span: 365 * day})
+	require.ErrorContains(t, err, "backups to simulate")
+}
+
+func TestSimulateDaily(t *testing.T) {
+	end := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	sim, err := Simulate(periods(7, 0), &Timeline{End: end, Every: day, Span: 30 * day, Report: 10 * day})
+	require.NoError(t, err)
+
+	require.Equal(t, 31, sim.Backups)
+	require.Equal(t, Sample{At: end, Kept: 7, Oldest: end.Add(-6 * day), LargestGap: day}, sim.Final)
+	require.Equal(t, []Tier{{Rule: "day", Kept: 7, Newest: end, Oldest: end.Add(-6 * day), LargestGap: day}}, sim.Tiers)
+
+	require.Len(t, sim.Samples, 3)
+	for i, s := range sim.Samples {
+		require.Equal(t, end.Add(time.Duration(i-2)*10*day), s.At)
+		require.Equal(t, 7, s.Kept)
+	}
+}
+
+func TestSimulateTiers(t *testing.T) {
+	// a saturday, the weeks of the policy start on mondays
+	end := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	sim, err := Simulate(periods(3, 4), &Timeline{End: end, Every: day, Span: 60 * day})
+	require.NoError(t, err)
+	require.Empty(t, sim.Samples)
+
+	// the latest backup of the week covers the week in both windows
+	require.Equal(t, "day", sim.Tiers[0].Rule)
+	require.Equal(t, 3, sim.Tiers[0].Kept)
+	require.Equal(t, "week", sim.Tiers[1].Rule)
+	require.Equal(t, 3, sim.Tiers[1].Kept)
+	require.Equal(t, 7*day, sim.Tiers[1].LargestGap)
+	require.Equal(t, 6, sim.Final.Kept)
+	require.Equal(t, sim.Tiers[1].Oldest, sim.Final.Oldest)
+}
+
+func TestSimulatePruneEvery(t *testing.T) {
+	end := time.Date(2026, 10, 17, 23, 0, 0, 0, time.UTC)
+	tl := &Timeline{End: end, Every: time.Hour, Span: 3 * day, Prune: day, Report: time.Hour}
+	sim, err := Simulate(periods(1, 0), tl)
+	require.NoError(t, err)
+
+	// the hourly backups accumulate between two prunes
+	peak := 0
+	for _, s := range sim.Samples {
+		peak = max(peak, s.Kept)
+	}
+	require.Equal(t, 24, peak)
+	require.Equal(t, 1, sim.Final.Kept)
+	require.Equal(t, end, sim.Final.Oldest)
+}
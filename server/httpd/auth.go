@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package httpd
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+type Scope string
+
+const (
+	// ScopeRead allows to open the repository, list and get resources.
+	ScopeRead Scope = "read"
+	// ScopeAppend allows to put resources that don't exist yet, and to
+	// delete locks which every writer has to release.
+	ScopeAppend Scope = "append"
+	// ScopeDelete allows to delete and overwrite resources.
+	ScopeDelete Scope = "delete"
+)
+
+// Client is an identity allowed to connect, either with a bearer token or
+// with a client certificate.
+type Client struct {
+	Name    string  `yaml:"name"`
+	Token   string  `yaml:"token,omitempty"`
+	Subject string  `yaml:"subject,omitempty"`
+	Scopes  []Scope `yaml:"scopes"`
+}
+
+func (c *Client) Allowed(scope Scope) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+func (c *Client) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("missing client name")
+	}
+	if len(c.Scopes) == 0 {
+		return fmt.Errorf("client %q: no scopes", c.Name)
+	}
+	for _, scope := range c.Scopes {
+		switch scope {
+		case ScopeRead, ScopeAppend, ScopeDelete:
+		default:
+			return fmt.Errorf("client %q: invalid scope %q", c.Name, scope)
+		}
+	}
+	return nil
+}
+
+// Auth is the list of clients allowed to use the server.
+type Auth struct {
+	ClientCA     string   `yaml:"client_ca,omitempty"`
+	Tokens       []Client `yaml:"tokens,omitempty"`
+	Certificates []Client `yaml:"certificates,omitempty"`
+}
+
+func ParseAuth(data []byte) (*Auth, error) {
+	var auth Auth
+	if err := yaml.Unmarshal(data, &auth); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	check := func(c *Client) error {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		if _, ok := seen[c.Name]; ok {
+			return fmt.Errorf("duplicate client %q", c.Name)
+		}
+		seen[c.Name] = struct{}{}
+		return nil
+	}
+
+	for i := range auth.Tokens {
+		if err := check(&auth.Tokens[i]); err != nil {
+			return nil, err
+		}
+		if auth.Tokens[i].Token == "" {
+			return nil, fmt.Errorf("client %q: missing token", auth.Tokens[i].Name)
+		}
+	}
+	for i := range auth.Certificates {
+		if err := check(&auth.Certificates[i]); err != nil {
+			return nil, err
+		}
+		if auth.Certificates[i].Subject == "" {
+			return nil, fmt.Errorf("client %q: missing subject", auth.Certificates[i].Name)
+		}
+	}
+
+	if len(auth.Certificates) != 0 && auth.ClientCA == "" {
+		return nil, fmt.Errorf("certificates require a client_ca")
+	}
+	if len(auth.Tokens) == 0 && len(auth.Certificates) == 0 {
+		return nil, fmt.Errorf("no clients defined")
+	}
+
+	return &auth, nil
+}
+
+// LoadAuth reads the clients from a file, the path of the client CA being
+// relative to it.
+func LoadAuth(path string) (*Auth, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := ParseAuth(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if auth.ClientCA != "" && !filepath.IsAbs(auth.ClientCA) {
+		auth.ClientCA = filepath.Join(filepath.Dir(path), auth.ClientCA)
+	}
+	return auth, nil
+}
+
+// TLSConfig returns the configuration to verify the client certificates,
+// or nil if there are none to verify.
+func (a *Auth) TLSConfig() (*tls.Config, error) {
+	if a.ClientCA == "" {
+		return nil, nil
+	}
+
+	pem, err := os.ReadFile(a.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", a.ClientCA)
+	}
+
+	return &tls.Config{
+		ClientCAs: pool,
+		// token clients don't present a certificate
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+// Authenticate returns the client that issued the request, or nil.
+func (a *Auth) Authenticate(r *http.Request) *Client {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for i := range a.Certificates {
+			if a.Certificates[i].Subject == subject {
+				return &a.Certificates[i]
+			}
+		}
+		return nil
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return nil
+	}
+
+	var client *Client
+	for i := range a.Tokens {
+		// go through all the tokens to not leak which one matched
+		if subtle.ConstantTimeCompare([]byte(a.Tokens[i].Token), []byte(token)) == 1 {
+			client = &a.Tokens[i]
+		}
+	}
+	return client
+}
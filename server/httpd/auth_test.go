@@ -0,0 +1,319 @@
+package httpd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+const testAuth = `
+tokens:
+  - name: reader
+    token: r-secret
+    scopes: [read]
+  - name: writer
+    token: w-secret
+    scopes: [read, append]
+  - name: admin
+    token: a-secret
+    scopes: [read, append, delete]
+`
+
+func mustParseAuth(t *testing.T, data string) *Auth {
+	t.Helper()
+	auth, err := ParseAuth([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseAuth: %v", err)
+	}
+	return auth
+}
+
+func newAuthMux(store *fakeStore, auth *Auth, accessLog *bytes.Buffer) http.Handler {
+	s := &server{store: store, auth: auth}
+	if accessLog != nil {
+		s.accessLog = accessLog
+	}
+	return s.handler()
+}
+
+func doRequest(h http.Handler, method, path, token string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestParseAuth(t *testing.T) {
+	auth := mustParseAuth(t, testAuth)
+	if len(auth.Tokens) != 3 {
+		t.Fatalf("got %d tokens, want 3", len(auth.Tokens))
+	}
+	if !auth.Tokens[1].Allowed(ScopeAppend) || auth.Tokens[1].Allowed(ScopeDelete) {
+		t.Fatalf("unexpected scopes for writer: %v", auth.Tokens[1].Scopes)
+	}
+}
+
+func TestParseAuth_Errors(t *testing.T) {
+	cases := map[string]string{
+		"no clients":      `client_ca: ca.pem`,
+		"missing name":    `tokens: [{token: x, scopes: [read]}]`,
+		"missing token":   `tokens: [{name: x, scopes: [read]}]`,
+		"no scopes":       `tokens: [{name: x, token: x}]`,
+		"invalid scope":   `tokens: [{name: x, token: x, scopes: [write]}]`,
+		"duplicate":       `tokens: [{name: x, token: x, scopes: [read]}, {name: x, token: y, scopes: [read]}]`,
+		"missing subject": `{client_ca: ca.pem, certificates: [{name: x, scopes: [read]}]}`,
+		"missing ca":      `certificates: [{name: x, subject: host, scopes: [read]}]`,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseAuth([]byte(data)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadAuth_RelativeClientCA(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.yaml")
+	data := "client_ca: ca.pem\ncertificates: [{name: x, subject: host, scopes: [read]}]\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := LoadAuth(path)
+	if err != nil {
+		t.Fatalf("LoadAuth: %v", err)
+	}
+	if auth.ClientCA != filepath.Join(dir, "ca.pem") {
+		t.Fatalf("client_ca = %q", auth.ClientCA)
+	}
+
+	if _, err := LoadAuth(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestAuth_Unauthorized(t *testing.T) {
+	h := newAuthMux(&fakeStore{}, mustParseAuth(t, testAuth), nil)
+
+	for _, token := range []string{"", "bogus"} {
+		rec := doRequest(h, http.MethodGet, "/", token, nil)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("token %q: status = %d", token, rec.Code)
+		}
+		if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Fatalf("missing WWW-Authenticate header")
+		}
+	}
+}
+
+func TestAuth_Scopes(t *testing.T) {
+	mac := makeMAC(0x42)
+	packfile := "/resources/packfiles/" + hex.EncodeToString(mac[:])
+	lock := "/resources/locks/" + hex.EncodeToString(mac[:])
+
+	cases := []struct {
+		token  string
+		method string
+		path   string
+		want   int
+	}{
+		{"r-secret", http.MethodGet, "/", http.StatusOK},
+		{"r-secret", http.MethodGet, packfile, http.StatusOK},
+		{"r-secret", http.MethodPut, packfile, http.StatusForbidden},
+		{"r-secret", http.MethodDelete, lock, http.StatusForbidden},
+		{"w-secret", http.MethodPut, packfile, http.StatusOK},
+		{"w-secret", http.MethodDelete, packfile, http.StatusForbidden},
+		{"w-secret", http.MethodDelete, lock, http.StatusOK},
+		{"a-secret", http.MethodDelete, packfile, http.StatusOK},
+	}
+	for _, c := range cases {
+		h := newAuthMux(&fakeStore{getErr: errors.New("not found")}, mustParseAuth(t, testAuth), nil)
+		if c.method == http.MethodGet {
+			h = newAuthMux(&fakeStore{}, mustParseAuth(t, testAuth), nil)
+		}
+		rec := doRequest(h, c.method, c.path, c.token, nil)
+		if rec.Code != c.want {
+			t.Errorf("%s %s %s: status = %d, want %d", c.token, c.method, c.path, rec.Code, c.want)
+		}
+	}
+}
+
+func TestAuth_AppendOnlyDoesNotOverwrite(t *testing.T) {
+	mac := makeMAC(0x42)
+	store := &fakeStore{
+		getData: map[storage.StorageResource]map[objects.MAC][]byte{
+			storage.StorageResourcePackfile: {mac: []byte("original")},
+		},
+	}
+	h := newAuthMux(store, mustParseAuth(t, testAuth), nil)
+	path := "/resources/packfiles/" + hex.EncodeToString(mac[:])
+
+	rec := doRequest(h, http.MethodPut, path, "w-secret", []byte("replaced"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if store.lastPutData != nil {
+		t.Fatalf("existing resource was overwritten with %q", store.lastPutData)
+	}
+
+	// clients allowed to delete may overwrite
+	rec = doRequest(h, http.MethodPut, path, "a-secret", []byte("replaced"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if string(store.lastPutData) != "replaced" {
+		t.Fatalf("lastPutData = %q", store.lastPutData)
+	}
+}
+
+func TestAuth_NoDeleteStillApplies(t *testing.T) {
+	mac := makeMAC(0x42)
+	s := &server{store: &fakeStore{}, auth: mustParseAuth(t, testAuth), noDelete: true}
+	rec := doRequest(s.handler(), http.MethodDelete,
+		"/resources/packfiles/"+hex.EncodeToString(mac[:]), "a-secret", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var log bytes.Buffer
+	h := newAuthMux(&fakeStore{openConfig: []byte("config")}, mustParseAuth(t, testAuth), &log)
+
+	doRequest(h, http.MethodGet, "/", "r-secret", nil)
+	doRequest(h, http.MethodGet, "/", "bogus", nil)
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines: %q", len(lines), log.String())
+	}
+
+	fields := strings.Fields(lines[0])
+	if len(fields) != 7 {
+		t.Fatalf("unexpected line %q", lines[0])
+	}
+	if _, err := time.Parse(time.RFC3339, fields[0]); err != nil {
+		t.Fatalf("bad timestamp in %q: %v", lines[0], err)
+	}
+	if got := strings.Join(fields[2:], " "); got != "reader GET / 200 6" {
+		t.Fatalf("line = %q", lines[0])
+	}
+	if got := strings.Join(strings.Fields(lines[1])[2:6], " "); got != "- GET / 401" {
+		t.Fatalf("line = %q", lines[1])
+	}
+}
+
+// writeCert generates a certificate signed by parent, or self-signed if
+// parent is nil, and returns it along with its key.
+func writeCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestAuth_ClientCertificate(t *testing.T) {
+	ca, caKey, caPEM := writeCert(t, "test ca", true, nil, nil)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := mustParseAuth(t, `
+client_ca: `+filepath.Join(dir, "ca.pem")+`
+certificates:
+  - name: backup-host
+    subject: host.example.org
+    scopes: [read]
+`)
+	tlsConfig, err := auth.TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+
+	var log bytes.Buffer
+	s := &server{store: &fakeStore{openConfig: []byte("config")}, auth: auth, accessLog: &log}
+	ts := httptest.NewUnstartedServer(s.handler())
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+
+	get := func(cn string) int {
+		t.Helper()
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		if cn != "" {
+			cert, key, _ := writeCert(t, cn, false, ca, caKey)
+			transport.TLSClientConfig.Certificates = []tls.Certificate{{
+				Certificate: [][]byte{cert.Raw},
+				PrivateKey:  key,
+			}}
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(ts.URL + "/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get("host.example.org"); code != http.StatusOK {
+		t.Fatalf("known subject: status = %d", code)
+	}
+	if code := get("other.example.org"); code != http.StatusUnauthorized {
+		t.Fatalf("unknown subject: status = %d", code)
+	}
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Fatalf("no certificate: status = %d", code)
+	}
+
+	// waits for the handlers to write the access log
+	ts.Close()
+	if !strings.Contains(log.String(), " backup-host GET / 200 ") {
+		t.Fatalf("access log = %q", log.String())
+	}
+}
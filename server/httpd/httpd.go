@@ -8,21 +8,174 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/server/worm"
 )
 
 var ErrInvalidResourceType = fmt.Errorf("invalid resource type")
 var ErrInvalidMAC = fmt.Errorf("invalid MAC")
 var ErrInvalidRange = fmt.Errorf("invalid range")
 
+// Options tune the behaviour of the server.
+type Options struct {
+	NoDelete bool
+	Cert     string
+	Key      string
+
+	// Auth, if set, restricts the access to the listed clients.
+	Auth *Auth
+	// AccessLog, if set, receives a line per request.
+	AccessLog io.Writer
+}
+
 type server struct {
 	store    storage.Store
 	noDelete bool
+	auth     *Auth
+
+	// with a WORM policy, the client presenting its admin token
+	worm  *worm.Policy
+	admin *Client
+
+	logMu     sync.Mutex
+	accessLog io.Writer
+}
+
+type clientKey struct{}
+
+// client returns the client that issued the request, or nil if the server
+// doesn't require authentication.
+func client(r *http.Request) *Client {
+	c, _ := r.Context().Value(clientKey{}).(*Client)
+	return c
+}
+
+// requiredScope returns the scope a client needs for a request.  Locks are
+// deleted by the writers once done, so that doesn't require the delete
+// scope.
+func requiredScope(r *http.Request) Scope {
+	switch r.Method {
+	case http.MethodPut:
+		return ScopeAppend
+	case http.MethodDelete:
+		if r.PathValue("resource") == "locks" {
+			return ScopeAppend
+		}
+		return ScopeDelete
+	default:
+		return ScopeRead
+	}
+}
+
+// authenticate rejects the requests that don't come from a known client.
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c *Client
+		if s.worm != nil {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if found && s.worm.IsAdmin(token) {
+				c = s.admin
+			}
+		}
+
+		if c == nil {
+			if s.auth == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if c = s.auth.Authenticate(r); c == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if lw, ok := w.(*loggingWriter); ok {
+			lw.client = c.Name
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, c)))
+	})
+}
+
+// authorize checks that the client has the scope required by the request.
+// The WORM policy is never listed, only the admin may read it, so that
+// maintenance knows the retention.
+func (s *server) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := client(r)
+		if c != nil && !c.Allowed(requiredScope(r)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.PathValue("resource") == "packfiles" {
+			admin := s.admin != nil && c == s.admin && r.Method == http.MethodGet
+			if mac, err := getMac(r); err == nil && mac == worm.PolicyID && !admin {
+				http.Error(w, worm.ErrReserved.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+type loggingWriter struct {
+	http.ResponseWriter
+
+	client string
+	status int
+	size   int64
+}
+
+func (w *loggingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ReadFrom keeps the responses served with sendfile when possible.
+func (w *loggingWriter) ReadFrom(rd io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, rd)
+	w.size += n
+	return n, err
+}
+
+func (w *loggingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += int64(n)
+	return n, err
+}
+
+// logAccess writes a line per request to the access log:
+//
+//	<time> <remote address> <client> <method> <path> <status> <bytes>
+func (s *server) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &loggingWriter{ResponseWriter: w, client: "-"}
+		next.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		s.logMu.Lock()
+		defer s.logMu.Unlock()
+		fmt.Fprintf(s.accessLog, "%s %s %s %s %s %d %d\n",
+			time.Now().UTC().Format(time.RFC3339), r.RemoteAddr, lw.client,
+			r.Method, r.URL.Path, lw.status, lw.size)
+	})
 }
 
 func (s *server) openRepository(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +199,11 @@ func (s *server) listResource(w http.ResponseWriter, r *http.Request) {
 	if indexes, err := s.store.List(r.Context(), typ); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	} else {
+		if typ == storage.StorageResourcePackfile {
+			indexes = slices.DeleteFunc(indexes, func(mac objects.MAC) bool {
+				return mac == worm.PolicyID
+			})
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(indexes)
 	}
@@ -64,27 +222,92 @@ func (s *server) getResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rg, err := getRange(r)
+	// resources are addressed by their MAC, it is a strong validator
+	etag := `"` + hex.EncodeToString(mac[:]) + `"`
+	w.Header().Set("ETag", etag)
+	if !checkPreconditions(w, r, etag) {
+		return
+	}
+
+	if legacyRange(r) {
+		rg, err := getRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rd, err := s.store.Get(r.Context(), typ, mac, rg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rd.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if size := resourceSize(rd); rg == nil && size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		stream(w, rd, http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	brg, err := parseByteRange(r.Header.Get("Range"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if h := r.Header.Get("If-Range"); h != "" && h != etag {
+		brg = nil
+	}
 
-	if rd, err := s.store.Get(r.Context(), typ, mac, rg); err != nil {
+	rd, err := s.store.Get(r.Context(), typ, mac, nil)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
-	} else {
-		defer rd.Close()
+	}
+	defer func() { rd.Close() }()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
 
-		data, err := io.ReadAll(rd)
+	// without the size the range can't be resolved, serve it all
+	size := resourceSize(rd)
+	if brg == nil || size < 0 {
+		if size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		stream(w, rd, http.StatusOK)
+		return
+	}
+
+	offset, length, ok := brg.resolve(size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, ErrInvalidRange.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	var body io.Reader
+	if seeker, ok := rd.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = io.LimitReader(rd, length)
+	} else {
+		// let the store fetch the range rather than skipping through
+		rd.Close()
+		rd, err = s.store.Get(r.Context(), typ, mac, &storage.Range{Offset: uint64(offset), Length: uint32(length)})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Write(data)
+		body = rd
 	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	stream(w, body, http.StatusPartialContent)
 }
 
 func (s *server) putResource(w http.ResponseWriter, r *http.Request) {
@@ -100,6 +323,23 @@ func (s *server) putResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if typ != storage.StorageResourceLock {
+		// In WORM mode, existing resources are never replaced.
+		if s.worm != nil && s.exists(r.Context(), typ, mac) {
+			http.Error(w, "resource already exists", http.StatusForbidden)
+			return
+		}
+
+		// Clients without the delete scope may only add resources, not
+		// replace existing ones.  Resources are addressed by their MAC so
+		// a client legitimately putting one again is sending the same
+		// content.
+		if c := client(r); c != nil && !c.Allowed(ScopeDelete) && s.exists(r.Context(), typ, mac) {
+			io.Copy(io.Discard, r.Body)
+			return
+		}
+	}
+
 	if _, err = s.store.Put(r.Context(), typ, mac, r.Body); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -123,34 +363,111 @@ func (s *server) deleteResource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.worm != nil && typ != storage.StorageResourceLock {
+		if client(r) != s.admin {
+			http.Error(w, "deleting requires the admin token", http.StatusForbidden)
+			return
+		}
+		if err := s.checkRetention(r.Context(), typ, mac); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
 	if err := s.store.Delete(r.Context(), typ, mac); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func Server(ctx context.Context, repo *repository.Repository, addr string, noDelete bool, cert string, key string) error {
-	s := server{
-		store:    repo.Store(),
-		noDelete: noDelete,
+func (s *server) exists(ctx context.Context, typ storage.StorageResource, mac objects.MAC) bool {
+	rd, err := s.store.Get(ctx, typ, mac, &storage.Range{Offset: 0, Length: 1})
+	if err != nil {
+		return false
+	}
+	rd.Close()
+	return true
+}
+
+// checkRetention returns an error if the WORM policy forbids to delete the
+// resource yet.  Resources whose age can't be known are kept.
+func (s *server) checkRetention(ctx context.Context, typ storage.StorageResource, mac objects.MAC) error {
+	rd, err := s.store.Get(ctx, typ, mac, nil)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	modTime, ok := resourceModTime(rd)
+	if !ok {
+		return fmt.Errorf("can't tell the age of the resource")
+	}
+	if !s.worm.Expired(modTime) {
+		return fmt.Errorf("resource is younger than the retention period of %s", s.worm.Retention)
+	}
+	return nil
+}
+
+func (s *server) setWORM(policy *worm.Policy) {
+	s.worm = policy
+	if policy != nil {
+		s.admin = &Client{
+			Name:   "admin",
+			Scopes: []Scope{ScopeRead, ScopeAppend, ScopeDelete},
+		}
 	}
+}
 
+func (s *server) handler() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /", s.openRepository)
+	mux.HandleFunc("GET /", s.authorize(s.openRepository))
+
+	mux.HandleFunc("GET /resources/{resource}", s.authorize(s.listResource))
+	mux.HandleFunc("GET /resources/{resource}/{mac}", s.authorize(s.getResource))
+	mux.HandleFunc("PUT /resources/{resource}/{mac}", s.authorize(s.putResource))
+	mux.HandleFunc("DELETE /resources/{resource}/{mac}", s.authorize(s.deleteResource))
+
+	handler := s.authenticate(mux)
+	if s.accessLog != nil {
+		handler = s.logAccess(handler)
+	}
+	return handler
+}
+
+func Server(ctx context.Context, repo *repository.Repository, addr string, opts *Options) error {
+	s := &server{
+		store:     repo.Store(),
+		noDelete:  opts.NoDelete,
+		auth:      opts.Auth,
+		accessLog: opts.AccessLog,
+	}
 
-	mux.HandleFunc("GET /resources/{resource}", s.listResource)
-	mux.HandleFunc("GET /resources/{resource}/{mac}", s.getResource)
-	mux.HandleFunc("PUT /resources/{resource}/{mac}", s.putResource)
-	mux.HandleFunc("DELETE /resources/{resource}/{mac}", s.deleteResource)
+	// the policy is read from the store, whatever the options
+	policy, err := worm.Load(ctx, s.store)
+	if err != nil {
+		return err
+	}
+	s.setWORM(policy)
+
+	server := &http.Server{Addr: addr, Handler: s.handler()}
+	if s.auth != nil {
+		tlsConfig, err := s.auth.TLSConfig()
+		if err != nil {
+			return err
+		}
+		if tlsConfig != nil && (opts.Cert == "" || opts.Key == "") {
+			return fmt.Errorf("client certificates require a server certificate and key")
+		}
+		server.TLSConfig = tlsConfig
+	}
 
-	server := &http.Server{Addr: addr, Handler: mux}
 	go func() {
 		<-repo.AppContext().Done()
 		server.Shutdown(repo.AppContext().Context)
 	}()
 
-	if cert != "" && key != "" {
-		return server.ListenAndServeTLS(cert, key)
+	if opts.Cert != "" && opts.Key != "" {
+		return server.ListenAndServeTLS(opts.Cert, opts.Key)
 	}
 	return server.ListenAndServe()
 }
@@ -109,15 +109,9 @@ func makeMAC(first byte) objects.MAC {
 // newTestMux wires up the same routes as Server() but against the supplied
 // fakeStore so we can drive every handler via httptest without spinning up
 // a real http.Server.
-func newTestMux(store *fakeStore, noDelete bool) *http.ServeMux {
+func newTestMux(store *fakeStore, noDelete bool) http.Handler {
 	s := &server{store: store, noDelete: noDelete}
-	mux := http.NewServeMux()
-	mux.HandleFunc("GET /", s.openRepository)
-	mux.HandleFunc("GET /resources/{resource}", s.listResource)
-	mux.HandleFunc("GET /resources/{resource}/{mac}", s.getResource)
-	mux.HandleFunc("PUT /resources/{resource}/{mac}", s.putResource)
-	mux.HandleFunc("DELETE /resources/{resource}/{mac}", s.deleteResource)
-	return mux
+	return s.handler()
 }
 
 // ---------- pure parser helpers ----------
@@ -384,6 +378,8 @@ func TestGetResourceHandler_WithRange(t *testing.T) {
 	mux := newTestMux(store, false)
 
 	req := httptest.NewRequest(http.MethodGet, "/resources/packfiles/"+hex.EncodeToString(mac[:]), nil)
+	// the plakar http store sends bytes=<offset>-<offset+length>
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Range", "bytes=2-5")
 	rec := httptest.NewRecorder()
 	mux.ServeHTTP(rec, req)
@@ -429,6 +425,7 @@ func TestGetResourceHandler_BadRange(t *testing.T) {
 	mux := newTestMux(store, false)
 
 	req := httptest.NewRequest(http.MethodGet, "/resources/packfiles/"+hex.EncodeToString(mac[:]), nil)
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Range", "items=garbage")
 	rec := httptest.NewRecorder()
 	mux.ServeHTTP(rec, req)
@@ -622,7 +619,7 @@ func TestServer_StartServeAndShutdown(t *testing.T) {
 	errCh := make(chan error, 1)
 	go func() {
 		// noDelete=false, no TLS — exercises the plain ListenAndServe path.
-		errCh <- Server(ctx, repo, addr, false, "", "")
+		errCh <- Server(ctx, repo, addr, &Options{})
 	}()
 
 	// Wait until the server accepts connections, then make one request so the
@@ -664,7 +661,7 @@ func TestServer_TLSConfigErrors(t *testing.T) {
 	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
 
 	addr := freePort(t)
-	err := Server(ctx, repo, addr, false, "/nonexistent/cert.pem", "/nonexistent/key.pem")
+	err := Server(ctx, repo, addr, &Options{Cert: "/nonexistent/cert.pem", Key: "/nonexistent/key.pem"})
 	if err == nil {
 		t.Fatal("expected error from ListenAndServeTLS with missing cert/key")
 	}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package httpd
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacyRange tells whether the Range header of a request is to be read
+// with getRange.  The plakar http store sends its ranges as
+// bytes=<offset>-<offset+length> and expects a 200 response, it is told
+// apart from other clients by the JSON content type it sets on all of its
+// requests.
+func legacyRange(r *http.Request) bool {
+	return r.Header.Get("Content-Type") == "application/json"
+}
+
+// byteRange is a single range of a standard Range header.  A negative
+// start is a suffix range of -start bytes, a negative end extends to the
+// end of the resource.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+// parseByteRange parses a standard Range header, it returns nil for the
+// headers that must be ignored: other units and multiple ranges.
+func parseByteRange(s string) (*byteRange, error) {
+	spec, found := strings.CutPrefix(s, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	start, end, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return nil, ErrInvalidRange
+	}
+
+	if start == "" {
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, ErrInvalidRange
+		}
+		return &byteRange{start: -n, end: -1}, nil
+	}
+
+	rg := byteRange{end: -1}
+	var err error
+	if rg.start, err = strconv.ParseInt(start, 10, 64); err != nil || rg.start < 0 {
+		return nil, ErrInvalidRange
+	}
+	if end != "" {
+		if rg.end, err = strconv.ParseInt(end, 10, 64); err != nil || rg.end < rg.start {
+			return nil, ErrInvalidRange
+		}
+	}
+	return &rg, nil
+}
+
+// resolve returns the offset and length of the range within a resource of
+// the given size, or false if it doesn't overlap it.
+func (rg *byteRange) resolve(size int64) (int64, int64, bool) {
+	if rg.start < 0 {
+		n := min(-rg.start, size)
+		return size - n, n, n > 0
+	}
+	if rg.start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if rg.end >= 0 && rg.end < end {
+		end = rg.end
+	}
+	// the store ranges are limited to 4GB
+	return rg.start, min(end-rg.start+1, math.MaxUint32), true
+}
+
+// etagMatch tells whether an If-Match or If-None-Match header matches the
+// entity tag.  Resources never change so weak tags match as well.
+func etagMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions handles the conditional requests and returns false
+// if the response has already been sent.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if h := r.Header.Get("If-Match"); h != "" && !etagMatch(h, etag) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && etagMatch(h, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	}
+	return true
+}
+
+// resourceSize returns the size of a resource as returned by the store, or
+// -1 if it can't be known without reading it.
+func resourceSize(rd io.Reader) int64 {
+	switch rd := rd.(type) {
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := rd.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	case io.Seeker:
+		if size, err := rd.Seek(0, io.SeekEnd); err == nil {
+			if _, err := rd.Seek(0, io.SeekStart); err == nil {
+				return size
+			}
+		}
+	}
+	return -1
+}
+
+// resourceModTime returns the last modification time of a resource as
+// returned by the store, if it can be known.
+func resourceModTime(rd io.Reader) (time.Time, bool) {
+	if st, ok := rd.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if fi, err := st.Stat(); err == nil {
+			return fi.ModTime(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// stream copies the resource to the client.  The first chunk is read
+// before the headers are sent, so that a resource failing to read right
+// away is still reported as an error.
+func stream(w http.ResponseWriter, rd io.Reader, status int) {
+	var buf [32 * 1024]byte
+	n, err := io.ReadAtLeast(rd, buf[:], 1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		w.Header().Del("Content-Range")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	if _, err := w.Write(buf[:n]); err != nil {
+		return
+	}
+	io.Copy(w, rd)
+}
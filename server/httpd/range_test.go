@@ -0,0 +1,227 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+// seekReadCloser is a resource whose size can be known, like the files
+// returned by the fs store.
+type seekReadCloser struct {
+	*bytes.Reader
+}
+
+func (seekReadCloser) Close() error { return nil }
+
+func rangeRequest(h http.Handler, mac objects.MAC, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/resources/packfiles/"+hex.EncodeToString(mac[:]), nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestParseByteRange(t *testing.T) {
+	cases := map[string]*byteRange{
+		"":             nil,
+		"items=0-1":    nil,
+		"bytes=0-1,4-": nil,
+		"bytes=2-5":    {start: 2, end: 5},
+		"bytes=2-":     {start: 2, end: -1},
+		"bytes=-3":     {start: -3, end: -1},
+	}
+	for header, want := range cases {
+		got, err := parseByteRange(header)
+		if err != nil {
+			t.Fatalf("%q: err = %v", header, err)
+		}
+		if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+			t.Fatalf("%q: got %+v, want %+v", header, got, want)
+		}
+	}
+
+	for _, header := range []string{"bytes=5-2", "bytes=a-", "bytes=-0", "bytes=3"} {
+		if _, err := parseByteRange(header); err == nil {
+			t.Fatalf("%q: expected an error", header)
+		}
+	}
+}
+
+func TestByteRangeResolve(t *testing.T) {
+	cases := []struct {
+		rg             byteRange
+		offset, length int64
+		ok             bool
+	}{
+		{byteRange{2, 5}, 2, 4, true},
+		{byteRange{2, 100}, 2, 8, true},
+		{byteRange{2, -1}, 2, 8, true},
+		{byteRange{-3, -1}, 7, 3, true},
+		{byteRange{-30, -1}, 0, 10, true},
+		{byteRange{10, -1}, 0, 0, false},
+	}
+	for _, c := range cases {
+		offset, length, ok := c.rg.resolve(10)
+		if offset != c.offset || length != c.length || ok != c.ok {
+			t.Fatalf("%+v: got %d, %d, %v", c.rg, offset, length, ok)
+		}
+	}
+}
+
+func TestGetResourceHandler_StandardRange(t *testing.T) {
+	mac := makeMAC(0x42)
+	store := &fakeStore{getReader: seekReadCloser{bytes.NewReader([]byte("0123456789"))}}
+	mux := newTestMux(store, false)
+
+	rec := rangeRequest(mux, mac, map[string]string{"Range": "bytes=2-5"})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if rec.Body.String() != "2345" {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+	if got := rec.Header().Get("Content-Length"); got != "4" {
+		t.Fatalf("Content-Length = %q", got)
+	}
+}
+
+// sizedReadCloser is a resource whose size is known but which can't be
+// seeked, like the objects of a remote store.
+type sizedReadCloser struct {
+	io.Reader
+	fi os.FileInfo
+}
+
+func (r sizedReadCloser) Stat() (os.FileInfo, error) { return r.fi, nil }
+func (sizedReadCloser) Close() error                 { return nil }
+
+func TestGetResourceHandler_SuffixRangeFetchesFromStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "packfile")
+	if err := os.WriteFile(path, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := makeMAC(0x42)
+	store := &fakeStore{getReader: sizedReadCloser{bytes.NewReader([]byte("789")), fi}}
+	mux := newTestMux(store, false)
+
+	rec := rangeRequest(mux, mac, map[string]string{"Range": "bytes=-3"})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if store.lastGetRng == nil || store.lastGetRng.Offset != 7 || store.lastGetRng.Length != 3 {
+		t.Fatalf("range wrong: %+v", store.lastGetRng)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 7-9/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+	if rec.Body.String() != "789" {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+}
+
+func TestGetResourceHandler_RangeNotSatisfiable(t *testing.T) {
+	mac := makeMAC(0x42)
+	store := &fakeStore{getReader: seekReadCloser{bytes.NewReader([]byte("0123456789"))}}
+	mux := newTestMux(store, false)
+
+	rec := rangeRequest(mux, mac, map[string]string{"Range": "bytes=20-"})
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes */10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+}
+
+func TestGetResourceHandler_RangeUnknownSize(t *testing.T) {
+	mac := makeMAC(0x42)
+	store := &fakeStore{
+		getData: map[storage.StorageResource]map[objects.MAC][]byte{
+			storage.StorageResourcePackfile: {mac: []byte("0123456789")},
+		},
+	}
+	mux := newTestMux(store, false)
+
+	// the range can't be resolved, the whole resource is served
+	rec := rangeRequest(mux, mac, map[string]string{"Range": "bytes=-3"})
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Fatalf("unexpected Content-Length")
+	}
+}
+
+func TestGetResourceHandler_ContentLength(t *testing.T) {
+	mac := makeMAC(0x42)
+	store := &fakeStore{getReader: seekReadCloser{bytes.NewReader([]byte("0123456789"))}}
+	mux := newTestMux(store, false)
+
+	rec := rangeRequest(mux, mac, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Length"); got != "10" {
+		t.Fatalf("Content-Length = %q", got)
+	}
+	if got := rec.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Fatalf("Accept-Ranges = %q", got)
+	}
+}
+
+func TestGetResourceHandler_Conditional(t *testing.T) {
+	mac := makeMAC(0x42)
+	etag := `"` + hex.EncodeToString(mac[:]) + `"`
+	newMux := func() http.Handler {
+		return newTestMux(&fakeStore{getReader: seekReadCloser{bytes.NewReader([]byte("0123456789"))}}, false)
+	}
+
+	rec := rangeRequest(newMux(), mac, nil)
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Fatalf("ETag = %q", got)
+	}
+
+	rec = rangeRequest(newMux(), mac, map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("If-None-Match: status = %d", rec.Code)
+	}
+
+	rec = rangeRequest(newMux(), mac, map[string]string{"If-None-Match": `"other"`})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("If-None-Match other: status = %d", rec.Code)
+	}
+
+	rec = rangeRequest(newMux(), mac, map[string]string{"If-Match": `"other"`})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match: status = %d", rec.Code)
+	}
+
+	rec = rangeRequest(newMux(), mac, map[string]string{"Range": "bytes=0-1", "If-Range": etag})
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("If-Range: status = %d", rec.Code)
+	}
+
+	rec = rangeRequest(newMux(), mac, map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`})
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("If-Range other: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+// Package worm implements the write-once policy of the stores served by
+// plakar server.  The policy is kept in the store itself so that it keeps
+// being enforced when the server is restarted without it.
+package worm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/objects"
+)
+
+const VERSION = 1
+
+// PolicyID is the packfile holding the policy.  It isn't a valid packfile:
+// the server hides it from its clients and maintenance refuses to run on a
+// store holding it.
+var PolicyID = objects.MAC(sha256.Sum256([]byte("plakar worm policy")))
+
+var ErrEnabled = errors.New("store is in WORM mode")
+
+// ErrReserved is what plakar server answers to the clients reading the
+// policy other than its admin, and to all of them if it has no policy.
+var ErrReserved = errors.New("reserved resource")
+
+type Policy struct {
+	Version   int           `json:"version"`
+	Timestamp time.Time     `json:"timestamp"`
+	Retention time.Duration `json:"retention"`
+	// AdminToken is the SHA-256 of the token allowed to delete resources.
+	AdminToken []byte `json:"admin_token"`
+}
+
+// Load returns the policy of the store, or nil if it has none.
+func Load(ctx context.Context, store storage.Store) (*Policy, error) {
+	packfiles, err := store.List(ctx, storage.StorageResourcePackfile)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(packfiles, PolicyID) {
+		return nil, nil
+	}
+	return read(ctx, store)
+}
+
+// Fetch returns the policy of a store served by plakar server, which only
+// exposes it to its admin, or nil if the store has none or if it isn't
+// exposed.  The policy of the other stores is listed, see Load.  Any other
+// failure is an error, the caller can't tell whether there is a policy.
+func Fetch(ctx context.Context, store storage.Store) (*Policy, error) {
+	if store.Type() != "http" {
+		return Load(ctx, store)
+	}
+
+	policy, err := read(ctx, store)
+	if err != nil && strings.TrimSpace(err.Error()) == ErrReserved.Error() {
+		return nil, nil
+	}
+	return policy, err
+}
+
+func read(ctx context.Context, store storage.Store) (*Policy, error) {
+	rd, err := store.Get(ctx, storage.StorageResourcePackfile, PolicyID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var policy Policy
+	if err := json.NewDecoder(rd).Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid WORM policy: %w", err)
+	}
+	if policy.Version > VERSION {
+		return nil, fmt.Errorf("WORM policy version %d is newer than current version %d",
+			policy.Version, VERSION)
+	}
+	return &policy, nil
+}
+
+// Enable sets the policy of the store.  Once set, the retention can only
+// be raised and the admin token can't be changed.
+func Enable(ctx context.Context, store storage.Store, retention time.Duration, adminToken string) (*Policy, error) {
+	policy, err := Load(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy == nil {
+		if adminToken == "" {
+			return nil, fmt.Errorf("an admin token is required")
+		}
+		digest := sha256.Sum256([]byte(adminToken))
+		policy = &Policy{
+			Version:    VERSION,
+			Timestamp:  time.Now(),
+			AdminToken: digest[:],
+		}
+	} else {
+		if adminToken != "" && !policy.IsAdmin(adminToken) {
+			return nil, fmt.Errorf("the admin token of a WORM store can't be changed")
+		}
+		if retention < policy.Retention {
+			return nil, fmt.Errorf("the retention of a WORM store can't be lowered from %s", policy.Retention)
+		}
+		if retention == policy.Retention {
+			return policy, nil
+		}
+	}
+
+	if retention <= 0 {
+		return nil, fmt.Errorf("invalid retention %s", retention)
+	}
+	policy.Retention = retention
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := store.Put(ctx, storage.StorageResourcePackfile, PolicyID, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *Policy) IsAdmin(token string) bool {
+	digest := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(digest[:], p.AdminToken) == 1
+}
+
+// Expired tells whether a resource last modified at the given time may be
+// deleted.
+func (p *Policy) Expired(modTime time.Time) bool {
+	return time.Since(modTime) >= p.Retention
+}
@@ -0,0 +1,154 @@
+package worm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/server/worm"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore keeps the packfiles in memory, that's all the policy needs.
+type memStore struct {
+	storage.Store
+	packfiles map[objects.MAC][]byte
+	typ       string
+	getErr    error
+}
+
+func newMemStore() *memStore {
+	return &memStore{packfiles: make(map[objects.MAC][]byte)}
+}
+
+func (m *memStore) List(ctx context.Context, res storage.StorageResource) ([]objects.MAC, error) {
+	return slices.Collect(maps.Keys(m.packfiles)), nil
+}
+
+func (m *memStore) Get(ctx context.Context, res storage.StorageResource, mac objects.MAC, rg *storage.Range) (io.ReadCloser, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	data, ok := m.packfiles[mac]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStore) Put(ctx context.Context, res storage.StorageResource, mac objects.MAC, rd io.Reader) (int64, error) {
+	data, err := io.ReadAll(rd)
+	m.packfiles[mac] = data
+	return int64(len(data)), err
+}
+
+func (m *memStore) Flags() location.Flags { return 0 }
+func (m *memStore) Type() string          { return m.typ }
+
+func TestEnableAndLoad(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	policy, err := worm.Load(ctx, store)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	_, err = worm.Enable(ctx, store, 24*time.Hour, "")
+	require.ErrorContains(t, err, "admin token is required")
+
+	_, err = worm.Enable(ctx, store, 0, "s3cr3t")
+	require.ErrorContains(t, err, "invalid retention")
+
+	policy, err = worm.Enable(ctx, store, 24*time.Hour, "s3cr3t")
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, policy.Retention)
+
+	policy, err = worm.Load(ctx, store)
+	require.NoError(t, err)
+	require.NotNil(t, policy)
+	require.Equal(t, 24*time.Hour, policy.Retention)
+	require.True(t, policy.IsAdmin("s3cr3t"))
+	require.False(t, policy.IsAdmin("other"))
+	// only a digest of the token is kept
+	require.NotContains(t, string(store.packfiles[worm.PolicyID]), "s3cr3t")
+}
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	policy, err := worm.Fetch(ctx, store)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	_, err = worm.Enable(ctx, store, 24*time.Hour, "s3cr3t")
+	require.NoError(t, err)
+	policy, err = worm.Fetch(ctx, store)
+	require.NoError(t, err)
+	require.NotNil(t, policy)
+	require.Equal(t, 24*time.Hour, policy.Retention)
+
+	store.packfiles[worm.PolicyID] = []byte("garbage")
+	_, err = worm.Fetch(ctx, store)
+	require.ErrorContains(t, err, "invalid WORM policy")
+}
+
+func TestFetchServer(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	store.typ = "http"
+	_, err := worm.Enable(ctx, store, 24*time.Hour, "s3cr3t")
+	require.NoError(t, err)
+
+	// the admin reads the policy
+	policy, err := worm.Fetch(ctx, store)
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, policy.Retention)
+
+	// the server refuses it to the other clients, as when it has none
+	store.getErr = errors.New(worm.ErrReserved.Error() + "\n")
+	policy, err = worm.Fetch(ctx, store)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	// any other failure can't tell whether there is a policy
+	store.getErr = errors.New("Internal Server Error")
+	_, err = worm.Fetch(ctx, store)
+	require.EqualError(t, err, "Internal Server Error")
+}
+
+func TestEnableCantWeaken(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+
+	_, err := worm.Enable(ctx, store, 24*time.Hour, "s3cr3t")
+	require.NoError(t, err)
+
+	_, err = worm.Enable(ctx, store, time.Hour, "s3cr3t")
+	require.ErrorContains(t, err, "can't be lowered")
+
+	_, err = worm.Enable(ctx, store, 24*time.Hour, "other")
+	require.ErrorContains(t, err, "can't be changed")
+
+	// restarting with the same settings, or without the token, is fine
+	_, err = worm.Enable(ctx, store, 24*time.Hour, "s3cr3t")
+	require.NoError(t, err)
+
+	policy, err := worm.Enable(ctx, store, 48*time.Hour, "")
+	require.NoError(t, err)
+	require.Equal(t, 48*time.Hour, policy.Retention)
+	require.True(t, policy.IsAdmin("s3cr3t"))
+}
+
+func TestExpired(t *testing.T) {
+	policy := &worm.Policy{Retention: time.Hour}
+	require.True(t, policy.Expired(time.Now().Add(-2*time.Hour)))
+	require.False(t, policy.Expired(time.Now().Add(-time.Minute)))
+}
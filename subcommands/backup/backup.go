@@ -18,13 +18,16 @@ package backup
 
 import (
 	"bufio"
+	"cmp"
 	"flag"
 	"fmt"
+	"io"
 	"maps"
 	"os"
-	"os/exec"
-	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PlakarKorp/kloset/connectors"
@@ -39,6 +42,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/retention"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -58,13 +62,33 @@ type Backup struct {
 	PreHook             string
 	PostHook            string
 	FailHook            string
+	HookTimeout         time.Duration
+	PreHookFailure      string
 	NoXattr             bool
 	Cache               string
 	NoProgress          bool
+	Resume              bool
+	CheckpointEvery     time.Duration
+	ChangedFrom         string
+	Estimate            bool
+	Retry               int
+	MaxErrors           int
+	ErrorReport         string
+	FSSnapshot          string
+	JSON                bool
 	Name                string
 	Category            string
 	Environment         string
 	Perimeter           string
+	NoRetention         bool
+
+	interrupt     chan struct{}
+	interruptOnce sync.Once
+
+	// closed when the current pass over the sources stops
+	stop chan struct{}
+	// set when a source of the current pass was cut short
+	cut atomic.Bool
 }
 
 func init() {
@@ -106,16 +130,18 @@ func (e *tagFlags) asList() []string {
 	return strings.Split(tags, ",")
 }
 
-func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
-	var opt_ignore_files ignoreFlags
-	var opt_ignore ignoreFlags
-	var opt_tags tagFlags
-
-	excludes := []string{}
+// backupFlags holds the values of the flags that are not stored as is in
+// the Backup.
+type backupFlags struct {
+	ignoreFiles ignoreFlags
+	ignore      ignoreFlags
+	tags        tagFlags
+}
 
+func (cmd *Backup) newFlagSet(opts *backupFlags) *flag.FlagSet {
 	cmd.Opts = make(map[string]string)
 
-	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] path\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] @LOCATION\n", flags.Name())
@@ -123,32 +149,116 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 		flags.PrintDefaults()
 	}
 
-	flags.Var(&opt_tags, "tag", "comma-separated list of tags to apply to the snapshot")
+	flags.Var(&opts.tags, "tag", "comma-separated list of tags to apply to the snapshot")
 	flags.StringVar(&cmd.Name, "name", "default", "backup name")
 	flags.StringVar(&cmd.Category, "category", "", "backup category")
 	flags.StringVar(&cmd.Environment, "environment", "", "backup environment")
 	flags.StringVar(&cmd.Perimeter, "perimeter", "", "backup perimeter")
 	flags.StringVar(&cmd.Job, "job", "", "backup job")
-	flags.Var(&opt_ignore_files, "ignore-file", "path to a file containing newline-separated gitignore patterns, treated as -ignore; can be specified multiple times")
-	flags.Var(&opt_ignore, "ignore", "gitignore pattern to exclude files, can be specified multiple times to add several exclusion patterns")
+	flags.Var(&opts.ignoreFiles, "ignore-file", "path to a file containing newline-separated gitignore patterns, treated as -ignore; can be specified multiple times")
+	flags.Var(&opts.ignore, "ignore", "gitignore pattern to exclude files, can be specified multiple times to add several exclusion patterns")
 	flags.StringVar(&cmd.PackfileTempStorage, "packfiles", "", "memory or a path to a directory to store temporary packfiles")
 	flags.BoolVar(&cmd.OptCheck, "check", false, "check the snapshot after creating it")
 	flags.Var(utils.NewOptsFlag(cmd.Opts), "o", "specify extra importer options")
 	flags.BoolVar(&cmd.DryRun, "dry-run", false, "do not actually perform a backup")
+	flags.BoolVar(&cmd.Estimate, "estimate", false, "estimate the size of the backup without performing it")
+	flags.BoolVar(&cmd.JSON, "json", false, "output the estimate in JSON format")
 	flags.BoolVar(&cmd.NoXattr, "no-xattr", false, "do not back up extended attributes")
 	flags.StringVar(&cmd.Cache, "cache", "vfs", "path to store vfs cache, 'no' for uncached and 'vfs' for the default in memory cache")
 	flags.BoolVar(&cmd.NoProgress, "no-progress", false, "do not display progress")
+	flags.BoolVar(&cmd.Resume, "resume", false, "resume from the checkpoint of an interrupted backup, and create one if interrupted")
+	flags.DurationVar(&cmd.CheckpointEvery, "checkpoint-every", DefaultCheckpointInterval, "with -resume, commit a checkpoint this often, 0 to only do it when interrupted")
+	flags.StringVar(&cmd.ChangedFrom, "changed-from", "", "only look at the paths listed in this file, - for stdin, on top of the previous snapshot")
+	flags.IntVar(&cmd.Retry, "retry", 0, "retry reading an entry this many times after a transient error")
+	flags.IntVar(&cmd.MaxErrors, "max-errors", -1, "fail the backup if there are more errors than this, -1 for no limit")
+	flags.StringVar(&cmd.ErrorReport, "error-report", "", "write the report of the errors in JSON to this file, - for stdout")
+	flags.StringVar(&cmd.FSSnapshot, "fs-snapshot", "", "back up the fs sources from a filesystem snapshot: btrfs, lvm or zfs")
+	flags.BoolVar(&cmd.NoRetention, "no-retention", false, "do not apply the retention policy of the store after the backup")
+	flags.StringVar(&cmd.PreHook, "pre-hook", "", "command to run before the backup")
+	flags.StringVar(&cmd.PostHook, "post-hook", "", "command to run after a successful backup")
+	flags.StringVar(&cmd.FailHook, "fail-hook", "", "command to run after a failed backup")
+	flags.DurationVar(&cmd.HookTimeout, "hook-timeout", 0, "kill the hooks running for longer than this duration")
+	flags.StringVar(&cmd.PreHookFailure, "pre-hook-failure", PreHookAbort, "what to do when the pre-backup hook fails: abort or warn")
 
 	flags.Var(locate.NewTimeFlag(&cmd.ForcedTimestamp), "force-timestamp", "force a timestamp")
-	flags.Parse(args)
 
+	return flags
+}
+
+// checkFlags validates the flags parsed, without acting on them.
+func (cmd *Backup) checkFlags() error {
 	if !cmd.ForcedTimestamp.IsZero() {
 		if cmd.ForcedTimestamp.After(time.Now()) {
 			return fmt.Errorf("forced timestamp cannot be in the future")
 		}
 	}
 
-	for _, ignoreFile := range opt_ignore_files {
+	if cmd.Estimate && cmd.DryRun {
+		return fmt.Errorf("-estimate and -dry-run can't be used together")
+	}
+	if cmd.JSON && !cmd.Estimate {
+		return fmt.Errorf("-json requires -estimate")
+	}
+
+	if cmd.Resume && cmd.Cache != "vfs" {
+		return fmt.Errorf("-resume requires the vfs cache")
+	}
+	if cmd.CheckpointEvery < 0 {
+		return fmt.Errorf("invalid checkpoint interval %s", cmd.CheckpointEvery)
+	}
+	if cmd.ChangedFrom != "" {
+		if cmd.Cache != "vfs" {
+			return fmt.Errorf("-changed-from requires the vfs cache")
+		}
+		if cmd.Resume {
+			return fmt.Errorf("-changed-from can't be used with -resume")
+		}
+	}
+
+	if _, ok := fsSnapshotDrivers[cmd.FSSnapshot]; !ok && cmd.FSSnapshot != "" {
+		return fmt.Errorf("unknown fs snapshot driver %q", cmd.FSSnapshot)
+	}
+
+	if cmd.Retry < 0 {
+		return fmt.Errorf("invalid number of retries %d", cmd.Retry)
+	}
+	if cmd.MaxErrors < -1 {
+		return fmt.Errorf("invalid number of errors %d", cmd.MaxErrors)
+	}
+
+	if cmd.HookTimeout < 0 {
+		return fmt.Errorf("invalid hook timeout %s", cmd.HookTimeout)
+	}
+	return checkPreFailure(cmd.PreHookFailure)
+}
+
+// CheckArgs validates the arguments of a backup without running it.
+func (cmd *Backup) CheckArgs(args []string) error {
+	var opts backupFlags
+
+	flags := cmd.newFlagSet(&opts)
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	return cmd.checkFlags()
+}
+
+func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opts backupFlags
+
+	excludes := []string{}
+
+	flags := cmd.newFlagSet(&opts)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := cmd.checkFlags(); err != nil {
+		return err
+	}
+	cmd.interrupt = make(chan struct{})
+
+	for _, ignoreFile := range opts.ignoreFiles {
 		lines, err := LoadIgnoreFile(ignoreFile)
 		if err != nil {
 			return err
@@ -156,13 +266,13 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 		excludes = append(excludes, lines...)
 	}
 
-	for _, item := range opt_ignore {
+	for _, item := range opts.ignore {
 		excludes = append(excludes, item)
 	}
 
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Excludes = excludes
-	cmd.Tags = opt_tags.asList()
+	cmd.Tags = opts.tags.asList()
 
 	// If no tags were provided via CLI flag, check PLAKAR_TAGS env var
 	if len(cmd.Tags) == 0 {
@@ -193,7 +303,7 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 	return ret, err
 }
 
-func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
+func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Repository) (ret int, err error, snapshotID objects.MAC, warning error) {
 	emitter := repo.Emitter("import")
 	defer emitter.Close()
 
@@ -212,11 +322,42 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		opts.ForcedTimestamp = cmd.ForcedTimestamp
 	}
 
+	// The errors of the entries, reported once the backup is done.
+	report := &ErrorReport{}
+
+	// The sources backed up from a filesystem snapshot.
+	var fsSnapshots []*fsSnapshotImporter
+
 	sourcesPerOrig := make(map[string][]importer.Importer)
+	// Sources are imported in the order they were given on the command
+	// line, which is also the order they appear in the snapshot.
+	sourcesOrder := make([]string, 0, len(cmd.Sources))
 	// If we are doing a fake run for statistics instantiate separate importers,
 	// otherwise it makes plugin development harder than needed.
 	sourcesPerOrigForStats := make(map[string][]importer.Importer)
 
+	// The hooks given on the command line cover the whole backup, those of
+	// the sources are run after them, and undone in the reverse order.
+	var hooks []*Hooks
+	if cmd.PreHook != "" || cmd.PostHook != "" || cmd.FailHook != "" {
+		preFailure := cmd.PreHookFailure
+		if preFailure == "" {
+			preFailure = PreHookAbort
+		}
+		hooks = append(hooks, &Hooks{
+			Source:     strings.Join(cmd.Sources, " "),
+			Pre:        cmd.PreHook,
+			Post:       cmd.PostHook,
+			Fail:       cmd.FailHook,
+			Timeout:    cmd.HookTimeout,
+			PreFailure: preFailure,
+			index:      -1,
+		})
+	}
+
+	// whether the sources can be imported again after a checkpoint
+	restartable := true
+
 	for _, source := range cmd.Sources {
 		scanDir := "fs:" + ctx.CWD
 		if source != "" {
@@ -246,6 +387,22 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 			}
 		}
 
+		var srcHooks *Hooks
+		if strings.HasPrefix(scanDir, "@") {
+			srcHooks, err = sourceHooks(scanDir, cmdOptsCopy)
+			if err != nil {
+				return 1, err, objects.MAC{}, nil
+			}
+			if srcHooks != nil && srcHooks.Timeout == 0 {
+				srcHooks.Timeout = cmd.HookTimeout
+			}
+		}
+
+		fsSnap, err := sourceFSSnapshot(scanDir, cmd.FSSnapshot, cmdOptsCopy)
+		if err != nil {
+			return 1, err, objects.MAC{}, nil
+		}
+
 		// Now that we have resolved the possible @ syntax let's apply the scandir.
 		if _, found := cmdOptsCopy["location"]; !found {
 			cmdOptsCopy["location"] = scanDir
@@ -265,15 +422,46 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		}
 		defer imp.Close(ctx)
 
+		if fsSnap != nil {
+			if imp.Type() != "fs" {
+				return 1, fmt.Errorf("%s: fs snapshots only apply to fs sources", scanDir), objects.MAC{}, nil
+			}
+			fsSnap.Timeout = cmd.HookTimeout
+			snapImp := newFSSnapshotImporter(imp, fsSnap, importerOpts, cmdOptsCopy)
+			fsSnapshots = append(fsSnapshots, snapImp)
+			imp = snapImp
+		}
+
+		imp = newReportImporter(imp, report, cmd.Retry)
+		if cmd.Resume {
+			imp = &checkpointImporter{
+				Importer:    imp,
+				stop:        cmd.stopping,
+				interrupted: cmd.interrupted,
+				cut:         cmd.cutShort,
+			}
+			// the output of a stream can't be imported again
+			restartable = restartable && imp.Flags()&location.FLAG_STREAM == 0
+		}
+
 		var (
 			typ  = imp.Type()
 			orig = imp.Origin()
 		)
 
 		importerKey := typ + ":" + orig
+		if _, found := sourcesPerOrig[importerKey]; !found {
+			sourcesOrder = append(sourcesOrder, importerKey)
+		}
 		sourcesPerOrig[importerKey] = append(sourcesPerOrig[importerKey], imp)
 
-		if !cmd.NoProgress && (imp.Flags()&location.FLAG_STREAM) == 0 {
+		if srcHooks != nil {
+			srcHooks.index = slices.Index(sourcesOrder, importerKey)
+			hooks = append(hooks, srcHooks)
+		}
+
+		// The snapshot of a filesystem may be within the source.
+		if !cmd.NoProgress && !cmd.Estimate && cmd.ChangedFrom == "" && fsSnap == nil && (imp.Flags()&location.FLAG_STREAM) == 0 {
 			imp, err := importer.NewImporter(ctx.GetInner(), importerOpts, cmdOptsCopy)
 			if err != nil {
 				return 1, fmt.Errorf("failed to create an importer for %s: %s", scanDir, err), objects.MAC{}, nil
@@ -283,9 +471,19 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		}
 	}
 
-	// XXX - until we unlock multi-source
-	if len(sourcesPerOrig) != 1 {
-		return 1, fmt.Errorf("multi-source backup not supported yet"), objects.MAC{}, nil
+	var changes []string
+	if cmd.ChangedFrom != "" {
+		if len(sourcesOrder) != 1 || len(sourcesPerOrig[sourcesOrder[0]]) != 1 ||
+			sourcesPerOrig[sourcesOrder[0]][0].Type() != "fs" {
+			return 1, fmt.Errorf("-changed-from requires a single fs source"), objects.MAC{}, nil
+		}
+		if len(fsSnapshots) != 0 {
+			return 1, fmt.Errorf("-changed-from can't be used with an fs snapshot"), objects.MAC{}, nil
+		}
+		changes, err = readChangeList(ctx, cmd.ChangedFrom)
+		if err != nil {
+			return 1, err, objects.MAC{}, nil
+		}
 	}
 
 	if cmd.PackfileTempStorage == "memory" {
@@ -299,114 +497,265 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		defer os.RemoveAll(cmd.PackfileTempStorage)
 	}
 
-	// Execute pre-backup hook
-	if err := executeHook(ctx, cmd.PreHook); err != nil {
-		return 1, fmt.Errorf("pre-backup hook failed: %w", err), objects.MAC{}, nil
+	if err := runPreHooks(ctx, hooks); err != nil {
+		return 1, err, objects.MAC{}, nil
 	}
 
-	snap, err := snapshot.Create(repo, repository.DefaultType, cmd.PackfileTempStorage, objects.NilMac, opts)
+	var snap *snapshot.Builder
+	start := time.Now()
+	defer func() {
+		if ret != 0 {
+			run := &hookRun{start: start, err: cmp.Or(err, warning)}
+			if snap != nil {
+				run.hdr = snap.Header
+			}
+			runFailHooks(ctx, hooks, run)
+		}
+	}()
+
+	// The filesystem snapshots are taken once the pre hooks ran, and
+	// deleted before the post and fail hooks run, whatever happens.
+	for _, imp := range fsSnapshots {
+		defer imp.remove(ctx)
+		if err := imp.create(ctx); err != nil {
+			return 1, err, objects.MAC{}, nil
+		}
+	}
+
+	snap, err = snapshot.Create(repo, repository.DefaultType, cmd.PackfileTempStorage, objects.NilMac, opts)
 	if err != nil {
 		ctx.GetLogger().Error("%s", err)
 		return 1, err, objects.MAC{}, nil
 	}
-	defer snap.Close()
+	defer func() {
+		if snap != nil {
+			snap.Close()
+		}
+	}()
 
 	if cmd.Job != "" {
 		snap.Header.Job = cmd.Job
 	}
 
+	// Checkpoints used by this run, removed once it's committed.
+	var resumed []objects.MAC
+
+	var est *Estimate
+	if cmd.Estimate {
+		est = newEstimate()
+	}
+
+	// With -resume, what was backed up so far is committed as a checkpoint
+	// every -checkpoint-every, and the sources are imported again on top
+	// of it in a new pass.
+	var every time.Duration
+	if cmd.Resume && restartable && !cmd.DryRun && !cmd.Estimate {
+		every = cmd.CheckpointEvery
+	}
+
 	// Actual import of sources.
-	for key, sourceImporters := range sourcesPerOrig {
-		source, err := snapshot.NewSource(repo.AppContext(), sourceImporters...)
-		if err != nil {
-			return 1, err, objects.NilMac, nil
-		}
+	for {
+		endPass := sync.OnceFunc(cmd.startPass(every))
+		defer endPass()
 
-		if err := source.SetExcludes(cmd.Excludes); err != nil {
-			return 1, err, objects.MAC{}, nil
-		}
+		for _, key := range sourcesOrder {
+			source, err := snapshot.NewSource(repo.AppContext(), sourcesPerOrig[key]...)
+			if err != nil {
+				return 1, err, objects.NilMac, nil
+			}
 
-		if cmd.DryRun {
-			if err := dryrun(ctx, source, emitter); err != nil {
+			if err := source.SetExcludes(cmd.Excludes); err != nil {
 				return 1, err, objects.MAC{}, nil
 			}
-			return 0, nil, objects.MAC{}, nil
-		}
 
-		var parentVFS *vfs.Filesystem
+			if cmd.DryRun {
+				if err := dryrun(ctx, source, emitter); err != nil {
+					return 1, err, objects.MAC{}, nil
+				}
+				continue
+			}
 
-		if cmd.Cache == "vfs" {
-			parentID, _, err := locate.Match(repo, &locate.LocateOptions{
-				Filters: locate.LocateFilters{
-					Latest: true,
-					Roots: []string{
-						source.Root(),
-					},
-					Types: []string{
-						source.Type(),
-					},
-					Origins: []string{
-						source.Origin(),
-					},
-				},
-			})
-			if err != nil {
-				return 1, nil, objects.MAC{}, err
+			if cmd.Estimate {
+				if err := estimate(ctx, repo, source, est); err != nil {
+					return 1, err, objects.MAC{}, nil
+				}
+				continue
 			}
 
-			if len(parentID) != 0 {
-				parent, err := snapshot.Load(repo, parentID[0])
+			var parentVFS *vfs.Filesystem
+
+			if cmd.Cache == "vfs" {
+				parentOpts := &locate.LocateOptions{
+					Filters: locate.LocateFilters{
+						Latest: true,
+						Roots: []string{
+							source.Root(),
+						},
+						Types: []string{
+							source.Type(),
+						},
+						Origins: []string{
+							source.Origin(),
+						},
+					},
+				}
+				// a checkpoint only holds part of the source, it is
+				// the parent of a run resuming it and of no other
+				utils.IgnoreCheckpoints(parentOpts)
+				parentID, _, err := locate.Match(repo, parentOpts)
 				if err != nil {
-					fmt.Printf("Failed to load parent snapshot %x: %s\n", parentID[0], err)
-				} else {
-					defer parent.Close()
+					return 1, nil, objects.MAC{}, err
+				}
 
-					parentVFS, err = parent.FilesystemWithCache()
+				if cmd.Resume {
+					ids, err := checkpoints(repo, source)
 					if err != nil {
-						fmt.Printf("Failed to get parent VFS for snapshot %x: %s\n", parentID[0], err)
+						return 1, nil, objects.MAC{}, err
+					}
+					if len(ids) != 0 {
+						ctx.GetLogger().Info("resuming from checkpoint %x", ids[0][:4])
+						parentID = ids[:1]
+						for _, id := range ids {
+							if !slices.Contains(resumed, id) {
+								resumed = append(resumed, id)
+							}
+						}
+					}
+				}
+
+				if len(parentID) != 0 {
+					parent, err := snapshot.Load(repo, parentID[0])
+					if err != nil {
+						fmt.Printf("Failed to load parent snapshot %x: %s\n", parentID[0], err)
+					} else {
+						defer parent.Close()
+
+						// The parent may hold several sources, use
+						// the one matching what we are importing.
+						idx := utils.MatchSource(parent.Header, source.Type(), source.Origin(), source.Root())
+						if idx != -1 {
+							view, err := utils.SnapshotSource(repo, parent, idx)
+							if err != nil {
+								fmt.Printf("Failed to load parent snapshot %x: %s\n", parentID[0], err)
+							} else {
+								if view != parent {
+									defer view.Close()
+								}
+								parentVFS, err = view.FilesystemWithCache()
+								if err != nil {
+									fmt.Printf("Failed to get parent VFS for snapshot %x: %s\n", parentID[0], err)
+								}
+							}
+						}
 					}
 				}
 			}
-		}
-		snap.WithVFSCache(parentVFS)
+			snap.WithVFSCache(parentVFS)
 
-		if !cmd.NoProgress && (source.Flags()&location.FLAG_STREAM) == 0 {
-			source, err := snapshot.NewSource(repo.AppContext(), sourcesPerOrigForStats[key]...)
-			if err != nil {
-				return 1, err, objects.NilMac, nil
+			// Only the listed paths are looked at, the rest of the tree
+			// comes from the previous snapshot.
+			if cmd.ChangedFrom != "" {
+				if parentVFS == nil {
+					ctx.GetLogger().Warn("no previous snapshot of %s, backing it up entirely", source.Root())
+				} else {
+					imp := newChangedImporter(sourcesPerOrig[key][0], parentVFS, changes, cmd.NoXattr)
+					source, err = snapshot.NewSource(repo.AppContext(), newReportImporter(imp, report, cmd.Retry))
+					if err != nil {
+						return 1, err, objects.NilMac, nil
+					}
+					if err := source.SetExcludes(cmd.Excludes); err != nil {
+						return 1, err, objects.MAC{}, nil
+					}
+				}
 			}
 
-			if err := source.SetExcludes(cmd.Excludes); err != nil {
+			if !cmd.NoProgress && len(sourcesPerOrigForStats[key]) != 0 && (source.Flags()&location.FLAG_STREAM) == 0 {
+				source, err := snapshot.NewSource(repo.AppContext(), sourcesPerOrigForStats[key]...)
+				if err != nil {
+					return 1, err, objects.NilMac, nil
+				}
+
+				if err := source.SetExcludes(cmd.Excludes); err != nil {
+					return 1, err, objects.MAC{}, nil
+				}
+
+				go func() {
+					fsSummary := statistics(ctx, source)
+					emitter.FilesystemSummary(
+						fsSummary.FileCount,
+						fsSummary.DirCount,
+						fsSummary.SymlinkCount,
+						fsSummary.XattrCount,
+						fsSummary.TotalSize,
+					)
+				}()
+			}
+
+			if err := snap.Backup(source); err != nil {
+				return 1, fmt.Errorf("failed to backup source: %w", err), objects.MAC{}, nil
+			}
+
+			if cmd.cut.Load() {
+				break
+			}
+		}
+		endPass()
+
+		if !cmd.cut.Load() {
+			break
+		}
+		if !cmd.DryRun {
+			id, err := checkpoint(ctx, repo, snap, resumed)
+			if err != nil {
 				return 1, err, objects.MAC{}, nil
 			}
+			resumed = []objects.MAC{id}
+		}
+		if cmd.interrupted() {
+			return 1, ErrInterrupted, objects.MAC{}, nil
+		}
 
-			go func() {
-				fsSummary := statistics(ctx, source)
-				emitter.FilesystemSummary(
-					fsSummary.FileCount,
-					fsSummary.DirCount,
-					fsSummary.SymlinkCount,
-					fsSummary.XattrCount,
-					fsSummary.TotalSize,
-				)
-			}()
+		err := utils.ReleaseBuilder(repo, snap)
+		snap = nil
+		if err != nil {
+			return 1, err, objects.MAC{}, nil
+		}
+		snap, err = snapshot.Create(repo, repository.DefaultType, cmd.PackfileTempStorage, objects.NilMac, opts)
+		if err != nil {
+			return 1, err, objects.MAC{}, nil
+		}
+		if cmd.Job != "" {
+			snap.Header.Job = cmd.Job
 		}
+	}
+
+	if cmd.DryRun {
+		runPostHooks(ctx, hooks, &hookRun{start: start})
+		return 0, nil, objects.MAC{}, nil
+	}
 
-		if err := snap.Backup(source); err != nil {
-			if err := executeHook(ctx, cmd.FailHook); err != nil {
-				ctx.GetLogger().Warn("post-backup fail hook failed: %s", err)
+	if cmd.Estimate {
+		if cmd.JSON {
+			if err := est.write(ctx); err != nil {
+				return 1, err, objects.MAC{}, nil
 			}
-			return 1, fmt.Errorf("failed to backup source: %w", err), objects.MAC{}, nil
+		} else {
+			est.print(ctx)
 		}
+		runPostHooks(ctx, hooks, &hookRun{start: start})
+		return 0, nil, objects.MAC{}, nil
 	}
 
 	if err := snap.Commit(); err != nil {
-		if err := executeHook(ctx, cmd.FailHook); err != nil {
-			ctx.GetLogger().Warn("post-backup fail hook failed: %s", err)
-		}
 		return 1, fmt.Errorf("failed to commit snapshot: %w", err), objects.MAC{}, nil
 	}
 
+	for _, id := range resumed {
+		if err := repo.DeleteSnapshot(id); err != nil {
+			ctx.GetLogger().Warn("failed to remove checkpoint %x: %s", id[:4], err)
+		}
+	}
+
 	if cmd.OptCheck {
 		_, err := cached.RebuildStateFromStore(ctx, repo.Configuration().RepositoryID, ctx.StoreConfig, false)
 		if err != nil {
@@ -429,19 +778,22 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		}
 		defer checkCache.Close()
 
-		checkSnap.SetCheckCache(checkCache)
-
-		if err := checkSnap.Check("/", checkOptions); err != nil {
-			if err := executeHook(ctx, cmd.FailHook); err != nil {
-				ctx.GetLogger().Warn("post-backup fail hook failed: %s", err)
+		for source, err := range utils.SnapshotSources(repo, checkSnap) {
+			if err == nil {
+				source.SetCheckCache(checkCache)
+				err = source.Check("/", checkOptions)
+			}
+			if err != nil {
+				return 1, fmt.Errorf("failed to check snapshot: %w", err), objects.MAC{}, nil
 			}
-			return 1, fmt.Errorf("failed to check snapshot: %w", err), objects.MAC{}, nil
 		}
 	}
 
-	// Execute post-backup hook
-	if err := executeHook(ctx, cmd.PostHook); err != nil {
-		ctx.GetLogger().Warn("post-backup hook failed: %s", err)
+	report.log(ctx)
+	if cmd.ErrorReport != "" {
+		if err := report.write(ctx, cmd.ErrorReport); err != nil {
+			ctx.GetLogger().Warn("failed to write error report: %s", err)
+		}
 	}
 
 	totalErrors := uint64(0)
@@ -449,7 +801,18 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		s := snap.Header.GetSource(i)
 		totalErrors += s.Summary.Directory.Errors + s.Summary.Below.Errors
 	}
-	var warning error
+	if cmd.MaxErrors >= 0 && totalErrors > uint64(cmd.MaxErrors) {
+		return 1, fmt.Errorf("%d errors during backup, more than the %d allowed", totalErrors, cmd.MaxErrors), snap.Header.Identifier, nil
+	}
+
+	runPostHooks(ctx, hooks, &hookRun{hdr: snap.Header, start: start})
+
+	if ctx.Retention != "" && !cmd.NoRetention {
+		if _, err := retention.Apply(ctx, repo, ctx.Retention); err != nil {
+			ctx.GetLogger().Warn("failed to apply the retention policy %s: %s", ctx.Retention, err)
+		}
+	}
+
 	if totalErrors > 0 {
 		warning = fmt.Errorf("%d errors during backup", totalErrors)
 	}
@@ -481,25 +844,6 @@ func LoadIgnoreFile(filename string) ([]string, error) {
 	return lines, nil
 }
 
-func executeHook(ctx *appcontext.AppContext, hook string) error {
-	if hook == "" {
-		return nil
-	}
-	ctx.GetLogger().Info("executing hook: %s", hook)
-
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "windows":
-		cmd = exec.Command("cmd", "/C", hook)
-	default: // assume unix-esque
-		cmd = exec.Command("/bin/sh", "-c", hook)
-	}
-
-	cmd.Stdout = ctx.Stdout
-	cmd.Stderr = ctx.Stderr
-	return cmd.Run()
-}
-
 func ack(record *connectors.Record, results chan<- *connectors.Result) {
 	if results == nil {
 		record.Close()
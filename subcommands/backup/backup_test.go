@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/importer"
 	bfs "github.com/PlakarKorp/integrations/fs/storage"
@@ -18,9 +20,13 @@ import (
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	_ "github.com/PlakarKorp/plakar/connectors/command"
+	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -394,3 +400,159 @@ func TestExecuteCmdCreateDefaultWithIgnores(t *testing.T) {
 	output := bufOut.String()
 	require.NotContains(t, output, "/subdir")
 }
+
+func TestExecuteCmdCreateMultiSource(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	defer renderer.Wait()
+
+	defer ctx.Close()
+
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+	args := []string{tmpBackupDir, "mock://place"}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+
+	// sources appear in the order they were given
+	require.Len(t, snap.Header.Sources, 2)
+	require.Equal(t, "fs", snap.Header.GetSource(0).Importer.Type)
+	require.Equal(t, tmpBackupDir, snap.Header.GetSource(0).Importer.Directory)
+	require.Equal(t, "mock", snap.Header.GetSource(1).Importer.Type)
+}
+
+func TestExecuteCmdCreateCommandSource(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	defer renderer.Wait()
+
+	defer ctx.Close()
+
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+	args := []string{"-o", "command=echo 'CREATE TABLE t;'", "command:///dumps/db.sql", tmpBackupDir}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err, snapshotID, _ := subcommand.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+
+	require.Len(t, snap.Header.Sources, 2)
+	require.Equal(t, "command", snap.Header.GetSource(0).Importer.Type)
+
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+	data, err := fs.ReadFile(pvfs, "/dumps/db.sql")
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE t;\n", string(data))
+}
+
+func TestExecuteCmdCreateCommandSourceFailure(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, _, ctx := generateFixtures(t, bufOut, bufErr)
+	defer ctx.Close()
+
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+	args := []string{"-o", "command=echo partial; exit 1", "command:///dumps/db.sql"}
+
+	subcommand := &Backup{}
+	err := subcommand.Parse(ctx, args)
+	require.NoError(t, err)
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.ErrorContains(t, err, "exit status 1")
+	require.Equal(t, 1, status)
+
+	// the truncated dump was not committed
+	for range repo.ListSnapshots() {
+		t.Fatal("unexpected snapshot")
+	}
+}
+
+func TestBackupAppliesRetention(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	ctx.ConfigDir = t.TempDir()
+	configFile := ctx.ConfigDir + "/policies.yml"
+	cfg, err := utils.LoadPolicyConfigFile(configFile)
+	require.NoError(t, err)
+	cfg.Add("week")
+	require.NoError(t, cfg.Set("week", "days", "7"))
+	require.NoError(t, cfg.SaveToFile(configFile))
+
+	backup := func(args ...string) objects.MAC {
+		cmd := &Backup{}
+		require.NoError(t, cmd.Parse(ctx, append(args, "-no-progress", tmpBackupDir)))
+		status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		require.NoError(t, repo.RebuildState())
+		ptesting.WaitUnlocked(t, repo)
+		return snapshotID
+	}
+	snapshots := func() map[objects.MAC]struct{} {
+		ids := make(map[objects.MAC]struct{})
+		for id, err := range repo.ListSnapshots() {
+			require.NoError(t, err)
+			ids[id] = struct{}{}
+		}
+		return ids
+	}
+
+	backup("-force-timestamp", time.Now().Add(-30*24*time.Hour).UTC().Format(time.RFC3339))
+	ctx.Retention = "week"
+
+	first := backup("-no-retention")
+	require.Len(t, snapshots(), 2)
+
+	second := backup()
+	require.Equal(t, map[objects.MAC]struct{}{first: {}, second: {}}, snapshots())
+	require.Contains(t, bufOut.String(), "retention: policy week removed 1 snapshots")
+
+	// a failure to apply the policy doesn't fail the backup
+	ctx.Retention = "missing"
+	backup()
+	require.Contains(t, bufErr.String(), `failed to apply the retention policy missing: policy "missing" not found`)
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/pkg/xattr"
+)
+
+// readChangeList reads the paths listed one per line in name, or on the
+// standard input if name is "-".  They are returned cleaned and sorted,
+// without duplicates.
+func readChangeList(ctx *appcontext.AppContext, name string) ([]string, error) {
+	var rd io.Reader
+	if name == "-" {
+		rd = ctx.Stdin
+	} else {
+		fp, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open change list: %w", err)
+		}
+		defer fp.Close()
+		rd = fp
+	}
+
+	var changes []string
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			return nil, fmt.Errorf("change list: not an absolute path: %s", line)
+		}
+		changes = append(changes, toslash(filepath.Clean(line)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read change list: %w", err)
+	}
+
+	slices.Sort(changes)
+	return slices.Compact(changes), nil
+}
+
+// toslash converts a path to the format of the fs importer.
+func toslash(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// changedImporter builds the tree of an fs source from the snapshot of
+// the previous backup, only looking at the paths of the change list on
+// the filesystem.  The paths not listed are recorded as they were, so
+// that their content is taken from the parent snapshot.  A listed path
+// missing from the filesystem is removed, one new to the snapshot is
+// added along with what's below it.
+type changedImporter struct {
+	importer.Importer
+	*localStat
+	parent  *vfs.Filesystem
+	changes []string
+}
+
+func newChangedImporter(imp importer.Importer, parent *vfs.Filesystem, changes []string, noXattr bool) *changedImporter {
+	root := imp.Root()
+	var below []string
+	for _, pathname := range changes {
+		if pathname == root || isBelow(root, pathname) {
+			below = append(below, pathname)
+		}
+	}
+
+	return &changedImporter{
+		Importer:  imp,
+		localStat: newLocalStat(noXattr),
+		parent:    parent,
+		changes:   below,
+	}
+}
+
+func isBelow(dir, pathname string) bool {
+	return strings.HasPrefix(pathname, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (imp *changedImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	done := make(map[string]struct{})
+	err := imp.parent.WalkDir("/", func(pathname string, entry *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, found := slices.BinarySearch(imp.changes, pathname); !found {
+			imp.graft(pathname, entry, records)
+			return nil
+		}
+
+		done[pathname] = struct{}{}
+		info, err := imp.stat(pathname, records)
+		if err != nil || !info.IsDir() {
+			return fs.SkipDir
+		}
+		if !entry.IsDir() {
+			// a file replaced by a directory
+			return imp.walk(ctx, pathname, records, done)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pathname := range imp.changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, found := done[pathname]; found {
+			continue
+		}
+
+		info, err := imp.add(pathname, records, done)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			if err := imp.walk(ctx, pathname, records, done); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// graft records an entry of the parent snapshot as it was.
+func (imp *changedImporter) graft(pathname string, entry *vfs.Entry, records chan<- *connectors.Record) {
+	fspath := filepath.FromSlash(pathname)
+	records <- connectors.NewRecord(pathname, entry.SymlinkTarget, entry.FileInfo, entry.ExtendedAttributes,
+		func() (io.ReadCloser, error) {
+			return os.Open(fspath)
+		})
+
+	for _, attr := range entry.ExtendedAttributes {
+		records <- connectors.NewXattr(pathname, attr, objects.AttributeExtended,
+			func() (io.ReadCloser, error) {
+				rd, err := entry.Xattr(imp.parent, attr)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(rd), nil
+			})
+	}
+}
+
+// add records a path new to the snapshot and the directories leading to
+// it which are not in the snapshot either.
+func (imp *changedImporter) add(pathname string, records chan<- *connectors.Record, done map[string]struct{}) (fs.FileInfo, error) {
+	root := imp.Root()
+
+	var missing []string
+	for dir := path.Dir(pathname); dir == root || isBelow(root, dir); dir = path.Dir(dir) {
+		if _, found := done[dir]; found {
+			break
+		}
+		if _, err := imp.parent.GetEntryNoFollow(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		done[missing[i]] = struct{}{}
+		if _, err := imp.stat(missing[i], records); err != nil {
+			return nil, err
+		}
+	}
+
+	done[pathname] = struct{}{}
+	return imp.stat(pathname, records)
+}
+
+// walk records everything below a directory found on the filesystem.
+func (imp *changedImporter) walk(ctx context.Context, pathname string, records chan<- *connectors.Record, done map[string]struct{}) error {
+	return filepath.WalkDir(filepath.FromSlash(pathname), func(fspath string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entrypath := toslash(fspath)
+		if entrypath == pathname {
+			return nil
+		}
+		done[entrypath] = struct{}{}
+		if err != nil {
+			records <- connectors.NewError(entrypath, err)
+			return nil
+		}
+		imp.stat(entrypath, records)
+		return nil
+	})
+}
+
+// localStat records paths as found on the local filesystem.
+type localStat struct {
+	noXattr bool
+	users   map[uint64]string
+	groups  map[uint64]string
+}
+
+func newLocalStat(noXattr bool) *localStat {
+	return &localStat{
+		noXattr: noXattr,
+		users:   make(map[uint64]string),
+		groups:  make(map[uint64]string),
+	}
+}
+
+// stat records a path as found on the filesystem, it returns an error if
+// the path doesn't exist anymore.
+func (ls *localStat) stat(pathname string, records chan<- *connectors.Record) (fs.FileInfo, error) {
+	fspath := filepath.FromSlash(pathname)
+	info, err := os.Lstat(fspath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			records <- connectors.NewError(pathname, err)
+		}
+		return nil, err
+	}
+
+	fileinfo := objects.FileInfoFromStat(info)
+	fileinfo.Lusername = ls.lookup(ls.users, fileinfo.Uid(), func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	fileinfo.Lgroupname = ls.lookup(ls.groups, fileinfo.Gid(), func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+
+	var target string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err = os.Readlink(fspath)
+		if err != nil {
+			records <- connectors.NewError(pathname, err)
+			return nil, err
+		}
+	}
+
+	var attrs []string
+	if !ls.noXattr {
+		attrs, err = xattr.LList(fspath)
+		if err != nil {
+			records <- connectors.NewError(pathname, err)
+		}
+	}
+
+	records <- connectors.NewRecord(pathname, target, fileinfo, attrs,
+		func() (io.ReadCloser, error) {
+			return os.Open(fspath)
+		})
+	for _, attr := range attrs {
+		records <- connectors.NewXattr(pathname, attr, objects.AttributeExtended,
+			func() (io.ReadCloser, error) {
+				data, err := xattr.LGet(fspath, attr)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(bytes.NewReader(data)), nil
+			})
+	}
+	return info, nil
+}
+
+func (ls *localStat) lookup(names map[uint64]string, id uint64, fn func(string) (string, error)) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	name, err := fn(strconv.FormatUint(id, 10))
+	if err != nil {
+		name = ""
+	}
+	names[id] = name
+	return name
+}
@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadChangeList(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	ctx.Stdin = strings.NewReader("/b/c\n\n/a//x/../y\r\n/b/c\n/a\n")
+	changes, err := readChangeList(ctx, "-")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/a", "/a/y", "/b/c"}, changes)
+
+	name := filepath.Join(t.TempDir(), "changes")
+	require.NoError(t, os.WriteFile(name, []byte("/z\nrelative/path\n"), 0644))
+	_, err = readChangeList(ctx, name)
+	require.ErrorContains(t, err, "not an absolute path: relative/path")
+
+	_, err = readChangeList(ctx, filepath.Join(t.TempDir(), "missing"))
+	require.ErrorContains(t, err, "failed to open change list")
+}
+
+func TestBackupChangedFromParse(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-changed-from", "-", "-cache", "no", "/tmp"}),
+		"-changed-from requires the vfs cache")
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-changed-from", "-", "-resume", "/tmp"}),
+		"-changed-from can't be used with -resume")
+
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-changed-from", "-", "/tmp"}))
+	require.Equal(t, "-", cmd.ChangedFrom)
+}
+
+func TestBackupChangedFrom(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", tmpBackupDir}))
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+	ptesting.WaitUnlocked(t, repo)
+
+	// changes listed: a modified file, a removed one and a new directory,
+	// the unlisted one isn't looked at and keeps its previous content.
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/subdir/foo.txt", []byte("hello new foo"), 0644))
+	require.NoError(t, os.Remove(tmpBackupDir+"/subdir/dummy.txt"))
+	require.NoError(t, os.MkdirAll(tmpBackupDir+"/new/deep", 0755))
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/new/deep/baz", []byte("hello baz"), 0644))
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/another_subdir/bar", []byte("unlisted"), 0644))
+
+	ctx.Stdin = strings.NewReader(strings.Join([]string{
+		tmpBackupDir + "/subdir/foo.txt",
+		tmpBackupDir + "/subdir/dummy.txt",
+		tmpBackupDir + "/new",
+		"/outside/of/the/source",
+	}, "\n"))
+
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-changed-from", "-", tmpBackupDir}))
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+
+	root := filepath.ToSlash(tmpBackupDir)
+	for name, content := range map[string]string{
+		"/subdir/foo.txt":     "hello new foo",
+		"/subdir/to_exclude":  "*/subdir/to_exclude\n",
+		"/new/deep/baz":       "hello baz",
+		"/another_subdir/bar": "hello bar",
+	} {
+		data, err := fs.ReadFile(pvfs, root+name)
+		require.NoError(t, err, name)
+		require.Equal(t, content, string(data), name)
+	}
+
+	_, err = pvfs.GetEntry(root + "/subdir/dummy.txt")
+	require.Error(t, err)
+}
+
+func TestBackupChangedFromAfterCheckpoint(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", tmpBackupDir}))
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+	ptesting.WaitUnlocked(t, repo)
+
+	// a run with -resume interrupted from the start leaves a checkpoint
+	// holding next to nothing, more recent than the complete backup
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-resume", "-no-progress", tmpBackupDir}))
+	require.True(t, cmd.Interrupt())
+	status, err, _, _ = cmd.DoBackup(ctx, repo)
+	require.ErrorIs(t, err, ErrInterrupted)
+	require.Equal(t, 1, status)
+	require.NoError(t, repo.RebuildState())
+	ptesting.WaitUnlocked(t, repo)
+
+	// the unlisted files are grafted from the complete backup
+	require.NoError(t, os.WriteFile(tmpBackupDir+"/subdir/foo.txt", []byte("hello new foo"), 0644))
+	ctx.Stdin = strings.NewReader(tmpBackupDir + "/subdir/foo.txt\n")
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-changed-from", "-", tmpBackupDir}))
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+
+	root := filepath.ToSlash(tmpBackupDir)
+	for name, content := range map[string]string{
+		"/subdir/foo.txt":     "hello new foo",
+		"/subdir/dummy.txt":   "hello dummy",
+		"/another_subdir/bar": "hello bar",
+	} {
+		data, err := fs.ReadFile(pvfs, root+name)
+		require.NoError(t, err, name)
+		require.Equal(t, content, string(data), name)
+	}
+}
+
+func TestBackupChangedFromSingleSource(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+
+	ctx.Stdin = strings.NewReader("")
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-changed-from", "-",
+		tmpBackupDir + "/subdir", tmpBackupDir + "/another_subdir"}))
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.ErrorContains(t, err, "-changed-from requires a single fs source")
+	require.Equal(t, 1, status)
+}
@@ -0,0 +1,236 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/compression"
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Estimate is what a backup would add to the store, computed by chunking
+// the files locally and looking up the chunks in the state.
+type Estimate struct {
+	Files       uint64 `json:"files"`
+	Directories uint64 `json:"directories"`
+	Symlinks    uint64 `json:"symlinks"`
+	Xattrs      uint64 `json:"xattrs"`
+	Errors      uint64 `json:"errors"`
+
+	// Size is the logical size of the files, of which NewSize isn't in
+	// the store yet and is expected to take StoredSize once compressed.
+	Size       uint64 `json:"size"`
+	NewSize    uint64 `json:"new_size"`
+	StoredSize uint64 `json:"stored_size"`
+	Chunks     uint64 `json:"chunks"`
+	NewChunks  uint64 `json:"new_chunks"`
+
+	// Classes breaks the files down by the first part of their MIME
+	// type, such as text or image.
+	Classes map[string]*ClassEstimate `json:"classes"`
+
+	seen map[objects.MAC]struct{}
+}
+
+type ClassEstimate struct {
+	Files uint64 `json:"files"`
+	Size  uint64 `json:"size"`
+}
+
+func newEstimate() *Estimate {
+	return &Estimate{
+		Classes: make(map[string]*ClassEstimate),
+		seen:    make(map[objects.MAC]struct{}),
+	}
+}
+
+// estimate adds the files of source to est.  The chunks appearing several
+// times, in the store or in the source, are only counted as new once.
+func estimate(ctx *appcontext.AppContext, repo *repository.Repository, source *snapshot.Source, est *Estimate) error {
+	cker, err := repo.Chunker(nil)
+	if err != nil {
+		return err
+	}
+
+	var algorithm string
+	if c := repo.Configuration().Compression; c != nil {
+		algorithm = c.Algorithm
+	}
+
+	for _, imp := range source.Importers() {
+		err := progress(ctx, imp, func(records <-chan *connectors.Record, results chan<- *connectors.Result) {
+			for record := range records {
+				isDir := record.Err == nil && record.FileInfo.Lmode.IsDir()
+				if source.GetExcludes().IsExcluded(record.Pathname, isDir) {
+					ack(record, results)
+					continue
+				}
+
+				switch {
+				case record.Err != nil:
+					est.Errors++
+				case record.IsXattr:
+					est.Xattrs++
+				case isDir:
+					est.Directories++
+				case record.FileInfo.Mode()&os.ModeSymlink != 0:
+					est.Symlinks++
+				case record.FileInfo.Mode().IsRegular():
+					if err := est.file(ctx, repo, cker, algorithm, record); err != nil {
+						est.Errors++
+					}
+				}
+				ack(record, results)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// chunker is the content defined chunker of the store.
+type chunker interface {
+	Reset(io.Reader)
+	Next() ([]byte, error)
+}
+
+func (est *Estimate) file(ctx *appcontext.AppContext, repo *repository.Repository, cker chunker, algorithm string, record *connectors.Record) error {
+	if record.Reader == nil {
+		return fmt.Errorf("%s: no content", record.Pathname)
+	}
+
+	var (
+		contentType string
+		size        uint64
+	)
+	cker.Reset(record.Reader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := cker.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(data) != 0 {
+			if contentType == "" {
+				contentType = mimetype.Detect(data).String()
+			}
+			size += uint64(len(data))
+			est.chunk(repo, algorithm, data)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if contentType == "" {
+		contentType = cmp.Or(mime.TypeByExtension(path.Ext(record.Pathname)), "application/octet-stream")
+	}
+	class, _, _ := strings.Cut(contentType, "/")
+
+	est.Files++
+	est.Size += size
+	if est.Classes[class] == nil {
+		est.Classes[class] = &ClassEstimate{}
+	}
+	est.Classes[class].Files++
+	est.Classes[class].Size += size
+	return nil
+}
+
+func (est *Estimate) chunk(repo *repository.Repository, algorithm string, data []byte) {
+	est.Chunks++
+
+	mac := repo.ComputeMAC(data)
+	if _, found := est.seen[mac]; found {
+		return
+	}
+	est.seen[mac] = struct{}{}
+	if repo.BlobExists(resources.RT_CHUNK, mac) {
+		return
+	}
+
+	est.NewChunks++
+	est.NewSize += uint64(len(data))
+	est.StoredSize += compressedSize(algorithm, data)
+}
+
+// compressedSize returns the size of data once compressed with algorithm,
+// or its size if it can't be compressed.
+func compressedSize(algorithm string, data []byte) uint64 {
+	if algorithm == "" {
+		return uint64(len(data))
+	}
+	rd, err := compression.DeflateStream(algorithm, bytes.NewReader(data))
+	if err != nil {
+		return uint64(len(data))
+	}
+	n, err := io.Copy(io.Discard, rd)
+	if err != nil {
+		return uint64(len(data))
+	}
+	return uint64(n)
+}
+
+func (est *Estimate) print(ctx *appcontext.AppContext) {
+	fmt.Fprintf(ctx.Stdout, "files: %d\n", est.Files)
+	fmt.Fprintf(ctx.Stdout, "directories: %d\n", est.Directories)
+	fmt.Fprintf(ctx.Stdout, "symlinks: %d\n", est.Symlinks)
+	fmt.Fprintf(ctx.Stdout, "xattrs: %d\n", est.Xattrs)
+	fmt.Fprintf(ctx.Stdout, "errors: %d\n", est.Errors)
+	fmt.Fprintf(ctx.Stdout, "size: %s\n", humanize.IBytes(est.Size))
+	fmt.Fprintf(ctx.Stdout, "new data: %s in %d/%d chunks, %s deduplicated\n",
+		humanize.IBytes(est.NewSize), est.NewChunks, est.Chunks, humanize.IBytes(est.Size-est.NewSize))
+	fmt.Fprintf(ctx.Stdout, "estimated stored size: %s\n", humanize.IBytes(est.StoredSize))
+
+	classes := make([]string, 0, len(est.Classes))
+	for class := range est.Classes {
+		classes = append(classes, class)
+	}
+	slices.SortFunc(classes, func(a, b string) int {
+		return cmp.Or(cmp.Compare(est.Classes[b].Size, est.Classes[a].Size), strings.Compare(a, b))
+	})
+	for _, class := range classes {
+		fmt.Fprintf(ctx.Stdout, "  %-12s %8d files %10s\n", class,
+			est.Classes[class].Files, humanize.IBytes(est.Classes[class].Size))
+	}
+}
+
+func (est *Estimate) write(ctx *appcontext.AppContext) error {
+	return utils.NewJSONWriter(ctx.Stdout).Write("estimate", est)
+}
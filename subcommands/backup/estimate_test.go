@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupEstimateParse(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-estimate", "-dry-run", "/tmp"}),
+		"-estimate and -dry-run can't be used together")
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-json", "/tmp"}),
+		"-json requires -estimate")
+}
+
+func TestCompressedSize(t *testing.T) {
+	data := bytes.Repeat([]byte("plakar"), 1024)
+	require.Equal(t, uint64(len(data)), compressedSize("", data))
+	require.Equal(t, uint64(len(data)), compressedSize("UNKNOWN", data))
+	require.Less(t, compressedSize("LZ4", data), uint64(len(data)))
+	require.Less(t, compressedSize("GZIP", data), uint64(len(data)))
+}
+
+func TestBackupEstimate(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	run := func(args ...string) *Estimate {
+		bufOut.Reset()
+		cmd := &Backup{}
+		require.NoError(t, cmd.Parse(ctx, append([]string{"-estimate", "-json"}, args...)))
+		status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		require.Zero(t, snapshotID)
+		ptesting.WaitUnlocked(t, repo)
+
+		var record struct {
+			utils.JSONRecord
+			Item Estimate `json:"item"`
+		}
+		require.NoError(t, json.Unmarshal(bufOut.Bytes(), &record))
+		require.Equal(t, "estimate", record.Type)
+		return &record.Item
+	}
+
+	// the fixture files are all new: foo.txt of 9 bytes, dummy.txt of 11
+	// bytes and to_exclude of 20 bytes
+	est := run(tmpBackupDir + "/subdir")
+	require.Equal(t, uint64(3), est.Files)
+	// subdir and the directories leading to it
+	require.Equal(t, uint64(strings.Count(filepath.ToSlash(tmpBackupDir), "/")+2), est.Directories)
+	require.Equal(t, uint64(9+11+20), est.Size)
+	require.Equal(t, est.Size, est.NewSize)
+	require.Equal(t, uint64(3), est.NewChunks)
+	require.NotZero(t, est.StoredSize)
+	require.Equal(t, uint64(3), est.Classes["text"].Files)
+
+	// nothing was committed
+	require.NoError(t, repo.RebuildState())
+	require.Equal(t, est.Size, run(tmpBackupDir+"/subdir").NewSize)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", tmpBackupDir + "/subdir"}))
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+	ptesting.WaitUnlocked(t, repo)
+
+	// once backed up, only another_subdir/bar is new
+	est = run(tmpBackupDir)
+	require.Equal(t, uint64(4), est.Files)
+	require.Equal(t, uint64(9+11+20+9), est.Size)
+	require.Equal(t, uint64(9), est.NewSize)
+	require.Equal(t, uint64(1), est.NewChunks)
+
+	// the summary in text
+	bufOut.Reset()
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-estimate", tmpBackupDir}))
+	status, err, _, _ = cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "files: 4\n")
+	require.Contains(t, bufOut.String(), "new data: 9 B in 1/4 chunks, 40 B deduplicated\n")
+	require.Contains(t, bufOut.String(), "estimated stored size:")
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// fsSnapshotKeys are the keys of a source configuration setting up its
+// filesystem snapshot, they are not passed to the importer.
+var fsSnapshotKeys = []string{"fs_snapshot", "fs_snapshot_create", "fs_snapshot_delete", "fs_snapshot_path"}
+
+// FSSnapshot describes how to take a filesystem snapshot of an fs source,
+// so that it's backed up as it was at a single point in time.  The
+// commands are run through the shell with the following variables set:
+//
+//	PLAKAR_FS_SOURCE	the path of the source
+//	PLAKAR_FS_SNAPSHOT	a name for the snapshot, unique to the backup
+//	PLAKAR_FS_MOUNT		an empty directory to mount the snapshot on
+//
+// Path, expanded with the same variables, is where Create makes the
+// snapshot of the source reachable.  Delete is always run once Create
+// was, even if it failed.
+type FSSnapshot struct {
+	Driver  string
+	Create  string
+	Delete  string
+	Path    string
+	Timeout time.Duration
+}
+
+// fsSnapshotDrivers take a snapshot of a btrfs subvolume, a ZFS dataset
+// or an LVM logical volume, the source must be where it's mounted.
+var fsSnapshotDrivers = map[string]FSSnapshot{
+	"btrfs": {
+		Create: `btrfs subvolume snapshot -r "$PLAKAR_FS_SOURCE" "$PLAKAR_FS_SOURCE/.$PLAKAR_FS_SNAPSHOT"`,
+		Delete: `btrfs subvolume delete "$PLAKAR_FS_SOURCE/.$PLAKAR_FS_SNAPSHOT"`,
+		Path:   "$PLAKAR_FS_SOURCE/.$PLAKAR_FS_SNAPSHOT",
+	},
+	"zfs": {
+		Create: `zfs snapshot "$(zfs list -H -o name "$PLAKAR_FS_SOURCE")@$PLAKAR_FS_SNAPSHOT"`,
+		Delete: `zfs destroy "$(zfs list -H -o name "$PLAKAR_FS_SOURCE")@$PLAKAR_FS_SNAPSHOT"`,
+		Path:   "$PLAKAR_FS_SOURCE/.zfs/snapshot/$PLAKAR_FS_SNAPSHOT",
+	},
+	"lvm": {
+		Create: `dev=$(findmnt -n -o SOURCE --mountpoint "$PLAKAR_FS_SOURCE") && ` +
+			`vg=$(lvs --noheadings -o vg_name "$dev" | tr -d ' ') && ` +
+			`lvcreate -q -s -l 10%ORIGIN -n "$PLAKAR_FS_SNAPSHOT" "$dev" && ` +
+			`mount -o ro "/dev/$vg/$PLAKAR_FS_SNAPSHOT" "$PLAKAR_FS_MOUNT"`,
+		Delete: `umount "$PLAKAR_FS_MOUNT"; ` +
+			`dev=$(findmnt -n -o SOURCE --mountpoint "$PLAKAR_FS_SOURCE") && ` +
+			`vg=$(lvs --noheadings -o vg_name "$dev" | tr -d ' ') && ` +
+			`lvremove -q -f "$vg/$PLAKAR_FS_SNAPSHOT"`,
+		Path: "$PLAKAR_FS_MOUNT",
+	},
+}
+
+// sourceFSSnapshot extracts the filesystem snapshot settings from the
+// configuration of a source, driver applies if it sets none.  It returns
+// nil if the source isn't to be snapshotted.
+func sourceFSSnapshot(source, driver string, opts map[string]string) (*FSSnapshot, error) {
+	if value, ok := opts["fs_snapshot"]; ok {
+		driver = value
+	}
+
+	snap := &FSSnapshot{Driver: driver}
+	if builtin, ok := fsSnapshotDrivers[driver]; ok {
+		snap.Create, snap.Delete, snap.Path = builtin.Create, builtin.Delete, builtin.Path
+	} else if driver != "" && driver != "custom" {
+		return nil, fmt.Errorf("%s: unknown fs snapshot driver %q", source, driver)
+	}
+
+	if value, ok := opts["fs_snapshot_create"]; ok {
+		snap.Create = value
+	}
+	if value, ok := opts["fs_snapshot_delete"]; ok {
+		snap.Delete = value
+	}
+	if value, ok := opts["fs_snapshot_path"]; ok {
+		snap.Path = value
+	}
+
+	for _, key := range fsSnapshotKeys {
+		delete(opts, key)
+	}
+
+	if driver == "" && snap.Create == "" {
+		return nil, nil
+	}
+	if snap.Create == "" || snap.Path == "" {
+		return nil, fmt.Errorf("%s: fs snapshot needs fs_snapshot_create and fs_snapshot_path", source)
+	}
+	return snap, nil
+}
+
+// fsSnapshotImporter imports an fs source from a snapshot of it, as if it
+// was imported from the source itself.
+type fsSnapshotImporter struct {
+	importer.Importer
+	snapshot *FSSnapshot
+	opts     *connectors.Options
+	config   map[string]string
+
+	// set once the snapshot is created
+	env   []string
+	mount string
+	inner importer.Importer
+}
+
+func newFSSnapshotImporter(imp importer.Importer, snapshot *FSSnapshot, opts *connectors.Options, config map[string]string) *fsSnapshotImporter {
+	// the excludes match the paths of the source, they are applied
+	// once the records are renamed
+	iopts := *opts
+	iopts.Excludes = nil
+
+	return &fsSnapshotImporter{
+		Importer: imp,
+		snapshot: snapshot,
+		opts:     &iopts,
+		config:   maps.Clone(config),
+	}
+}
+
+// create takes the snapshot, remove must be called afterwards even if it
+// fails.
+func (imp *fsSnapshotImporter) create(ctx *appcontext.AppContext) error {
+	var name [4]byte
+	rand.Read(name[:])
+
+	mount, err := os.MkdirTemp("", "plakar-fs-snapshot-")
+	if err != nil {
+		return err
+	}
+	imp.mount = mount
+
+	vars := map[string]string{
+		"PLAKAR_FS_SOURCE":   filepath.FromSlash(imp.Root()),
+		"PLAKAR_FS_SNAPSHOT": "plakar-" + hex.EncodeToString(name[:]),
+		"PLAKAR_FS_MOUNT":    mount,
+	}
+	for key, value := range vars {
+		imp.env = append(imp.env, key+"="+value)
+	}
+
+	ctx.GetLogger().Info("creating fs snapshot of %s", vars["PLAKAR_FS_SOURCE"])
+	if err := runShell(ctx, ctx, imp.snapshot.Create, imp.snapshot.Timeout, imp.env); err != nil {
+		return fmt.Errorf("failed to create fs snapshot of %s: %w", vars["PLAKAR_FS_SOURCE"], err)
+	}
+
+	snapPath := os.Expand(imp.snapshot.Path, func(key string) string { return vars[key] })
+	if info, err := os.Stat(snapPath); err != nil {
+		return fmt.Errorf("fs snapshot of %s: %w", vars["PLAKAR_FS_SOURCE"], err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("fs snapshot of %s: %s is not a directory", vars["PLAKAR_FS_SOURCE"], snapPath)
+	}
+
+	config := maps.Clone(imp.config)
+	config["location"] = "fs://" + snapPath
+	imp.inner, err = importer.NewImporter(ctx.GetInner(), imp.opts, config)
+	if err != nil {
+		return fmt.Errorf("fs snapshot of %s: %w", vars["PLAKAR_FS_SOURCE"], err)
+	}
+	return nil
+}
+
+// remove deletes the snapshot if it was created.
+func (imp *fsSnapshotImporter) remove(ctx *appcontext.AppContext) {
+	if imp.mount == "" {
+		return
+	}
+
+	if imp.inner != nil {
+		imp.inner.Close(ctx)
+		imp.inner = nil
+	}
+
+	// the snapshot is deleted even if the backup was interrupted
+	ctx.GetLogger().Info("deleting fs snapshot of %s", imp.Root())
+	if err := runShell(ctx, context.WithoutCancel(ctx), imp.snapshot.Delete, imp.snapshot.Timeout, imp.env); err != nil {
+		ctx.GetLogger().Warn("failed to delete fs snapshot of %s: %s", imp.Root(), err)
+	}
+	if err := os.Remove(imp.mount); err != nil {
+		ctx.GetLogger().Warn("failed to remove %s: %s", imp.mount, err)
+	}
+	imp.mount = ""
+}
+
+func (imp *fsSnapshotImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	if imp.inner == nil {
+		return fmt.Errorf("fs snapshot of %s not created", imp.Root())
+	}
+
+	var (
+		root      = imp.Root()
+		snapRoot  = imp.inner.Root()
+		ancestors = newLocalStat(imp.opts.NoXattr)
+	)
+
+	// the directories leading to the source are those of the
+	// filesystem, not of the snapshot
+	for dir := root; dir != "/"; {
+		dir = path.Dir(dir)
+		ancestors.stat(dir, records)
+	}
+
+	irecords := make(chan *connectors.Record)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- imp.inner.Import(ctx, irecords, nil)
+	}()
+
+	for record := range irecords {
+		switch {
+		case record.Pathname == snapRoot:
+			record.Pathname = root
+			record.FileInfo.Lname = path.Base(root)
+		case isBelow(snapRoot, record.Pathname):
+			record.Pathname = root + strings.TrimPrefix(record.Pathname, strings.TrimSuffix(snapRoot, "/"))
+		default:
+			// leading to the snapshot
+			record.Close()
+			continue
+		}
+		records <- record
+	}
+	return <-errc
+}
+
+// Reopen opens again a file of the source from the snapshot.
+func (imp *fsSnapshotImporter) Reopen(record *connectors.Record, offset int64) (io.ReadCloser, error) {
+	pathname := strings.TrimSuffix(imp.inner.Root(), "/") + strings.TrimPrefix(record.Pathname, strings.TrimSuffix(imp.Root(), "/"))
+	return openAt(filepath.FromSlash(pathname), offset)
+}
@@ -0,0 +1,251 @@
+package backup
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceFSSnapshot(t *testing.T) {
+	// no snapshot
+	opts := map[string]string{"location": "/data"}
+	snap, err := sourceFSSnapshot("/data", "", opts)
+	require.NoError(t, err)
+	require.Nil(t, snap)
+
+	// a builtin driver, from the flag
+	snap, err = sourceFSSnapshot("/data", "btrfs", opts)
+	require.NoError(t, err)
+	require.Equal(t, "btrfs", snap.Driver)
+	require.Equal(t, fsSnapshotDrivers["btrfs"].Create, snap.Create)
+	require.Equal(t, fsSnapshotDrivers["btrfs"].Path, snap.Path)
+
+	// the configuration of the source overrides the flag, and its keys
+	// aren't passed to the importer
+	opts = map[string]string{
+		"location":           "/data",
+		"fs_snapshot":        "zfs",
+		"fs_snapshot_delete": "true",
+	}
+	snap, err = sourceFSSnapshot("@data", "btrfs", opts)
+	require.NoError(t, err)
+	require.Equal(t, "zfs", snap.Driver)
+	require.Equal(t, fsSnapshotDrivers["zfs"].Create, snap.Create)
+	require.Equal(t, "true", snap.Delete)
+	require.Equal(t, map[string]string{"location": "/data"}, opts)
+
+	// custom commands
+	snap, err = sourceFSSnapshot("@data", "", map[string]string{
+		"fs_snapshot_create": "snap create",
+		"fs_snapshot_path":   "$PLAKAR_FS_MOUNT",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &FSSnapshot{Create: "snap create", Path: "$PLAKAR_FS_MOUNT"}, snap)
+
+	_, err = sourceFSSnapshot("@data", "", map[string]string{"fs_snapshot": "custom", "fs_snapshot_create": "snap create"})
+	require.ErrorContains(t, err, "@data: fs snapshot needs fs_snapshot_create and fs_snapshot_path")
+
+	_, err = sourceFSSnapshot("@data", "", map[string]string{"fs_snapshot": "xfs"})
+	require.ErrorContains(t, err, `@data: unknown fs snapshot driver "xfs"`)
+}
+
+func TestBackupFSSnapshotParse(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-fs-snapshot", "xfs", "/tmp"}),
+		`unknown fs snapshot driver "xfs"`)
+
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-fs-snapshot", "lvm", "/tmp"}))
+	require.Equal(t, "lvm", cmd.FSSnapshot)
+}
+
+// fsSnapshotMounts returns the directories mounting the snapshots.
+func fsSnapshotMounts(t *testing.T) []string {
+	mounts, err := filepath.Glob(filepath.Join(os.TempDir(), "plakar-fs-snapshot-*"))
+	require.NoError(t, err)
+	return mounts
+}
+
+func TestBackupFSSnapshot(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	// the "snapshot" is a copy of the source with a file added, which
+	// tells it apart from the source
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress",
+		"-o", `fs_snapshot_create=cp -R "$PLAKAR_FS_SOURCE/." "$PLAKAR_FS_MOUNT" && echo snap > "$PLAKAR_FS_MOUNT/only-in-snapshot"`,
+		"-o", `fs_snapshot_delete=rm -rf "$PLAKAR_FS_MOUNT"/* && echo "deleted $PLAKAR_FS_SNAPSHOT"`,
+		"-o", "fs_snapshot_path=$PLAKAR_FS_MOUNT",
+		tmpBackupDir}))
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "deleted plakar-")
+	require.Empty(t, fsSnapshotMounts(t))
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+
+	// the files are where they are in the source
+	root := filepath.ToSlash(tmpBackupDir)
+	for name, content := range map[string]string{
+		"/subdir/foo.txt":     "hello foo",
+		"/another_subdir/bar": "hello bar",
+		"/only-in-snapshot":   "snap\n",
+	} {
+		data, err := fs.ReadFile(pvfs, root+name)
+		require.NoError(t, err, name)
+		require.Equal(t, content, string(data), name)
+	}
+
+	entry, err := pvfs.GetEntry(root)
+	require.NoError(t, err)
+	require.True(t, entry.Stat().IsDir())
+	require.Equal(t, filepath.Base(tmpBackupDir), entry.Stat().Name())
+}
+
+func TestBackupFSSnapshotFailure(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	for _, test := range []struct {
+		create, path, err string
+	}{
+		{"exit 3", "$PLAKAR_FS_MOUNT", "failed to create fs snapshot of " + tmpBackupDir},
+		{"true", "$PLAKAR_FS_MOUNT/missing", "no such file or directory"},
+	} {
+		bufOut.Reset()
+		cmd := &Backup{}
+		require.NoError(t, cmd.Parse(ctx, []string{"-no-progress",
+			"-fail-hook", `echo "fail: $PLAKAR_ERROR"`,
+			"-o", "fs_snapshot_create=" + test.create,
+			"-o", "fs_snapshot_delete=echo deleted",
+			"-o", "fs_snapshot_path=" + test.path,
+			tmpBackupDir}))
+		status, err, _, _ := cmd.DoBackup(ctx, repo)
+		require.ErrorContains(t, err, test.err)
+		require.Equal(t, 1, status)
+		ptesting.WaitUnlocked(t, repo)
+
+		// the snapshot is deleted before the fail hook runs
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(bufOut.String()), "\n") {
+			if !strings.HasPrefix(line, "info: ") {
+				lines = append(lines, line)
+			}
+		}
+		require.Len(t, lines, 2)
+		require.Equal(t, "deleted", lines[0])
+		require.True(t, strings.HasPrefix(lines[1], "fail: "), lines[1])
+		require.Empty(t, fsSnapshotMounts(t))
+	}
+}
+
+func TestBackupFSSnapshotNotFS(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-fs-snapshot", "btrfs", "errortest:///"}))
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.ErrorContains(t, err, "errortest:///: fs snapshots only apply to fs sources")
+	require.Equal(t, 1, status)
+}
+
+// TestBackupFSSnapshotBtrfs backs up a btrfs subvolume of a loopback
+// image, it needs to be root with the btrfs tools installed.
+func TestBackupFSSnapshotBtrfs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("not root")
+	}
+	for _, tool := range []string{"mkfs.btrfs", "btrfs", "mount", "umount"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	image := filepath.Join(t.TempDir(), "btrfs.img")
+	mnt := t.TempDir()
+	require.NoError(t, os.WriteFile(image, nil, 0600))
+	require.NoError(t, os.Truncate(image, 128<<20))
+	if out, err := exec.Command("mkfs.btrfs", "-q", image).CombinedOutput(); err != nil {
+		t.Skipf("mkfs.btrfs: %s: %s", err, out)
+	}
+	if out, err := exec.Command("mount", "-o", "loop", image, mnt).CombinedOutput(); err != nil {
+		t.Skipf("mount: %s: %s", err, out)
+	}
+	t.Cleanup(func() { exec.Command("umount", mnt).Run() })
+
+	subvol := filepath.Join(mnt, "data")
+	out, err := exec.Command("btrfs", "subvolume", "create", subvol).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.NoError(t, os.WriteFile(filepath.Join(subvol, "file"), []byte("hello btrfs"), 0644))
+
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-fs-snapshot", "btrfs", subvol}))
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, repo.RebuildState())
+
+	// the snapshot subvolume is gone
+	entries, err := os.ReadDir(subvol)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+	data, err := fs.ReadFile(pvfs, filepath.ToSlash(subvol)+"/file")
+	require.NoError(t, err)
+	require.Equal(t, "hello btrfs", string(data))
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+const (
+	PreHookAbort = "abort"
+	PreHookWarn  = "warn"
+)
+
+// hookKeys are the keys of a source configuration holding its hooks, they
+// are not passed to the importer.
+var hookKeys = []string{"pre_hook", "post_hook", "fail_hook", "hook_timeout", "pre_hook_failure"}
+
+// Hooks are the commands run before the backup, after it succeeded and
+// after it failed.  They are either set on the command line, applying to
+// the whole backup, or in the configuration of a source.
+type Hooks struct {
+	Source     string
+	Pre        string
+	Post       string
+	Fail       string
+	Timeout    time.Duration
+	PreFailure string
+
+	// index of the source in the snapshot, -1 for the whole backup.
+	index int
+}
+
+func checkPreFailure(value string) error {
+	if value != PreHookAbort && value != PreHookWarn {
+		return fmt.Errorf("invalid pre-hook failure mode %q, must be %s or %s",
+			value, PreHookAbort, PreHookWarn)
+	}
+	return nil
+}
+
+// sourceHooks extracts the hooks from the configuration of a source, it
+// returns nil if the source has none.
+func sourceHooks(source string, opts map[string]string) (*Hooks, error) {
+	hooks := &Hooks{
+		Source:     source,
+		Pre:        opts["pre_hook"],
+		Post:       opts["post_hook"],
+		Fail:       opts["fail_hook"],
+		PreFailure: PreHookAbort,
+	}
+
+	if value, ok := opts["hook_timeout"]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("%s: invalid hook_timeout %q", source, value)
+		}
+		hooks.Timeout = timeout
+	}
+	if value, ok := opts["pre_hook_failure"]; ok {
+		if err := checkPreFailure(value); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		hooks.PreFailure = value
+	}
+
+	for _, key := range hookKeys {
+		delete(opts, key)
+	}
+
+	if hooks.Pre == "" && hooks.Post == "" && hooks.Fail == "" {
+		return nil, nil
+	}
+	return hooks, nil
+}
+
+// hookRun describes the backup to the post and fail hooks.
+type hookRun struct {
+	hdr   *header.Header
+	start time.Time
+	err   error
+}
+
+// errors counts the errors of the sources covered by the hooks.
+func (h *Hooks) errors(hdr *header.Header) uint64 {
+	if hdr == nil {
+		return 0
+	}
+	var count uint64
+	for i := range hdr.Sources {
+		if h.index == -1 || h.index == i {
+			s := hdr.GetSource(i)
+			count += s.Summary.Directory.Errors + s.Summary.Below.Errors
+		}
+	}
+	return count
+}
+
+func (h *Hooks) env(stage string, run *hookRun) []string {
+	env := []string{
+		"PLAKAR_HOOK=" + stage,
+		"PLAKAR_SOURCE=" + h.Source,
+	}
+	if run == nil {
+		return env
+	}
+
+	env = append(env,
+		"PLAKAR_ERRORS="+strconv.FormatUint(h.errors(run.hdr), 10),
+		"PLAKAR_DURATION="+strconv.FormatFloat(time.Since(run.start).Seconds(), 'f', 3, 64))
+	if run.hdr != nil && run.err == nil {
+		env = append(env, fmt.Sprintf("PLAKAR_SNAPSHOT_ID=%x", run.hdr.Identifier))
+	}
+	if run.err != nil {
+		env = append(env, "PLAKAR_ERROR="+run.err.Error())
+	}
+	return env
+}
+
+// runPreHooks runs the pre hooks in order.  If one fails and must abort
+// the backup, the fail hooks of those that already ran are executed.
+func runPreHooks(ctx *appcontext.AppContext, hooks []*Hooks) error {
+	for i, h := range hooks {
+		err := executeHook(ctx, h.Pre, h.Timeout, h.env("pre", nil))
+		if err == nil {
+			continue
+		}
+		if h.PreFailure == PreHookWarn {
+			ctx.GetLogger().Warn("pre-backup hook failed: %s", err)
+			continue
+		}
+		err = fmt.Errorf("pre-backup hook failed: %w", err)
+		runFailHooks(ctx, hooks[:i+1], &hookRun{start: time.Now(), err: err})
+		return err
+	}
+	return nil
+}
+
+// runPostHooks runs the post hooks in the reverse order of the pre hooks.
+func runPostHooks(ctx *appcontext.AppContext, hooks []*Hooks, run *hookRun) {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if err := executeHook(ctx, h.Post, h.Timeout, h.env("post", run)); err != nil {
+			ctx.GetLogger().Warn("post-backup hook failed: %s", err)
+		}
+	}
+}
+
+// runFailHooks runs the fail hooks in the reverse order of the pre hooks.
+func runFailHooks(ctx *appcontext.AppContext, hooks []*Hooks, run *hookRun) {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if err := executeHook(ctx, h.Fail, h.Timeout, h.env("fail", run)); err != nil {
+			ctx.GetLogger().Warn("post-backup fail hook failed: %s", err)
+		}
+	}
+}
+
+// executeHook runs a hook through the shell.  It is killed when the
+// context is canceled or when it runs for longer than timeout, if set.
+func executeHook(ctx *appcontext.AppContext, hook string, timeout time.Duration, env []string) error {
+	if hook == "" {
+		return nil
+	}
+	ctx.GetLogger().Info("executing hook: %s", hook)
+	return runShell(ctx, ctx, hook, timeout, env)
+}
+
+// runShell runs a command through the shell with the output of ctx.  It is
+// killed when runCtx is canceled or when it runs for longer than timeout,
+// if set.
+func runShell(ctx *appcontext.AppContext, runCtx context.Context, hook string, timeout time.Duration, env []string) error {
+	hookCtx := runCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		hookCtx, cancel = context.WithTimeout(runCtx, timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.CommandContext(hookCtx, "cmd", "/C", hook)
+	default: // assume unix-esque
+		cmd = exec.CommandContext(hookCtx, "/bin/sh", "-c", hook)
+	}
+
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = ctx.Stdout
+	cmd.Stderr = ctx.Stderr
+	// don't wait forever on children of the hook keeping its output open
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if errors.Is(hookCtx.Err(), context.DeadlineExceeded) && runCtx.Err() == nil {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/config"
+	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceHooks(t *testing.T) {
+	opts := map[string]string{
+		"location":         "fs:/var/db",
+		"pre_hook":         "db-freeze",
+		"post_hook":        "db-thaw",
+		"hook_timeout":     "30s",
+		"pre_hook_failure": "warn",
+	}
+	hooks, err := sourceHooks("@db", opts)
+	require.NoError(t, err)
+	require.Equal(t, "@db", hooks.Source)
+	require.Equal(t, "db-freeze", hooks.Pre)
+	require.Equal(t, "db-thaw", hooks.Post)
+	require.Equal(t, 30*time.Second, hooks.Timeout)
+	require.Equal(t, PreHookWarn, hooks.PreFailure)
+	// the importer doesn't see the hooks
+	require.Equal(t, map[string]string{"location": "fs:/var/db"}, opts)
+
+	hooks, err = sourceHooks("@db", map[string]string{"location": "fs:/var/db"})
+	require.NoError(t, err)
+	require.Nil(t, hooks)
+
+	_, err = sourceHooks("@db", map[string]string{"pre_hook": "x", "hook_timeout": "soon"})
+	require.ErrorContains(t, err, "invalid hook_timeout")
+
+	_, err = sourceHooks("@db", map[string]string{"pre_hook": "x", "pre_hook_failure": "ignore"})
+	require.ErrorContains(t, err, "invalid pre-hook failure mode")
+}
+
+func TestExecuteHookTimeout(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.SetLogger(logging.NewLogger(io.Discard, io.Discard))
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufOut
+
+	begin := time.Now()
+	err := executeHook(ctx, "sleep 10", 100*time.Millisecond, nil)
+	require.ErrorContains(t, err, "timed out after 100ms")
+	require.Less(t, time.Since(begin), 5*time.Second)
+
+	require.NoError(t, executeHook(ctx, `echo "$PLAKAR_HOOK"`, time.Minute, []string{"PLAKAR_HOOK=pre"}))
+	require.Equal(t, "pre\n", bufOut.String())
+}
+
+func TestBackupParseHooks(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-pre-hook", "freeze", "-post-hook", "thaw",
+		"-fail-hook", "alert", "-hook-timeout", "1m", "-pre-hook-failure", "warn", "/tmp"}))
+	require.Equal(t, "freeze", cmd.PreHook)
+	require.Equal(t, "thaw", cmd.PostHook)
+	require.Equal(t, "alert", cmd.FailHook)
+	require.Equal(t, time.Minute, cmd.HookTimeout)
+	require.Equal(t, PreHookWarn, cmd.PreHookFailure)
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-pre-hook-failure", "ignore", "/tmp"}),
+		"invalid pre-hook failure mode")
+}
+
+func TestBackupHooksEnvironment(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{tmpBackupDir}))
+	cmd.PreHook = `echo "pre: $PLAKAR_HOOK $PLAKAR_SOURCE"`
+	cmd.PostHook = `echo "post: $PLAKAR_HOOK id=$PLAKAR_SNAPSHOT_ID errors=$PLAKAR_ERRORS duration=$PLAKAR_DURATION"`
+
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Contains(t, output, "pre: pre "+tmpBackupDir+"\n")
+	require.Contains(t, output, fmt.Sprintf("post: post id=%x errors=0 duration=", snapshotID))
+}
+
+func TestBackupPreHookWarn(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-pre-hook-failure", "warn", tmpBackupDir}))
+	cmd.PreHook = "exit 3"
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufErr.String(), "pre-backup hook failed")
+}
+
+func TestBackupPreHookAbortRunsFailHook(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{tmpBackupDir}))
+	cmd.PreHook = "exit 7"
+	cmd.PostHook = "echo post-hook executed"
+	cmd.FailHook = `echo "fail: $PLAKAR_ERROR"`
+
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufOut.String(), "fail: pre-backup hook failed: exit status 7")
+	require.NotContains(t, bufOut.String(), "post-hook executed")
+}
+
+func TestBackupSourceHooks(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	ctx.Config = config.NewConfig()
+	ctx.Config.Sources["db"] = map[string]string{
+		"location":  "fs:" + tmpBackupDir,
+		"pre_hook":  `echo "freeze $PLAKAR_SOURCE"`,
+		"post_hook": `echo "thaw $PLAKAR_SOURCE"`,
+	}
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"@db"}))
+	cmd.PreHook = "echo global pre"
+	cmd.PostHook = "echo global post"
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the source hooks run within the global ones
+	var order []string
+	for _, line := range strings.Split(bufOut.String(), "\n") {
+		switch line {
+		case "global pre", "freeze @db", "thaw @db", "global post":
+			order = append(order, line)
+		}
+	}
+	require.Equal(t, []string{"global pre", "freeze @db", "thaw @db", "global post"}, order)
+}
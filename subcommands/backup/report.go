@@ -0,0 +1,316 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// Classes of the errors of the report.
+const (
+	ErrorNotFound   = "not-found"
+	ErrorPermission = "permission"
+	ErrorTransient  = "transient"
+	ErrorIO         = "io"
+	ErrorCanceled   = "canceled"
+	ErrorOther      = "other"
+)
+
+// transientErrors are worth retrying, the entry may be readable later.
+var transientErrors = []error{
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.EINTR,
+	syscall.ETIMEDOUT,
+	syscall.ETXTBSY,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED,
+	os.ErrDeadlineExceeded,
+}
+
+func isTransient(err error) bool {
+	for _, target := range transientErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// errorClass returns the class of err, telling the errors that need to
+// be fixed on the source apart from those a new run may not hit.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorCanceled
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrorNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrorPermission
+	case isTransient(err):
+		return ErrorTransient
+	case errors.Is(err, syscall.EIO):
+		return ErrorIO
+	default:
+		return ErrorOther
+	}
+}
+
+// ReportEntry is an entry which couldn't be backed up.
+type ReportEntry struct {
+	Path     string `json:"path"`
+	Xattr    string `json:"xattr,omitempty"`
+	Error    string `json:"error"`
+	Class    string `json:"class"`
+	Attempts int    `json:"attempts"`
+}
+
+// ErrorReport gathers the errors of the entries of a backup as they are
+// imported.  An entry failing again, as when the sources are imported
+// again after a checkpoint, is only reported once, with its last error.
+type ErrorReport struct {
+	mu      sync.Mutex
+	entries []ReportEntry
+	index   map[[2]string]int
+}
+
+func (r *ErrorReport) add(record *connectors.Record, err error, attempts int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := ReportEntry{
+		Path:     record.Pathname,
+		Xattr:    record.XattrName,
+		Error:    err.Error(),
+		Class:    errorClass(err),
+		Attempts: attempts,
+	}
+
+	key := [2]string{entry.Path, entry.Xattr}
+	if i, ok := r.index[key]; ok {
+		r.entries[i] = entry
+		return
+	}
+	if r.index == nil {
+		r.index = make(map[[2]string]int)
+	}
+	r.index[key] = len(r.entries)
+	r.entries = append(r.entries, entry)
+}
+
+func (r *ErrorReport) Entries() []ReportEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries
+}
+
+// log emits the report as warnings.
+func (r *ErrorReport) log(ctx *appcontext.AppContext) {
+	for _, entry := range r.Entries() {
+		pathname := entry.Path
+		if entry.Xattr != "" {
+			pathname += ":" + entry.Xattr
+		}
+		ctx.GetLogger().Warn("%s: %s [%s]", pathname, entry.Error, entry.Class)
+	}
+}
+
+// write outputs the report in JSON to name, or the standard output if
+// name is "-".
+func (r *ErrorReport) write(ctx *appcontext.AppContext, name string) error {
+	w := ctx.Stdout
+	if name != "-" {
+		fp, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		w = fp
+	}
+
+	out := utils.NewJSONWriter(w)
+	for _, entry := range r.Entries() {
+		if err := out.Write("error", entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reopener is implemented by the importers able to open the content of
+// an entry again, at offset, after a transient error.  Their reads are
+// retried with -retry.
+type Reopener interface {
+	Reopen(record *connectors.Record, offset int64) (io.ReadCloser, error)
+}
+
+// reopenFile opens again a file of the fs importer.
+func reopenFile(record *connectors.Record, offset int64) (io.ReadCloser, error) {
+	return openAt(filepath.FromSlash(record.Pathname), offset)
+}
+
+func openAt(name string, offset int64) (io.ReadCloser, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if offset != 0 {
+		if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+			fp.Close()
+			return nil, err
+		}
+	}
+	return fp, nil
+}
+
+// retryDelay is the delay before the first retry, doubled on each one.
+var retryDelay = 100 * time.Millisecond
+
+// reportImporter adds the errors of the entries imported to a report,
+// after retrying the transient errors on their content.
+type reportImporter struct {
+	importer.Importer
+	report  *ErrorReport
+	retries int
+}
+
+func newReportImporter(imp importer.Importer, report *ErrorReport, retries int) *reportImporter {
+	return &reportImporter{Importer: imp, report: report, retries: retries}
+}
+
+func (imp *reportImporter) reopener() func(*connectors.Record, int64) (io.ReadCloser, error) {
+	if imp.retries == 0 {
+		return nil
+	}
+	if r, ok := imp.Importer.(Reopener); ok {
+		return r.Reopen
+	}
+	if imp.Type() == "fs" {
+		return reopenFile
+	}
+	return nil
+}
+
+func (imp *reportImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	reopen := imp.reopener()
+	irecords := make(chan *connectors.Record)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- imp.Importer.Import(ctx, irecords, results)
+	}()
+
+	for record := range irecords {
+		switch {
+		case record.Err != nil:
+			imp.report.add(record, record.Err, 1)
+		case record.Reader != nil:
+			rd := &retryReader{
+				ctx:     ctx,
+				record:  record,
+				rd:      record.Reader,
+				report:  imp.report,
+				retries: imp.retries,
+			}
+			// symlinks and directories have no content to retry
+			if record.IsXattr || record.FileInfo.Mode().IsRegular() {
+				rd.reopen = reopen
+			}
+			record.Reader = rd
+		}
+		records <- record
+	}
+	return <-errc
+}
+
+// retryReader reads the content of an entry, opening it again after a
+// transient error, and adds the error it gives up on to the report.
+type retryReader struct {
+	ctx     context.Context
+	record  *connectors.Record
+	rd      io.ReadCloser
+	reopen  func(*connectors.Record, int64) (io.ReadCloser, error)
+	report  *ErrorReport
+	retries int
+	offset  int64
+	failed  bool
+}
+
+func (r *retryReader) Read(p []byte) (int, error) {
+	for attempt := 1; ; attempt++ {
+		var (
+			n   int
+			err error
+		)
+		if r.rd == nil {
+			r.rd, err = r.reopen(r.record, r.offset)
+		}
+		if err == nil {
+			n, err = r.rd.Read(p)
+			r.offset += int64(n)
+		}
+		if n != 0 && err != io.EOF {
+			// the error, if any, shows again on the next read
+			return n, nil
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		if r.reopen == nil || attempt > r.retries || !isTransient(err) {
+			if !r.failed {
+				r.failed = true
+				r.report.add(r.record, err, attempt)
+			}
+			return 0, err
+		}
+
+		if r.rd != nil {
+			r.rd.Close()
+			r.rd = nil
+		}
+		timer := time.NewTimer(retryDelay << (attempt - 1))
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return 0, r.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *retryReader) Close() error {
+	if r.rd == nil {
+		return nil
+	}
+	return r.rd.Close()
+}
@@ -0,0 +1,260 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+const flakyContent = "content of a flaky file"
+
+// flakyOpens counts the opens of /flaky by the errortest importer, the
+// first one fails with EAGAIN.
+var flakyOpens atomic.Int64
+
+func init() {
+	importer.Register("errortest", 0, newErrorTestImporter)
+}
+
+type errorTestImporter struct {
+	origin string
+}
+
+func newErrorTestImporter(ctx context.Context, opts *connectors.Options, name string, config map[string]string) (importer.Importer, error) {
+	return &errorTestImporter{origin: opts.Hostname}, nil
+}
+
+func (p *errorTestImporter) Type() string                    { return "errortest" }
+func (p *errorTestImporter) Root() string                    { return "/" }
+func (p *errorTestImporter) Origin() string                  { return p.origin }
+func (p *errorTestImporter) Flags() location.Flags           { return 0 }
+func (p *errorTestImporter) Ping(ctx context.Context) error  { return nil }
+func (p *errorTestImporter) Close(ctx context.Context) error { return nil }
+
+func (p *errorTestImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	fi := objects.FileInfo{Lname: "/", Lmode: fs.ModeDir | 0755, LmodTime: modTime, Lnlink: 1}
+	records <- connectors.NewRecord("/", "", fi, nil, nil)
+
+	records <- connectors.NewError("/denied", &fs.PathError{Op: "open", Path: "/denied", Err: syscall.EACCES})
+
+	fi = objects.FileInfo{Lname: "flaky", Lmode: 0644, Lsize: int64(len(flakyContent)), LmodTime: modTime, Lnlink: 1}
+	records <- connectors.NewRecord("/flaky", "", fi, nil, func() (io.ReadCloser, error) {
+		if flakyOpens.Add(1) == 1 {
+			return nil, &fs.PathError{Op: "open", Path: "/flaky", Err: syscall.EAGAIN}
+		}
+		return io.NopCloser(strings.NewReader(flakyContent)), nil
+	})
+	return nil
+}
+
+func (p *errorTestImporter) Reopen(record *connectors.Record, offset int64) (io.ReadCloser, error) {
+	flakyOpens.Add(1)
+	return io.NopCloser(strings.NewReader(flakyContent[offset:])), nil
+}
+
+func TestErrorClass(t *testing.T) {
+	for err, class := range map[error]string{
+		&fs.PathError{Op: "open", Path: "/x", Err: syscall.ENOENT}: ErrorNotFound,
+		&fs.PathError{Op: "open", Path: "/x", Err: syscall.EACCES}: ErrorPermission,
+		&fs.PathError{Op: "read", Path: "/x", Err: syscall.EAGAIN}: ErrorTransient,
+		&fs.PathError{Op: "read", Path: "/x", Err: syscall.EBUSY}:  ErrorTransient,
+		fmt.Errorf("read: %w", os.ErrDeadlineExceeded):             ErrorTransient,
+		&fs.PathError{Op: "read", Path: "/x", Err: syscall.EIO}:    ErrorIO,
+		fmt.Errorf("import: %w", context.Canceled):                 ErrorCanceled,
+		fmt.Errorf("something else"):                               ErrorOther,
+	} {
+		require.Equal(t, class, errorClass(err), err.Error())
+	}
+}
+
+// failingReader returns its content in two reads, failing with err in
+// between.
+type failingReader struct {
+	content string
+	err     error
+	reads   int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	r.reads++
+	switch r.reads {
+	case 1:
+		return copy(p, r.content[:len(r.content)/2]), nil
+	case 2:
+		return 0, r.err
+	default:
+		return 0, io.EOF
+	}
+}
+
+func (r *failingReader) Close() error { return nil }
+
+func TestRetryReader(t *testing.T) {
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = 100 * time.Millisecond })
+
+	content := "0123456789"
+	reopen := func(record *connectors.Record, offset int64) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(content[offset:])), nil
+	}
+	record := &connectors.Record{Pathname: "/file"}
+
+	// a transient error is retried where it happened
+	report := &ErrorReport{}
+	rd := &retryReader{
+		ctx:     context.Background(),
+		record:  record,
+		rd:      &failingReader{content: content, err: syscall.EAGAIN},
+		reopen:  reopen,
+		report:  report,
+		retries: 1,
+	}
+	data, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+	require.Empty(t, report.Entries())
+
+	// the others are reported right away
+	rd = &retryReader{
+		ctx:     context.Background(),
+		record:  record,
+		rd:      &failingReader{content: content, err: syscall.EIO},
+		reopen:  reopen,
+		report:  report,
+		retries: 3,
+	}
+	_, err = io.ReadAll(rd)
+	require.ErrorIs(t, err, syscall.EIO)
+	require.Equal(t, []ReportEntry{{Path: "/file", Error: syscall.EIO.Error(), Class: ErrorIO, Attempts: 1}},
+		report.Entries())
+
+	// and so are the transient ones once out of retries
+	report = &ErrorReport{}
+	rd = &retryReader{
+		ctx:    context.Background(),
+		record: record,
+		rd:     &failingReader{content: content, err: syscall.EAGAIN},
+		reopen: func(record *connectors.Record, offset int64) (io.ReadCloser, error) {
+			return nil, syscall.EAGAIN
+		},
+		report:  report,
+		retries: 2,
+	}
+	_, err = io.ReadAll(rd)
+	require.ErrorIs(t, err, syscall.EAGAIN)
+	require.Len(t, report.Entries(), 1)
+	require.Equal(t, ErrorTransient, report.Entries()[0].Class)
+	require.Equal(t, 3, report.Entries()[0].Attempts)
+}
+
+func TestErrorReportOncePerEntry(t *testing.T) {
+	report := &ErrorReport{}
+	report.add(&connectors.Record{Pathname: "/file"}, syscall.EIO, 1)
+	report.add(&connectors.Record{Pathname: "/file", XattrName: "user.tag"}, syscall.EIO, 1)
+	report.add(&connectors.Record{Pathname: "/other"}, syscall.EIO, 1)
+
+	// as when the sources are imported again after a checkpoint
+	report.add(&connectors.Record{Pathname: "/file"}, syscall.EAGAIN, 2)
+	require.Equal(t, []ReportEntry{
+		{Path: "/file", Error: syscall.EAGAIN.Error(), Class: ErrorTransient, Attempts: 2},
+		{Path: "/file", Xattr: "user.tag", Error: syscall.EIO.Error(), Class: ErrorIO, Attempts: 1},
+		{Path: "/other", Error: syscall.EIO.Error(), Class: ErrorIO, Attempts: 1},
+	}, report.Entries())
+}
+
+func TestBackupRetryParse(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"/tmp"}))
+	require.Equal(t, 0, cmd.Retry)
+	require.Equal(t, -1, cmd.MaxErrors)
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-retry", "-1", "/tmp"}), "invalid number of retries")
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-max-errors", "-2", "/tmp"}), "invalid number of errors")
+}
+
+func TestBackupErrorReport(t *testing.T) {
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = 100 * time.Millisecond })
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	// the flaky file is read on the second attempt, the denied one is
+	// reported and fails the backup
+	flakyOpens.Store(0)
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-retry", "2", "-max-errors", "0",
+		"-fail-hook", `echo "fail: $PLAKAR_ERROR"`, "errortest:///"}))
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.EqualError(t, err, "1 errors during backup, more than the 0 allowed")
+	require.Equal(t, 1, status)
+	require.Equal(t, int64(2), flakyOpens.Load())
+	require.Contains(t, bufOut.String(), "fail: 1 errors during backup, more than the 0 allowed")
+	require.Contains(t, bufErr.String(), "/denied: open /denied: permission denied [permission]")
+	ptesting.WaitUnlocked(t, repo)
+	require.NotContains(t, bufErr.String(), "/flaky:")
+
+	// without retries, the flaky file is reported too
+	flakyOpens.Store(0)
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", "-max-errors", "2",
+		"-error-report", reportFile, "errortest:///"}))
+	status, err, _, warning := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.EqualError(t, warning, "2 errors during backup")
+
+	fp, err := os.Open(reportFile)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	var entries []ReportEntry
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var record struct {
+			Type string      `json:"type"`
+			Item ReportEntry `json:"item"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		require.Equal(t, "error", record.Type)
+		entries = append(entries, record.Item)
+	}
+	require.NoError(t, scanner.Err())
+	require.ElementsMatch(t, []ReportEntry{
+		{Path: "/denied", Error: "open /denied: permission denied", Class: ErrorPermission, Attempts: 1},
+		{Path: "/flaky", Error: "open /flaky: resource temporarily unavailable", Class: ErrorTransient, Attempts: 1},
+	}, entries)
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// DefaultCheckpointInterval is how often a backup run with -resume commits
+// a checkpoint.
+const DefaultCheckpointInterval = 30 * time.Minute
+
+var ErrInterrupted = errors.New("backup interrupted, run it again with -resume to carry on")
+
+// Interrupt stops a backup run with -resume after the paths being
+// processed are done, so that what was backed up is committed as a
+// checkpoint.
+func (cmd *Backup) Interrupt() bool {
+	if !cmd.Resume || cmd.interrupt == nil {
+		return false
+	}
+	cmd.interruptOnce.Do(func() { close(cmd.interrupt) })
+	return true
+}
+
+func (cmd *Backup) interrupted() bool {
+	select {
+	case <-cmd.interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// startPass starts a pass over the sources, it is stopped once the
+// backup is interrupted or, if every isn't zero, once it elapsed so that
+// a checkpoint is committed.  The returned function ends the pass.
+func (cmd *Backup) startPass(every time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	cmd.stop = stop
+	cmd.cut.Store(false)
+
+	go func() {
+		defer close(exited)
+
+		var elapsed <-chan time.Time
+		if every > 0 {
+			timer := time.NewTimer(every)
+			defer timer.Stop()
+			elapsed = timer.C
+		}
+		select {
+		case <-cmd.interrupt:
+		case <-elapsed:
+		case <-done:
+			return
+		}
+		close(stop)
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// stopping returns the channel closed when the current pass stops.
+func (cmd *Backup) stopping() <-chan struct{} {
+	return cmd.stop
+}
+
+// cutShort records that a source of the current pass was stopped before
+// its end, so that another pass is needed.
+func (cmd *Backup) cutShort() {
+	cmd.cut.Store(true)
+}
+
+// checkpoint commits what was backed up so far as a checkpoint, which
+// supersedes the previous ones, and returns it.
+func checkpoint(ctx *appcontext.AppContext, repo *repository.Repository, snap *snapshot.Builder, previous []objects.MAC) (objects.MAC, error) {
+	snap.Header.Tags = append(snap.Header.Tags, utils.CheckpointTag)
+	if err := snap.Commit(); err != nil {
+		return objects.MAC{}, fmt.Errorf("failed to commit checkpoint: %w", err)
+	}
+	ctx.GetLogger().Info("created checkpoint %x", snap.Header.GetIndexShortID())
+
+	for _, id := range previous {
+		if err := repo.DeleteSnapshot(id); err != nil {
+			ctx.GetLogger().Warn("failed to remove checkpoint %x: %s", id[:4], err)
+		}
+	}
+
+	// the next pass looks the checkpoint up
+	if err := repo.RebuildState(); err != nil {
+		return objects.MAC{}, err
+	}
+	return snap.Header.Identifier, nil
+}
+
+// checkpoints returns the checkpoints holding the given source, the most
+// recent first.
+func checkpoints(repo *repository.Repository, source *snapshot.Source) ([]objects.MAC, error) {
+	filters := locate.LocateFilters{
+		Tags:    []string{utils.CheckpointTag},
+		Roots:   []string{source.Root()},
+		Types:   []string{source.Type()},
+		Origins: []string{source.Origin()},
+	}
+
+	all, _, err := locate.Match(repo, &locate.LocateOptions{Filters: filters})
+	if err != nil || len(all) == 0 {
+		return nil, err
+	}
+
+	filters.Latest = true
+	ids, _, err := locate.Match(repo, &locate.LocateOptions{Filters: filters})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range all {
+		if id != ids[0] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// checkpointImporter stops importing when the pass is stopped and reports
+// a success, the records not passed to the snapshot yet are dropped.
+type checkpointImporter struct {
+	importer.Importer
+	stop        func() <-chan struct{}
+	interrupted func() bool
+	cut         func()
+
+	// The records passed by the previous import.  Unless the backup is
+	// interrupted, an import only stops once it passed more, otherwise a
+	// pass slower than the checkpoint interval would never back up anything
+	// new.
+	last int
+}
+
+func (imp *checkpointImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	stop := imp.stop()
+
+	ictx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var iresults chan *connectors.Result
+	if results != nil {
+		iresults = make(chan *connectors.Result)
+		go func() {
+			defer close(iresults)
+			for result := range results {
+				select {
+				case iresults <- result:
+				case <-ictx.Done():
+				}
+			}
+		}()
+	}
+
+	irecords := make(chan *connectors.Record)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- imp.Importer.Import(ictx, irecords, iresults)
+	}()
+
+	last, passed := imp.last, 0
+	stopped := false
+	for record := range irecords {
+		if stopped {
+			record.Close()
+			continue
+		}
+		if passed <= last && !imp.interrupted() {
+			records <- record
+			passed++
+			continue
+		}
+
+		select {
+		case <-stop:
+			stopped = true
+		default:
+			select {
+			case records <- record:
+				passed++
+				continue
+			case <-stop:
+				stopped = true
+			}
+		}
+		cancel()
+		imp.cut()
+		record.Close()
+	}
+	imp.last = passed
+
+	err := <-errc
+	if stopped {
+		return nil
+	}
+	return err
+}
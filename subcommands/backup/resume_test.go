@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const resumeTestFiles = 10
+
+// resumeTest is called by the resumetest importer after each file it
+// emitted, resumeReads counts the files whose content was read.
+var (
+	resumeTest  func(n int)
+	resumeReads atomic.Int64
+)
+
+func init() {
+	importer.Register("resumetest", location.FLAG_STREAM, newResumeTestImporter)
+}
+
+type resumeTestImporter struct {
+	origin string
+	flags  location.Flags
+}
+
+// newResumeTestImporter returns a stream, unless restartable is set.
+func newResumeTestImporter(ctx context.Context, opts *connectors.Options, name string, config map[string]string) (importer.Importer, error) {
+	flags := location.FLAG_STREAM
+	if config["restartable"] == "true" {
+		flags = 0
+	}
+	return &resumeTestImporter{origin: opts.Hostname, flags: flags}, nil
+}
+
+func (p *resumeTestImporter) Type() string                    { return "resumetest" }
+func (p *resumeTestImporter) Root() string                    { return "/" }
+func (p *resumeTestImporter) Origin() string                  { return p.origin }
+func (p *resumeTestImporter) Flags() location.Flags           { return p.flags }
+func (p *resumeTestImporter) Ping(ctx context.Context) error  { return nil }
+func (p *resumeTestImporter) Close(ctx context.Context) error { return nil }
+
+func (p *resumeTestImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"/", "/data"} {
+		fi := objects.FileInfo{Lname: dir[strings.LastIndex(dir, "/")+1:], Lmode: fs.ModeDir | 0755, LmodTime: modTime, Lnlink: 1}
+		records <- connectors.NewRecord(dir, "", fi, nil, nil)
+	}
+
+	for i := range resumeTestFiles {
+		content := fmt.Sprintf("content of file %d", i)
+		fi := objects.FileInfo{
+			Lname:    fmt.Sprintf("file%d", i),
+			Lmode:    0644,
+			Lsize:    int64(len(content)),
+			LmodTime: modTime,
+			Lnlink:   1,
+		}
+		records <- connectors.NewRecord("/data/"+fi.Lname, "", fi, nil, func() (io.ReadCloser, error) {
+			resumeReads.Add(1)
+			return io.NopCloser(strings.NewReader(content)), nil
+		})
+		if resumeTest != nil {
+			resumeTest(i + 1)
+		}
+	}
+	return nil
+}
+
+func TestBackupInterruptWithoutResume(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+	t.Cleanup(ctx.Close)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"/tmp"}))
+	require.False(t, cmd.Interrupt())
+
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-resume", "/tmp"}))
+	require.True(t, cmd.Interrupt())
+	require.True(t, cmd.Interrupt())
+	require.True(t, cmd.interrupted())
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-resume", "-cache", "no", "/tmp"}),
+		"-resume requires the vfs cache")
+
+	cmd = &Backup{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-resume", "-checkpoint-every", "-1s", "/tmp"}),
+		"invalid checkpoint interval")
+}
+
+func TestStartPass(t *testing.T) {
+	cmd := &Backup{interrupt: make(chan struct{})}
+	stopped := func() bool {
+		select {
+		case <-cmd.stopping():
+			return true
+		default:
+			return false
+		}
+	}
+
+	end := cmd.startPass(0)
+	require.False(t, stopped())
+	end()
+	require.False(t, stopped())
+
+	cmd.cutShort()
+	end = cmd.startPass(time.Millisecond)
+	require.False(t, cmd.cut.Load())
+	require.Eventually(t, stopped, time.Second, time.Millisecond)
+	end()
+
+	end = cmd.startPass(time.Hour)
+	close(cmd.interrupt)
+	require.Eventually(t, stopped, time.Second, time.Millisecond)
+	end()
+}
+
+// importCheckpoint runs imp until it stops, and returns the files it passed.
+func importCheckpoint(t *testing.T, imp *checkpointImporter) int {
+	records := make(chan *connectors.Record)
+	done := make(chan error, 1)
+	go func() {
+		done <- imp.Import(context.Background(), records, nil)
+	}()
+
+	var files int
+	for record := range records {
+		if record.FileInfo.Mode().IsRegular() {
+			files++
+		}
+		record.Close()
+	}
+	require.NoError(t, <-done)
+	return files
+}
+
+func TestCheckpointImporter(t *testing.T) {
+	stop := make(chan struct{})
+	resumeTest = func(n int) {
+		if n == 3 {
+			close(stop)
+		}
+	}
+	t.Cleanup(func() { resumeTest = nil })
+
+	var cut bool
+	imp := &checkpointImporter{
+		Importer:    &resumeTestImporter{},
+		stop:        func() <-chan struct{} { return stop },
+		interrupted: func() bool { return true },
+		cut:         func() { cut = true },
+	}
+	files := importCheckpoint(t, imp)
+	require.True(t, cut)
+	require.GreaterOrEqual(t, files, 2)
+	require.LessOrEqual(t, files, 3)
+}
+
+func TestCheckpointImporterProgress(t *testing.T) {
+	stop := make(chan struct{})
+	close(stop)
+
+	var cuts int
+	imp := &checkpointImporter{
+		Importer:    &resumeTestImporter{},
+		stop:        func() <-chan struct{} { return stop },
+		interrupted: func() bool { return false },
+		cut:         func() { cuts++ },
+	}
+
+	// a pass stopped from the start still passes one more record than
+	// the previous one, until the import is complete
+	for range 100 {
+		previous, before := imp.last, cuts
+		importCheckpoint(t, imp)
+		require.Greater(t, imp.last, previous)
+		if cuts == before {
+			break
+		}
+	}
+	require.Equal(t, 2+resumeTestFiles, imp.last)
+	require.Equal(t, imp.last-1, cuts)
+}
+
+func TestBackupResume(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	// the first run is interrupted halfway
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-resume", "-no-progress", "resumetest:///"}))
+	resumeTest = func(n int) {
+		if n == resumeTestFiles/2 {
+			cmd.Interrupt()
+		}
+	}
+	t.Cleanup(func() { resumeTest = nil })
+
+	status, err, _, _ := cmd.DoBackup(ctx, repo)
+	require.ErrorIs(t, err, ErrInterrupted)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufOut.String(), "created checkpoint")
+	require.NoError(t, repo.RebuildState())
+	ptesting.WaitUnlocked(t, repo)
+
+	filters := locate.LocateFilters{Tags: []string{utils.CheckpointTag}}
+	ids, _, err := locate.Match(repo, &locate.LocateOptions{Filters: filters})
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	// the resumed run doesn't read again what the checkpoint holds
+	resumeTest = nil
+	resumeReads.Store(0)
+	cmd = &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-resume", "-no-progress", "resumetest:///"}))
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "resuming from checkpoint")
+	require.Less(t, resumeReads.Load(), int64(resumeTestFiles))
+	require.NoError(t, repo.RebuildState())
+
+	ids, _, err = locate.Match(repo, &locate.LocateOptions{Filters: filters})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	require.NotContains(t, snap.Header.Tags, utils.CheckpointTag)
+
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+	for i := range resumeTestFiles {
+		data, err := fs.ReadFile(pvfs, fmt.Sprintf("/data/file%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("content of file %d", i), string(data))
+	}
+}
+
+func TestBackupPeriodicCheckpoint(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, _, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+	ctx.Stdout = bufOut
+	ctx.Stderr = bufErr
+
+	// the first pass is slow enough for a checkpoint to be due halfway
+	var passes atomic.Int64
+	resumeTest = func(n int) {
+		if n == 1 {
+			passes.Add(1)
+		}
+		if n == resumeTestFiles/2 && passes.Load() == 1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	t.Cleanup(func() { resumeTest = nil })
+	resumeReads.Store(0)
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-resume", "-checkpoint-every", "250ms", "-no-progress",
+		"-o", "restartable=true", "resumetest:///"}))
+	status, err, snapshotID, _ := cmd.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "created checkpoint")
+	require.Contains(t, bufOut.String(), "resuming from checkpoint")
+	require.GreaterOrEqual(t, passes.Load(), int64(2))
+
+	// the files of the checkpoint weren't read again
+	require.Less(t, resumeReads.Load(), int64(resumeTestFiles+resumeTestFiles/2))
+
+	require.NoError(t, repo.RebuildState())
+	filters := locate.LocateFilters{Tags: []string{utils.CheckpointTag}}
+	ids, _, err := locate.Match(repo, &locate.LocateOptions{Filters: filters})
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	source, err := utils.SnapshotSource(repo, snap, 0)
+	require.NoError(t, err)
+	pvfs, err := source.Filesystem()
+	require.NoError(t, err)
+	for i := range resumeTestFiles {
+		data, err := fs.ReadFile(pvfs, fmt.Sprintf("/data/file%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("content of file %d", i), string(data))
+	}
+}
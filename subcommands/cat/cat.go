@@ -22,11 +22,15 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"path"
+	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
@@ -40,12 +44,14 @@ func (cmd *Cat) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("cat", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] -at TIME PATH...\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
 
 	flags.BoolVar(&cmd.Decompress, "decompress", false, "decompress output")
 	flags.BoolVar(&cmd.Highlight, "highlight", false, "highlight output")
+	flags.Var(locate.NewTimeFlag(&cmd.At), "at", "read paths from the newest snapshot taken at or before the given time")
 	flags.Parse(args)
 
 	if flags.NArg() == 0 {
@@ -63,13 +69,43 @@ type Cat struct {
 
 	Decompress bool
 	Highlight  bool
+	At         time.Time
 	Paths      []string
 }
 
+// openSnapshotPath opens the snapshot designated by snapPath or, with
+// -at, the newest snapshot holding it at that time.
+func (cmd *Cat) openSnapshotPath(ctx *appcontext.AppContext, repo *repository.Repository, snapPath string) (*snapshot.Snapshot, string, error) {
+	if cmd.At.IsZero() {
+		return locate.OpenSnapshotByPath(repo, snapPath)
+	}
+
+	pathname := snapPath
+	if !path.IsAbs(pathname) {
+		pathname = path.Join(ctx.CWD, pathname)
+	}
+
+	snap, idx, err := utils.LocateAt(repo, nil, cmd.At, pathname, "")
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Fprintf(ctx.Stderr, "cat: %s: using snapshot %x from %s\n", pathname,
+		snap.Header.Identifier[:4], snap.Header.Timestamp.UTC().Format(time.RFC3339))
+
+	view, err := utils.SnapshotSource(repo, snap, idx)
+	if view != snap {
+		snap.Close()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return view, pathname, nil
+}
+
 func (cmd *Cat) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	errors := 0
 	for _, snapPath := range cmd.Paths {
-		snap, pathname, err := locate.OpenSnapshotByPath(repo, snapPath)
+		snap, pathname, err := cmd.openSnapshotPath(ctx, repo, snapPath)
 		if err != nil {
 			ctx.GetLogger().Error("cat: %s: %s", snapPath, err)
 			errors++
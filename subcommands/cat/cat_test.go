@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/stretchr/testify/require"
@@ -214,3 +215,34 @@ func TestExecuteCmdCatHighlight(t *testing.T) {
 	output := bufOut.String()
 	require.Equal(t, "\x1b[1m\x1b[37mhello dummy\x1b[0m", output)
 }
+
+func TestExecuteCmdCatAt(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	for day, content := range []string{"first", "second", "third"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("subdir"),
+			ptesting.NewMockFile("subdir/file.txt", 0644, content),
+		}, ptesting.WithTimestamp(time.Date(2026, 10, 1+day, 12, 0, 0, 0, time.UTC)))
+		snap.Close()
+	}
+
+	subcommand := &Cat{}
+	require.NoError(t, subcommand.Parse(ctx, []string{"-at", "2026-10-02 14:00", "/subdir/file.txt"}))
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, "second", bufOut.String())
+	require.Contains(t, bufErr.String(), "from 2026-10-02T12:00:00Z")
+
+	bufOut.Reset()
+	subcommand = &Cat{}
+	require.NoError(t, subcommand.Parse(ctx, []string{"-at", "2026-09-30", "/subdir/file.txt"}))
+	status, err = subcommand.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufErr.String(), "no snapshot of /subdir/file.txt found at or before 2026-09-30T00:00:00Z")
+}
@@ -20,13 +20,16 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 
 	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/exitcodes"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/google/uuid"
 )
 
@@ -36,17 +39,27 @@ type Check struct {
 	LocateOptions *locate.LocateOptions
 	FastCheck     bool
 	NoVerify      bool
+	JSON          bool
 	Snapshots     []string
 }
 
+// checkResult is the JSON output of check for each checked snapshot.
+type checkResult struct {
+	Snapshot  objects.MAC `json:"snapshot"`
+	Path      string      `json:"path"`
+	Signature string      `json:"signature"`
+	Ok        bool        `json:"ok"`
+	Error     string      `json:"error,omitempty"`
+}
+
 func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &Check{} }, 0, "check")
 }
 
-func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
+func (cmd *Check) newFlagSet() *flag.FlagSet {
 	cmd.LocateOptions = locate.NewDefaultLocateOptions()
 
-	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
@@ -55,9 +68,24 @@ func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.BoolVar(&cmd.NoVerify, "no-verify", false, "disable signature verification")
 	flags.BoolVar(&cmd.FastCheck, "fast", false, "enable fast checking (no digest verification)")
+	flags.BoolVar(&cmd.JSON, "json", false, "output one JSON record per line")
 	cmd.LocateOptions.InstallLocateFlags(flags)
 
-	flags.Parse(args)
+	return flags
+}
+
+// CheckArgs validates the arguments of a check without running it.
+func (cmd *Check) CheckArgs(args []string) error {
+	flags := cmd.newFlagSet()
+	flags.SetOutput(io.Discard)
+	return flags.Parse(args)
+}
+
+func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := cmd.newFlagSet()
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
 
 	if flags.NArg() != 0 && !cmd.LocateOptions.Empty() {
 		ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
@@ -114,6 +142,13 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	emitter := repo.Emitter("check")
 	defer emitter.Close()
 
+	var out *utils.JSONWriter
+	if cmd.JSON {
+		out = utils.NewJSONWriter(ctx.Stdout)
+		// the progress events would be interleaved with the records
+		ctx.Silent = true
+	}
+
 	var failures int
 	for _, arg := range snapshots {
 		snap, pathname, err := locate.OpenSnapshotByPath(repo, arg)
@@ -123,19 +158,35 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 
 		snap.SetCheckCache(checkCache)
 
+		result := checkResult{
+			Snapshot:  snap.Header.Identifier,
+			Path:      pathname,
+			Signature: "unsigned",
+		}
+
 		var failed bool
-		if !cmd.NoVerify && snap.Header.Identity.Identifier != uuid.Nil {
+		if cmd.NoVerify {
+			result.Signature = "skipped"
+		} else if snap.Header.Identity.Identifier != uuid.Nil {
 			if ok, err := snap.Verify(); err != nil {
 				ctx.GetLogger().Warn("%s", err)
+				result.Signature = "unknown"
 			} else if !ok {
-				ctx.GetLogger().Info("snapshot %x signature verification failed", snap.Header.Identifier)
+				if out == nil {
+					ctx.GetLogger().Info("snapshot %x signature verification failed", snap.Header.Identifier)
+				}
+				result.Signature = "failed"
 				failed = true
 			} else {
-				ctx.GetLogger().Info("snapshot %x signature verification succeeded", snap.Header.Identifier)
+				if out == nil {
+					ctx.GetLogger().Info("snapshot %x signature verification succeeded", snap.Header.Identifier)
+				}
+				result.Signature = "verified"
 			}
 		}
 
 		if err := snap.Check(pathname, opts); err != nil {
+			result.Error = err.Error()
 			failed = true
 		}
 
@@ -144,6 +195,13 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		}
 
 		snap.Close()
+
+		if out != nil {
+			result.Ok = !failed
+			if err := out.Write("check", result); err != nil {
+				return 1, err
+			}
+		}
 	}
 
 	if failures != 0 {
@@ -3,6 +3,7 @@ package check
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
 	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -170,3 +172,41 @@ func TestExecuteCmdCheckSpecificSnapshot(t *testing.T) {
 	lastline := lines[len(lines)-1]
 	require.Contains(t, lastline, "check completed without errors")
 }
+
+func TestExecuteCmdCheckJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	defer renderer.Wait()
+	defer ctx.Close()
+
+	indexId := snap.Header.GetIndexID()
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", hex.EncodeToString(indexId[:]) + ":/subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the progress events are not mixed with the records
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 1)
+
+	var record struct {
+		Version int         `json:"version"`
+		Type    string      `json:"type"`
+		Item    checkResult `json:"item"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, utils.JSONVersion, record.Version)
+	require.Equal(t, "check", record.Type)
+	require.Equal(t, checkResult{
+		Snapshot:  snap.Header.Identifier,
+		Path:      "/subdir",
+		Signature: "unsigned",
+		Ok:        true,
+	}, record.Item)
+}
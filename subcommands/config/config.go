@@ -43,6 +43,10 @@ func init() {
 		subcommands.BeforeRepositoryOpen, "source")
 	subcommands.Register(func() subcommands.Subcommand { return &ConfigDestinationCmd{} },
 		subcommands.BeforeRepositoryOpen, "destination")
+	subcommands.Register(func() subcommands.Subcommand { return &ConfigPolicySimulateCmd{} },
+		subcommands.BeforeRepositoryOpen, "policy", "simulate")
+	subcommands.Register(func() subcommands.Subcommand { return &ConfigPolicyTestCmd{} },
+		0, "policy", "test")
 	subcommands.Register(func() subcommands.Subcommand { return &ConfigPolicyCmd{} },
 		subcommands.BeforeRepositoryOpen, "policy")
 }
@@ -3,11 +3,16 @@ package config
 import (
 	"flag"
 	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
+	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/retention"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -26,6 +31,8 @@ func (cmd *ConfigPolicyCmd) Parse(ctx *appcontext.AppContext, args []string) err
 		fmt.Fprintf(flags.Output(), "       %s rm <name>\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s set <name> [<option>=<value>...]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s show [<name>...]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s simulate [-every <duration>] [-for <duration>] [<name>]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s test [<name>]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s unset <name> <option>...\n", flags.Name())
 		flags.PrintDefaults()
 	}
@@ -178,6 +185,210 @@ func dispatchPolicy(ctx *appcontext.AppContext, cmd, subcmd string, args []strin
 		return config.SaveToFile(configFile)
 
 	default:
-		return fmt.Errorf("usage: plakar %s [add|rm|set|show|unset]", cmd)
+		return fmt.Errorf("usage: plakar %s [add|rm|set|show|simulate|test|unset]", cmd)
 	}
 }
+
+// ConfigPolicyTestCmd shows what a policy decides for the snapshots of the
+// store, the retention policy of the store by default.
+type ConfigPolicyTestCmd struct {
+	subcommands.SubcommandBase
+
+	name string
+}
+
+func (cmd *ConfigPolicyTestCmd) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("policy test", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [<name>]\n", flags.Name())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	switch flags.NArg() {
+	case 0:
+		if ctx.Retention == "" {
+			return fmt.Errorf("no policy specified and the store has no retention policy")
+		}
+		cmd.name = ctx.Retention
+	case 1:
+		cmd.name = normalizeName(flags.Arg(0))
+	default:
+		return fmt.Errorf("too many arguments")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	return nil
+}
+
+func (cmd *ConfigPolicyTestCmd) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	opts, err := retention.Load(ctx.ConfigDir, cmd.name)
+	if err != nil {
+		return 1, err
+	}
+
+	decisions, err := retention.Evaluate(repo, opts)
+	if err != nil {
+		return 1, err
+	}
+
+	deleted := printCalendar(ctx.Stdout, decisions)
+	fmt.Fprintf(ctx.Stdout, "policy %s: would keep %d and delete %d snapshot(s)\n",
+		cmd.name, len(decisions)-deleted, deleted)
+	return 0, nil
+}
+
+// printCalendar lays out the decisions by month and day, newest first, and
+// returns how many snapshots are deleted.
+func printCalendar(w io.Writer, decisions []retention.Decision) int {
+	deleted := 0
+	month, day := "", ""
+	for _, d := range decisions {
+		ts := d.Timestamp.UTC()
+		if m := ts.Format("January 2006"); m != month {
+			if month != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, m)
+			month, day = m, ""
+		}
+
+		label := ""
+		if dd := ts.Format("Mon 02"); dd != day {
+			label, day = dd, dd
+		}
+
+		action := "keep"
+		if d.Delete() {
+			action = "delete"
+			deleted++
+		}
+		fmt.Fprintf(w, "  %-6s  %-6s  %x  %s  %-24s  %s\n", label, action, d.ID[:4],
+			ts.Format("15:04:05"), d.Explain(), utils.SanitizeText(d.Name))
+	}
+	return deleted
+}
+
+// ConfigPolicySimulateCmd applies the retention periods of a policy to a
+// synthetic sequence of backups, to validate a scheme before deploying it.
+type ConfigPolicySimulateCmd struct {
+	subcommands.SubcommandBase
+
+	name     string
+	timeline retention.Timeline
+}
+
+func (cmd *ConfigPolicySimulateCmd) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.timeline = retention.Timeline{
+		End:    time.Now(),
+		Every:  24 * time.Hour,
+		Span:   365 * 24 * time.Hour,
+		Report: 30 * 24 * time.Hour,
+	}
+
+	flags := flag.NewFlagSet("policy simulate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [<name>]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	durationFlag(flags, "every", "interval between two backups (default 1d)", &cmd.timeline.Every)
+	durationFlag(flags, "for", "duration of the simulation (default 1y)", &cmd.timeline.Span)
+	durationFlag(flags, "prune-every", "interval between two prunes (default after each backup)", &cmd.timeline.Prune)
+	durationFlag(flags, "report", "interval between two reported points (default 30d)", &cmd.timeline.Report)
+	flags.Parse(args)
+
+	switch flags.NArg() {
+	case 0:
+		if ctx.Retention == "" {
+			return fmt.Errorf("no policy specified and the store has no retention policy")
+		}
+		cmd.name = ctx.Retention
+	case 1:
+		cmd.name = normalizeName(flags.Arg(0))
+	default:
+		return fmt.Errorf("too many arguments")
+	}
+
+	if cmd.timeline.Every <= 0 || cmd.timeline.Span <= 0 || cmd.timeline.Report <= 0 || cmd.timeline.Prune < 0 {
+		return fmt.Errorf("durations must be positive")
+	}
+	return nil
+}
+
+func durationFlag(flags *flag.FlagSet, name, usage string, d *time.Duration) {
+	flags.Func(name, usage, func(value string) error {
+		v, err := human2duration.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = v
+		return nil
+	})
+}
+
+func (cmd *ConfigPolicySimulateCmd) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	opts, err := retention.Load(ctx.ConfigDir, cmd.name)
+	if err != nil {
+		return 1, err
+	}
+
+	sim, err := retention.Simulate(opts, &cmd.timeline)
+	if err != nil {
+		return 1, fmt.Errorf("policy %s: %w", cmd.name, err)
+	}
+
+	filters := locate.NewDefaultLocateOptions()
+	filters.Filters = opts.Filters
+	filters.GroupBy = opts.GroupBy
+	if !filters.Empty() {
+		ctx.GetLogger().Warn("policy %s: the filters and grouping are ignored by the simulation", cmd.name)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "%-10s  %6s  %-10s  %s\n", "DATE", "KEPT", "OLDEST", "LARGEST GAP")
+	for _, s := range sim.Samples {
+		printSample(ctx.Stdout, s)
+	}
+	if n := len(sim.Samples); n == 0 || sim.Samples[n-1].At != sim.Final.At {
+		printSample(ctx.Stdout, sim.Final)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "\n%-8s  %6s  %-10s  %-10s  %s\n", "RULE", "KEPT", "NEWEST", "OLDEST", "LARGEST GAP")
+	for _, t := range sim.Tiers {
+		fmt.Fprintf(ctx.Stdout, "%-8s  %6d  %-10s  %-10s  %s\n", t.Rule, t.Kept,
+			t.Newest.Format(time.DateOnly), t.Oldest.Format(time.DateOnly), fmtDuration(t.LargestGap))
+	}
+
+	fmt.Fprintf(ctx.Stdout, "\npolicy %s: %d backups over %s, %d kept at the end",
+		cmd.name, sim.Backups, fmtDuration(cmd.timeline.Span), sim.Final.Kept)
+	if sim.Final.Kept != 0 {
+		fmt.Fprintf(ctx.Stdout, ", the oldest from %s, %s before the last backup",
+			sim.Final.Oldest.Format(time.DateOnly), fmtDuration(sim.Final.At.Sub(sim.Final.Oldest)))
+	}
+	fmt.Fprintln(ctx.Stdout)
+	return 0, nil
+}
+
+func printSample(w io.Writer, s retention.Sample) {
+	oldest := "-"
+	if s.Kept != 0 {
+		oldest = s.Oldest.Format(time.DateOnly)
+	}
+	fmt.Fprintf(w, "%-10s  %6d  %-10s  %s\n", s.At.Format(time.DateOnly), s.Kept, oldest, fmtDuration(s.LargestGap))
+}
+
+// fmtDuration prints d in days and hours, or as is below an hour.
+func fmtDuration(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Hour:
+		return d.String()
+	case d < day:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%day < time.Hour:
+		return fmt.Sprintf("%dd", d/day)
+	}
+	return fmt.Sprintf("%dd%dh", d/day, d%day/time.Hour)
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/subcommands"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyTestRegistered(t *testing.T) {
+	cmd, _, args := subcommands.Lookup([]string{"policy", "test", "weekly"})
+	require.IsType(t, &ConfigPolicyTestCmd{}, cmd)
+	require.Equal(t, []string{"weekly"}, args)
+
+	cmd, _, _ = subcommands.Lookup([]string{"policy", "show"})
+	require.IsType(t, &ConfigPolicyCmd{}, cmd)
+}
+
+func TestPolicyTestParse(t *testing.T) {
+	ctx, _, _ := cov80Ctx(t)
+
+	err := (&ConfigPolicyTestCmd{}).Parse(ctx, nil)
+	require.ErrorContains(t, err, "no policy specified")
+
+	err = (&ConfigPolicyTestCmd{}).Parse(ctx, []string{"a", "b"})
+	require.ErrorContains(t, err, "too many arguments")
+
+	// the retention policy of the store by default
+	ctx.Retention = "weekly"
+	cmd := &ConfigPolicyTestCmd{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.Equal(t, "weekly", cmd.name)
+
+	cmd = &ConfigPolicyTestCmd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"@daily"}))
+	require.Equal(t, "daily", cmd.name)
+}
+
+func TestPolicyTest(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	ctx.ConfigDir = t.TempDir()
+	require.NoError(t, dispatchPolicy(ctx, "policy", "add", []string{"recent", "days=2"}))
+
+	files := []ptesting.MockFile{ptesting.NewMockFile("file.txt", 0644, "content")}
+	now := time.Now().UTC()
+	kept := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithTimestamp(now))
+	defer kept.Close()
+	old := ptesting.GenerateSnapshot(t, repo, files, ptesting.WithTimestamp(now.AddDate(0, -2, 0)))
+	defer old.Close()
+	require.NoError(t, repo.RebuildState())
+
+	cmd := &ConfigPolicyTestCmd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"recent"}))
+	bufOut.Reset()
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// nothing is removed
+	count := 0
+	for _, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		count++
+	}
+	require.Equal(t, 2, count)
+
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Equal(t, []string{
+		now.Format("January 2006"),
+		"  " + now.Format("Mon 02") + "  keep    " + hex.EncodeToString(kept.Header.Identifier[:4]) + "  " +
+			now.Format("15:04:05") + "  day:" + now.Format("2006-01-02") + " #1" + strings.Repeat(" ", 24-len("day:2006-01-02 #1")) + "  test_backup",
+		"",
+		now.AddDate(0, -2, 0).Format("January 2006"),
+		"  " + now.AddDate(0, -2, 0).Format("Mon 02") + "  delete  " + hex.EncodeToString(old.Header.Identifier[:4]) + "  " +
+			now.Format("15:04:05") + "  outside retention windows  test_backup",
+		"policy recent: would keep 1 and delete 1 snapshot(s)",
+	}, lines)
+}
+
+func TestPolicySimulateRegistered(t *testing.T) {
+	cmd, _, args := subcommands.Lookup([]string{"policy", "simulate", "-every", "1h", "gfs"})
+	require.IsType(t, &ConfigPolicySimulateCmd{}, cmd)
+	require.Equal(t, []string{"-every", "1h", "gfs"}, args)
+}
+
+func TestPolicySimulateParse(t *testing.T) {
+	ctx, _, _ := cov80Ctx(t)
+
+	err := (&ConfigPolicySimulateCmd{}).Parse(ctx, nil)
+	require.ErrorContains(t, err, "no policy specified")
+
+	err = (&ConfigPolicySimulateCmd{}).Parse(ctx, []string{"-every", "0h", "gfs"})
+	require.ErrorContains(t, err, "durations must be positive")
+
+	cmd := &ConfigPolicySimulateCmd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-every", "1h", "-for", "2y", "-prune-every", "1d", "@gfs"}))
+	require.Equal(t, "gfs", cmd.name)
+	require.Equal(t, time.Hour, cmd.timeline.Every)
+	require.Equal(t, 2*365*24*time.Hour, cmd.timeline.Span)
+	require.Equal(t, 24*time.Hour, cmd.timeline.Prune)
+	require.Equal(t, 30*24*time.Hour, cmd.timeline.Report)
+
+	err = dispatchPolicy(ctx, "policy", "unknown", nil)
+	require.EqualError(t, err, "usage: plakar policy [add|rm|set|show|simulate|test|unset]")
+}
+
+func TestPolicySimulate(t *testing.T) {
+	ctx, bufOut, _ := cov80Ctx(t)
+	require.NoError(t, dispatchPolicy(ctx, "policy", "add", []string{"weekly", "weeks=4", "per-week=1"}))
+	require.NoError(t, dispatchPolicy(ctx, "policy", "add", []string{"named", "name=test"}))
+
+	cmd := &ConfigPolicySimulateCmd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-for", "60d", "named"}))
+	_, err := cmd.Execute(ctx, nil)
+	require.ErrorContains(t, err, "no retention period")
+
+	cmd = &ConfigPolicySimulateCmd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-for", "60d", "weekly"}))
+	cmd.timeline.End = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	bufOut.Reset()
+	status, err := cmd.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	out := bufOut.String()
+	require.Contains(t, out, "2026-10-17       4  2026-09-27  7d\n")
+	require.Contains(t, out, "week           4  2026-10-17  2026-09-27  7d\n")
+	require.Contains(t, out, "policy weekly: 61 backups over 60d, 4 kept at the end, the oldest from 2026-09-27, 20d before the last backup\n")
+}
+
+func TestFmtDuration(t *testing.T) {
+	require.Equal(t, "-", fmtDuration(0))
+	require.Equal(t, "30m0s", fmtDuration(30*time.Minute))
+	require.Equal(t, "5h", fmtDuration(5*time.Hour))
+	require.Equal(t, "7d", fmtDuration(7*24*time.Hour))
+	require.Equal(t, "320d6h", fmtDuration(320*24*time.Hour+6*time.Hour))
+}
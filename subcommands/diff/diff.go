@@ -27,7 +27,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
@@ -51,6 +50,8 @@ func (cmd *Diff) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 	flags.BoolVar(&cmd.Highlight, "highlight", false, "highlight output")
 	flags.BoolVar(&cmd.Recursive, "recursive", false, "recursive diff of directories")
+	flags.StringVar(&cmd.Source, "source", "", "diff the given source of multi-source snapshots")
+	flags.BoolVar(&cmd.JSON, "json", false, "output one JSON record per difference")
 	flags.Parse(args)
 
 	if flags.NArg() == 1 {
@@ -74,6 +75,21 @@ type Diff struct {
 	Recursive bool
 	Path1     string
 	Path2     string
+	Source    string
+	JSON      bool
+
+	json *utils.JSONWriter
+}
+
+// change is the JSON output of diff for each difference found.
+type change struct {
+	// one of added, removed, modified or type_mismatch
+	Status string `json:"status"`
+	Path1  string `json:"path1,omitempty"`
+	Path2  string `json:"path2,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
+	// unified diff of modified text files
+	Diff string `json:"diff,omitempty"`
 }
 
 func (cmd *Diff) Name() string {
@@ -81,7 +97,7 @@ func (cmd *Diff) Name() string {
 }
 
 func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	snap1, pathname1, err := locate.OpenSnapshotByPath(repo, cmd.Path1)
+	snap1, pathname1, _, err := utils.OpenSnapshotSource(repo, cmd.Path1, cmd.Source)
 	if err != nil {
 		return 1, fmt.Errorf("diff: could not open snapshot: %s", cmd.Path1)
 	}
@@ -101,7 +117,7 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		id2 = "local"
 	} else {
 		var snap2 *snapshot.Snapshot
-		snap2, pathname2, err = locate.OpenSnapshotByPath(repo, cmd.Path2)
+		snap2, pathname2, _, err = utils.OpenSnapshotSource(repo, cmd.Path2, cmd.Source)
 		if err != nil {
 			return 1, fmt.Errorf("diff: could not open snapshot: %s", cmd.Path2)
 		}
@@ -126,7 +142,9 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		out     = ctx.Stdout
 		builder = strings.Builder{}
 	)
-	if cmd.Highlight {
+	if cmd.JSON {
+		cmd.json = utils.NewJSONWriter(ctx.Stdout)
+	} else if cmd.Highlight {
 		out = &builder
 	}
 
@@ -135,7 +153,7 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
 	}
 
-	if cmd.Highlight {
+	if cmd.Highlight && !cmd.JSON {
 		err = quick.Highlight(ctx.Stdout, builder.String(), "diff", "terminal", "dracula")
 		if err != nil {
 			return 1, fmt.Errorf("diff: could not highlight diff: %w", err)
@@ -219,17 +237,31 @@ func (cmd *Diff) diff_directories_flat(out io.Writer, pathname1 string, fsobj1 f
 		visited[name] = true
 		if e2, ok := map2[name]; ok {
 			if e1.IsDir() && e2.IsDir() {
-				fmt.Fprintf(out, "Common subdirectories: %s and %s\n", name, name)
+				if cmd.json == nil {
+					fmt.Fprintf(out, "Common subdirectories: %s and %s\n", name, name)
+				}
 			} else if e1.IsDir() != e2.IsDir() {
-				fmt.Fprintf(out, "File type mismatch: %s (dir=%v) vs %s (dir=%v)\n", name, e1.IsDir(), name, e2.IsDir())
+				err := cmd.report(out, change{Status: "type_mismatch", Path1: path.Join(pathname1, name), Path2: path.Join(pathname2, name)},
+					"File type mismatch: %s (dir=%v) vs %s (dir=%v)\n", name, e1.IsDir(), name, e2.IsDir())
+				if err != nil {
+					return err
+				}
 			}
 		} else {
-			fmt.Fprintf(out, "Only in %s: %s\n", pathname1, name)
+			err := cmd.report(out, change{Status: "removed", Path1: path.Join(pathname1, name)},
+				"Only in %s: %s\n", pathname1, name)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	for name := range map2 {
 		if !visited[name] {
-			fmt.Fprintf(out, "Only in %s: %s\n", pathname2, name)
+			err := cmd.report(out, change{Status: "added", Path2: path.Join(pathname2, name)},
+				"Only in %s: %s\n", pathname2, name)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -282,14 +314,24 @@ func (cmd *Diff) diff_directories_recursive(out io.Writer, id1 string, fs1 fs.FS
 
 		switch {
 		case ok1 && !ok2:
-			fmt.Fprintf(out, "Only in %s: %s\n", path1, name)
+			err := cmd.report(out, change{Status: "removed", Path1: full1},
+				"Only in %s: %s\n", path1, name)
+			if err != nil {
+				return err
+			}
 
 		case !ok1 && ok2:
-			fmt.Fprintf(out, "Only in %s: %s\n", path2, name)
+			err := cmd.report(out, change{Status: "added", Path2: path.Join(path2, name)},
+				"Only in %s: %s\n", path2, name)
+			if err != nil {
+				return err
+			}
 
 		case ok1 && ok2:
 			if e1.IsDir() && e2.IsDir() {
-				fmt.Fprintf(out, "Common subdirectories: %s and %s\n", full1, full2)
+				if cmd.json == nil {
+					fmt.Fprintf(out, "Common subdirectories: %s and %s\n", full1, full2)
+				}
 				err := cmd.diff_directories_recursive(out, id1, fs1, full1, id2, fs2, full2)
 				if err != nil {
 					return err
@@ -314,7 +356,11 @@ func (cmd *Diff) diff_directories_recursive(out io.Writer, id1 string, fs1 fs.FS
 					return err
 				}
 			} else {
-				fmt.Fprintf(out, "File type mismatch: %s vs %s\n", full1, full2)
+				err := cmd.report(out, change{Status: "type_mismatch", Path1: full1, Path2: path.Join(path2, name)},
+					"File type mismatch: %s vs %s\n", full1, full2)
+				if err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -387,8 +433,8 @@ func (cmd *Diff) diff_readers(out io.Writer, id1 string, pathname1 string, rd1 i
 		}
 
 		if !same {
-			fmt.Fprintf(out, "Binary files %s and %s differ\n",
-				pathname1, pathname2)
+			return cmd.report(out, change{Status: "modified", Path1: pathname1, Path2: "/" + strings.TrimPrefix(pathname2, "/"), Binary: true},
+				"Binary files %s and %s differ\n", pathname1, pathname2)
 		}
 		return nil
 	}
@@ -418,5 +464,31 @@ func (cmd *Diff) diff_readers(out io.Writer, id1 string, pathname1 string, rd1 i
 		ToFile:   ToFile,
 		Context:  3,
 	}
-	return difflib.WriteUnifiedDiff(out, diff)
+	if cmd.json == nil {
+		return difflib.WriteUnifiedDiff(out, diff)
+	}
+
+	var builder strings.Builder
+	if err := difflib.WriteUnifiedDiff(&builder, diff); err != nil {
+		return err
+	}
+	if builder.Len() == 0 {
+		return nil
+	}
+	return cmd.json.Write("change", change{
+		Status: "modified",
+		Path1:  pathname1,
+		Path2:  pathname2,
+		Diff:   builder.String(),
+	})
+}
+
+// report outputs a difference, as a JSON record with -json or as the
+// given line of text otherwise.
+func (cmd *Diff) report(out io.Writer, c change, format string, args ...any) error {
+	if cmd.json != nil {
+		return cmd.json.Write("change", c)
+	}
+	_, err := fmt.Fprintf(out, format, args...)
+	return err
 }
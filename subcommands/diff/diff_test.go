@@ -3,12 +3,15 @@ package diff
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -306,3 +309,88 @@ func TestExecuteCmdDiffFiles(t *testing.T) {
 -hello dummy
 +hello dummy!!`)
 }
+
+func TestDiffSource(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "same\n"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "one\n"),
+	}))
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "same\n"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "two\n"),
+	}))
+	defer snap2.Close()
+
+	id1 := snap1.Header.GetIndexShortID()
+	id2 := snap2.Header.GetIndexShortID()
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-source", "other",
+		fmt.Sprintf("%s:/b.txt", hex.EncodeToString(id1[:])),
+		fmt.Sprintf("%s:/b.txt", hex.EncodeToString(id2[:])),
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	out := bufOut.String()
+	require.Contains(t, out, "-one")
+	require.Contains(t, out, "+two")
+}
+
+func TestDiffJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "one\n"),
+		ptesting.NewMockFile("subdir/b.txt", 0644, "same\n"),
+		ptesting.NewMockFile("subdir/removed.txt", 0644, "removed\n"),
+		ptesting.NewMockFile("subdir/blob", 0644, string([]byte{0x00, 'a'})),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "two\n"),
+		ptesting.NewMockFile("subdir/b.txt", 0644, "same\n"),
+		ptesting.NewMockFile("subdir/added.txt", 0644, "added\n"),
+		ptesting.NewMockFile("subdir/blob", 0644, string([]byte{0x00, 'b'})),
+	})
+	defer snap2.Close()
+
+	id1 := hex.EncodeToString(snap1.Header.GetIndexShortID())
+	id2 := hex.EncodeToString(snap2.Header.GetIndexShortID())
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "-recursive", id1 + ":/subdir", id2 + ":/subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	changes := []change{}
+	for _, line := range strings.Split(strings.TrimSpace(bufOut.String()), "\n") {
+		var record struct {
+			Version int    `json:"version"`
+			Type    string `json:"type"`
+			Item    change `json:"item"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		require.Equal(t, utils.JSONVersion, record.Version)
+		require.Equal(t, "change", record.Type)
+		changes = append(changes, record.Item)
+	}
+
+	require.Len(t, changes, 4)
+	require.Equal(t, "modified", changes[0].Status)
+	require.Equal(t, "/subdir/a.txt", changes[0].Path1)
+	require.Contains(t, changes[0].Diff, "-one\n+two\n")
+	require.Equal(t, change{Status: "added", Path2: "/subdir/added.txt"}, changes[1])
+	require.Equal(t, change{Status: "modified", Path1: "/subdir/blob", Path2: "/subdir/blob", Binary: true}, changes[2])
+	require.Equal(t, change{Status: "removed", Path1: "/subdir/removed.txt"}, changes[3])
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package hold
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/go-human2duration"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// Hold places or releases legal holds on snapshots.  The holds are
+// records of their own, the snapshots are left untouched.
+type Hold struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *locate.LocateOptions
+
+	Reason  string
+	Until   time.Time
+	Release bool
+}
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Hold{} }, 0, "hold")
+}
+
+// parseUntil parses the end of a hold, either a date or a duration from
+// now.
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	d, err := human2duration.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date or duration %q", value)
+	}
+	return now.Add(d), nil
+}
+
+func (cmd *Hold) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.LocateOptions = locate.NewDefaultLocateOptions()
+
+	var until string
+	flags := flag.NewFlagSet("hold", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s -reason REASON [-until TIME] [OPTIONS] SNAPSHOT...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s -release [OPTIONS] SNAPSHOT...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cmd.Reason, "reason", "", "reason of the hold")
+	flags.StringVar(&until, "until", "", "end of the hold, a date or a duration from now (e.g. 2027-01-01 or 1y)")
+	flags.BoolVar(&cmd.Release, "release", false, "release the hold of the snapshots")
+	cmd.LocateOptions.InstallDeletionFlags(flags)
+	flags.Parse(args)
+
+	if cmd.Release {
+		if cmd.Reason != "" || until != "" {
+			return fmt.Errorf("-release can't be used with -reason or -until")
+		}
+	} else if cmd.Reason == "" {
+		return fmt.Errorf("a reason is required to place a hold")
+	}
+
+	if until != "" {
+		now := time.Now()
+		t, err := parseUntil(until, now)
+		if err != nil {
+			return err
+		}
+		if !t.After(now) {
+			return fmt.Errorf("the end of the hold is in the past")
+		}
+		cmd.Until = t.UTC().Truncate(time.Second)
+	}
+
+	if flags.NArg() == 0 && cmd.LocateOptions.Empty() {
+		return fmt.Errorf("no filter specified, not going to hold everything")
+	}
+
+	cmd.LocateOptions.Filters.IDs = flags.Args()
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *Hold) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	matches, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+	if err != nil {
+		return 1, err
+	}
+
+	if len(matches) == 0 {
+		ctx.GetLogger().Info("hold: no snapshots matched the selection")
+		return 0, nil
+	}
+
+	var hold *utils.Hold
+	if !cmd.Release {
+		hold = &utils.Hold{Reason: cmd.Reason, Until: cmd.Until}
+	}
+
+	now := time.Now()
+	errors := 0
+	for _, snapshotID := range matches {
+		current, err := utils.LoadHold(repo, snapshotID, now)
+		if err != nil {
+			ctx.GetLogger().Error("hold: %x: %s", snapshotID[:4], err)
+			errors++
+			continue
+		}
+
+		if current == nil && hold == nil ||
+			current != nil && hold != nil && current.Reason == hold.Reason && current.Until.Equal(hold.Until) {
+			ctx.GetLogger().Info("hold: %x: unchanged", snapshotID[:4])
+			continue
+		}
+
+		if err := utils.PutHold(repo, snapshotID, hold); err != nil {
+			ctx.GetLogger().Error("hold: %x: %s", snapshotID[:4], err)
+			errors++
+			continue
+		}
+
+		if hold == nil {
+			fmt.Fprintf(ctx.Stdout, "%x: released\n", snapshotID)
+		} else {
+			fmt.Fprintf(ctx.Stdout, "%x: held: %s\n", snapshotID, hold)
+		}
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("failed to update the hold of %d snapshots", errors)
+	}
+	return 0, nil
+}
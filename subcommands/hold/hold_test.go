@@ -0,0 +1,181 @@
+package hold
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/encryption/keypair"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/subcommands"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoldRegisteredFactory(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"hold"})
+	require.NotNil(t, cmd)
+	require.IsType(t, &Hold{}, cmd)
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	until, err := parseUntil("2027-01-01T00:00:00Z", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), until.UTC())
+
+	until, err = parseUntil("2027-01-01", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local), until)
+
+	until, err = parseUntil("1w", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(7*24*time.Hour), until)
+
+	_, err = parseUntil("tomorrow", now)
+	require.ErrorContains(t, err, `invalid date or duration "tomorrow"`)
+}
+
+func TestHoldParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Hold{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"abcd"}), "a reason is required")
+
+	cmd = &Hold{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-release", "-reason", "x", "abcd"}),
+		"-release can't be used with -reason or -until")
+
+	cmd = &Hold{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-reason", "x"}), "not going to hold everything")
+
+	cmd = &Hold{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-reason", "x", "-until", "2001-01-01", "abcd"}),
+		"in the past")
+
+	cmd = &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-reason", "case 42", "-until", "1d", "abcd", "ef01"}))
+	require.Equal(t, "case 42", cmd.Reason)
+	require.WithinDuration(t, time.Now().Add(24*time.Hour), cmd.Until, time.Minute)
+	require.Equal(t, []string{"abcd", "ef01"}, cmd.LocateOptions.Filters.IDs)
+
+	cmd = &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-release", "-tag", "legal"}))
+	require.True(t, cmd.Release)
+	require.Equal(t, []string{"legal"}, cmd.LocateOptions.Filters.Tags)
+}
+
+func TestHoldExecute(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello"),
+	})
+	id := snap.Header.Identifier
+	hdr := *snap.Header
+	snap.Close()
+
+	cmd := &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-reason", "case 42", "-until", "2y", hex.EncodeToString(id[:4])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, fmt.Sprintf("%x: held: case 42 (until %s)\n", id, cmd.Until.Format(time.RFC3339)), bufOut.String())
+
+	hold, err := utils.LoadHold(repo, id, time.Now())
+	require.NoError(t, err)
+	require.NotNil(t, hold)
+	require.Equal(t, "case 42", hold.Reason)
+	require.True(t, cmd.Until.Equal(hold.Until))
+
+	// the snapshot is left untouched
+	var ids []objects.MAC
+	for snapshotID, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		ids = append(ids, snapshotID)
+	}
+	require.Equal(t, []objects.MAC{id}, ids)
+	held, err := snapshot.Load(repo, id)
+	require.NoError(t, err)
+	defer held.Close()
+	require.Equal(t, hdr.Duration, held.Header.Duration)
+	require.Equal(t, hdr.Context, held.Header.Context)
+
+	// placing the same hold again changes nothing
+	until := cmd.Until
+	cmd = &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-reason", "case 42", "-until", until.Format(time.RFC3339), hex.EncodeToString(id[:])}))
+	bufOut.Reset()
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "unchanged")
+
+	cmd = &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-release", hex.EncodeToString(id[:])}))
+	bufOut.Reset()
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, fmt.Sprintf("%x: released\n", id), bufOut.String())
+
+	hold, err = utils.LoadHold(repo, id, time.Now())
+	require.NoError(t, err)
+	require.Nil(t, hold)
+}
+
+func TestHoldExecuteSigned(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	})
+	defer snap.Close()
+
+	// a signed copy of the snapshot
+	kp, err := keypair.Generate()
+	require.NoError(t, err)
+	ctx.GetInner().Keypair = kp
+	builder, err := snap.Fork(&snapshot.BuilderOptions{})
+	require.NoError(t, err)
+	builder.Header.Identity = header.Identity{Identifier: uuid.New(), PublicKey: kp.PublicKey}
+	require.NoError(t, builder.Commit())
+	require.NoError(t, utils.ReleaseBuilder(repo, builder))
+	signedID := builder.Header.Identifier
+	require.NoError(t, repo.RebuildState())
+
+	// it is held without the key of its identity
+	ctx.GetInner().Keypair = nil
+	cmd := &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-reason", "case 42", hex.EncodeToString(signedID[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	hold, err := utils.LoadHold(repo, signedID, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, &utils.Hold{Reason: "case 42"}, hold)
+
+	signed, err := snapshot.Load(repo, signedID)
+	require.NoError(t, err)
+	defer signed.Close()
+	ok, err := signed.Verify()
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestHoldExecuteNoMatch(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Hold{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-reason", "x", "-name", "nothing"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+}
@@ -3,13 +3,13 @@ package info
 import (
 	"fmt"
 
-	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
 func (cmd *Info) executeErrors(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	snap, pathname, err := locate.OpenSnapshotByPath(repo, cmd.SnapshotID)
+	snap, pathname, _, err := utils.OpenSnapshotSource(repo, cmd.SnapshotID, cmd.Source)
 	if err != nil {
 		return 1, err
 	}
@@ -20,11 +20,22 @@ func (cmd *Info) executeErrors(ctx *appcontext.AppContext, repo *repository.Repo
 		return 1, err
 	}
 
+	var out *utils.JSONWriter
+	if cmd.JSON {
+		out = utils.NewJSONWriter(ctx.Stdout)
+	}
+
 	for item, err := range fs.Errors(pathname) {
 		if err != nil {
 			return 1, fmt.Errorf("failed to scan errors: %w", err)
 		}
 
+		if out != nil {
+			if err := out.Write("error", item); err != nil {
+				return 1, err
+			}
+			continue
+		}
 		fmt.Fprintf(ctx.Stdout, "%s: %s\n", item.Name, item.Error)
 	}
 	return 0, nil
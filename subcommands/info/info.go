@@ -33,14 +33,18 @@ type Info struct {
 	subcommands.SubcommandBase
 	SnapshotID string
 	Errors     bool
+	Source     string
+	JSON       bool
 }
 
 func (cmd *Info) Parse(ctx *appcontext.AppContext, args []string) error {
 	// Since this is the default action, we plug the general USAGE here.
 	flags := flag.NewFlagSet("info", flag.ExitOnError)
 	flags.BoolVar(&cmd.Errors, "errors", false, "display errors in the repository or snapshot")
+	flags.StringVar(&cmd.Source, "source", "", "display the given source of a multi-source snapshot")
+	flags.BoolVar(&cmd.JSON, "json", false, "output in JSON format")
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s [-errors] [SNAPSHOT]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [-errors] [-json] [-source SOURCE] [SNAPSHOT]\n", flags.Name())
 	}
 	flags.Parse(args)
 
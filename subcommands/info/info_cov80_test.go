@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
 	ptesting "github.com/PlakarKorp/plakar/testing"
@@ -98,3 +99,21 @@ func TestInfoCov80RepositoryMultiSnapshot(t *testing.T) {
 	require.Contains(t, out, "Hashing:")
 	require.Contains(t, out, "Storage size:")
 }
+
+// executeSnapshot shows the hold of a held snapshot.
+func TestInfoCov80SnapshotHeld(t *testing.T) {
+	t.Parallel()
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "alpha"),
+	}, ptesting.WithHold("case 42", time.Time{}))
+	defer snap.Close()
+
+	cmd := &Info{}
+	require.NoError(t, cmd.Parse(ctx, []string{hex.EncodeToString(snap.Header.Identifier[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "Hold: case 42\n")
+}
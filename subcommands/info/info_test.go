@@ -3,6 +3,7 @@ package info
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -192,3 +194,96 @@ func TestExecuteCmdInfoSnapshot(t *testing.T) {
 	require.Contains(t, output, fmt.Sprintf("Directory: %s", snap.Header.GetSource(0).Importer.Directory))
 	require.Contains(t, output, fmt.Sprintf("SnapshotID: %s", hex.EncodeToString(indexId[:])))
 }
+
+func TestExecuteCmdInfoSnapshotMultiSource(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("first.txt", 0644, "first"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockFile("second.txt", 0644, "second"),
+		ptesting.NewMockFile("third.txt", 0644, "third"),
+	}))
+	defer snap.Close()
+
+	indexId := snap.Header.GetIndexID()
+
+	cmd := &Info{}
+	require.NoError(t, cmd.Parse(ctx, []string{hex.EncodeToString(indexId[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Contains(t, output, "Sources:\n - 0: mock:mock /\n - 1: mock:other /\n")
+	require.Contains(t, output, "Source: 0\n")
+	require.Contains(t, output, "Origin: mock\n")
+	require.Contains(t, output, "Files: 1\n")
+
+	bufOut.Reset()
+	cmd = &Info{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-source", "other", hex.EncodeToString(indexId[:])}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output = bufOut.String()
+	require.Contains(t, output, "Source: 1\n")
+	require.Contains(t, output, "Origin: other\n")
+	require.Contains(t, output, "Files: 2\n")
+
+	cmd = &Info{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-source", "5", hex.EncodeToString(indexId[:])}))
+	status, err = cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdInfoJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("first.txt", 0644, "first"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockFile("second.txt", 0644, "second"),
+	}))
+	defer snap.Close()
+
+	decode := func() (string, map[string]any) {
+		var record utils.JSONRecord
+		require.NoError(t, json.Unmarshal(bufOut.Bytes(), &record))
+		require.Equal(t, utils.JSONVersion, record.Version)
+		return record.Type, record.Item.(map[string]any)
+	}
+
+	cmd := &Info{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	typ, item := decode()
+	require.Equal(t, "repository", typ)
+	require.Equal(t, float64(1), item["snapshots"].(map[string]any)["total"])
+	require.Equal(t, repo.Configuration().RepositoryID.String(), item["configuration"].(map[string]any)["repository_id"])
+
+	indexId := snap.Header.GetIndexID()
+	bufOut.Reset()
+	cmd = &Info{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "-source", "other", hex.EncodeToString(indexId[:])}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	typ, item = decode()
+	require.Equal(t, "snapshot", typ)
+	require.Equal(t, hex.EncodeToString(snap.Header.Identifier[:]), item["identifier"])
+	sources := item["sources"].([]any)
+	require.Len(t, sources, 2)
+	// the selected source comes first
+	require.Equal(t, "other", sources[0].(map[string]any)["importer"].(map[string]any)["origin"])
+}
@@ -3,13 +3,31 @@ package info
 import (
 	"fmt"
 
+	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
 )
 
+// repositoryInfo is the JSON output of info, with the fields of the
+// repository information returned by the API.
+type repositoryInfo struct {
+	Snapshots     repositorySnapshots   `json:"snapshots"`
+	Configuration storage.Configuration `json:"configuration"`
+}
+
+type repositorySnapshots struct {
+	Total       int   `json:"total"`
+	StorageSize int64 `json:"storage_size"`
+	LogicalSize int64 `json:"logical_size"`
+}
+
 func (cmd *Info) executeRepository(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.JSON {
+		return cmd.executeRepositoryJSON(ctx, repo)
+	}
 
 	fmt.Fprintln(ctx.Stdout, "Version:", repo.Configuration().Version)
 	fmt.Fprintln(ctx.Stdout, "Timestamp:", repo.Configuration().Timestamp)
@@ -87,3 +105,28 @@ func (cmd *Info) executeRepository(ctx *appcontext.AppContext, repo *repository.
 
 	return 0, nil
 }
+
+func (cmd *Info) executeRepositoryJSON(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	nSnapshots, logicalSize, err := snapshot.LogicalSize(repo)
+	if err != nil {
+		return 1, fmt.Errorf("unable to calculate logical size: %w", err)
+	}
+
+	storageSize, err := repo.Store().Size(ctx)
+	if err != nil {
+		return 1, fmt.Errorf("unable to compute storage size: %w", err)
+	}
+
+	err = utils.NewJSONWriter(ctx.Stdout).Write("repository", repositoryInfo{
+		Snapshots: repositorySnapshots{
+			Total:       nSnapshots,
+			StorageSize: storageSize,
+			LogicalSize: logicalSize,
+		},
+		Configuration: repo.Configuration(),
+	})
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
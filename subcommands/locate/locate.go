@@ -25,6 +25,7 @@ import (
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
@@ -45,6 +46,7 @@ func (cmd *Locate) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 
 	flags.StringVar(&cmd.Snapshot, "snapshot", "", "snapshot to locate in")
+	flags.BoolVar(&cmd.JSON, "json", false, "output one JSON record per line")
 	cmd.LocateOptions.InstallLocateFlags(flags)
 	flags.Parse(args)
 
@@ -64,6 +66,7 @@ type Locate struct {
 	LocateOptions *plocate.LocateOptions
 	Snapshot      string
 	Patterns      []string
+	JSON          bool
 }
 
 func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -79,6 +82,11 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		snapshots = append(snapshots, snapshotIDs...)
 	}
 
+	var out *utils.JSONWriter
+	if cmd.JSON {
+		out = utils.NewJSONWriter(ctx.Stdout)
+	}
+
 	for _, snapshotID := range snapshots {
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
@@ -115,6 +123,13 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 						continue
 					}
 				}
+				if out != nil {
+					if err := cmd.writeLocation(out, snap, fs, pathname); err != nil {
+						snap.Close()
+						return 1, fmt.Errorf("locate: %w", err)
+					}
+					continue
+				}
 				fmt.Fprintf(ctx.Stdout, "%x:%s\n", snap.Header.Identifier[0:4], utils.SanitizeText(pathname))
 			}
 		}
@@ -122,3 +137,14 @@ func (cmd *Locate) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 	}
 	return 0, nil
 }
+
+func (cmd *Locate) writeLocation(out *utils.JSONWriter, snap *snapshot.Snapshot, fs *vfs.Filesystem, pathname string) error {
+	entry, err := fs.GetEntry(pathname)
+	if err != nil {
+		return err
+	}
+	return out.Write("location", utils.SnapshotEntry{
+		Snapshot: *snap.Header,
+		Entry:    *entry,
+	})
+}
@@ -3,6 +3,7 @@ package locate
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
@@ -12,6 +13,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -140,3 +142,32 @@ func TestExecuteCmdLocateWithSnapshotId(t *testing.T) {
 	lines := strings.Split(strings.Trim(output, "\n"), "\n")
 	require.Equal(t, 1, len(lines))
 }
+
+func TestExecuteCmdLocateJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	cmd := &Locate{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "*.txt"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	paths := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(bufOut.String()), "\n") {
+		var record struct {
+			Version int                 `json:"version"`
+			Type    string              `json:"type"`
+			Item    utils.SnapshotEntry `json:"item"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		require.Equal(t, utils.JSONVersion, record.Version)
+		require.Equal(t, "location", record.Type)
+		require.Equal(t, snap.Header.Identifier, record.Item.Snapshot.Identifier)
+		paths = append(paths, record.Item.Entry.Path())
+	}
+	require.ElementsMatch(t, []string{"/another_subdir/bar.txt", "/subdir/dummy.txt", "/subdir/foo.txt"}, paths)
+}
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"io/fs"
 	"os/user"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,13 +48,17 @@ func (cmd *Ls) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("ls", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] -at TIME [PATH]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
 
 	flags.BoolVar(&cmd.DisplayUUID, "uuid", false, "display uuid instead of short ID")
 	flags.BoolVar(&cmd.Recursive, "recursive", false, "recursive listing")
+	flags.BoolVar(&cmd.JSON, "json", false, "output one JSON record per line")
 	flags.BoolVar(&cmd.ShowTags, "tags", false, "show tags")
+	flags.StringVar(&cmd.Source, "source", "", "list the given source of a multi-source snapshot")
+	flags.Var(locate.NewTimeFlag(&cmd.At), "at", "list the newest snapshot taken at or before the given time")
 
 	cmd.LocateOptions.InstallLocateFlags(flags)
 
@@ -77,11 +83,21 @@ type Ls struct {
 	Recursive     bool
 	DisplayUUID   bool
 	Path          []string
+	Source        string
+	At            time.Time
+	JSON          bool
 
 	ShowTags bool
 }
 
 func (cmd *Ls) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if !cmd.At.IsZero() {
+		if err := cmd.list_at(ctx, repo); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	if len(cmd.Path) == 0 {
 		if err := cmd.list_snapshots(ctx, repo); err != nil {
 			return 1, err
@@ -89,24 +105,39 @@ func (cmd *Ls) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		return 0, nil
 	}
 
-	if err := cmd.list_snapshot(ctx, repo, cmd.Path[0], cmd.Recursive); err != nil {
+	if err := cmd.list_snapshot(ctx, repo, cmd.Path[0], cmd.Source, cmd.Recursive); err != nil {
 		return 1, err
 	}
 	return 0, nil
 }
 
 func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repository) error {
+	utils.IgnoreCheckpoints(cmd.LocateOptions)
 	snapshotIDs, err := locate.LocateSnapshotIDs(repo, cmd.LocateOptions)
 	if err != nil {
 		return fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 	}
 
+	var out *utils.JSONWriter
+	if cmd.JSON {
+		out = utils.NewJSONWriter(ctx.Stdout)
+	}
+
 	for _, snapshotID := range snapshotIDs {
 		snap, err := snapshot.Load(repo, snapshotID)
 		if err != nil {
 			return fmt.Errorf("ls: could not fetch snapshot: %w", err)
 		}
 
+		if out != nil {
+			err := out.Write("snapshot", snap.Header)
+			snap.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		tags := ""
 		if cmd.ShowTags && len(snap.Header.Tags) > 0 {
 			tagList := strings.Join(snap.Header.Tags, ",")
@@ -115,23 +146,41 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 			}
 		}
 
+		hold := ""
+		h, err := utils.LoadHold(repo, snapshotID, time.Now())
+		if err != nil {
+			snap.Close()
+			return fmt.Errorf("ls: could not fetch the hold of snapshot: %w", err)
+		}
+		if h != nil {
+			hold = " held=" + h.String()
+		}
+
+		var size uint64
+		roots := make([]string, 0, len(snap.Header.Sources))
+		for i := range snap.Header.Sources {
+			source := &snap.Header.Sources[i]
+			size += source.Summary.Directory.Size + source.Summary.Below.Size
+			roots = append(roots, utils.SanitizeText(source.Importer.Directory))
+		}
+
 		if !cmd.DisplayUUID {
-			fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s%s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s%s%s\n",
 				snap.Header.Timestamp.UTC().Format(time.RFC3339),
 				hex.EncodeToString(snap.Header.GetIndexShortID()),
-				humanize.IBytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+				humanize.IBytes(size),
 				snap.Header.Duration.Round(time.Second),
-				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
-				tags)
+				strings.Join(roots, ","),
+				tags, hold)
 		} else {
 			indexID := snap.Header.GetIndexID()
-			fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s%s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s%s%s\n",
 				snap.Header.Timestamp.UTC().Format(time.RFC3339),
 				hex.EncodeToString(indexID[:]),
-				humanize.IBytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+				humanize.IBytes(size),
 				snap.Header.Duration.Round(time.Second),
-				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
-				tags)
+				strings.Join(roots, ","),
+				tags, hold)
 		}
 
 		snap.Close()
@@ -139,8 +188,29 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 	return nil
 }
 
-func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Repository, snapshotPath string, recursive bool) error {
-	snap, pathname, err := locate.OpenSnapshotByPath(repo, snapshotPath)
+func (cmd *Ls) list_at(ctx *appcontext.AppContext, repo *repository.Repository) error {
+	pathname := ""
+	if len(cmd.Path) != 0 {
+		pathname = cmd.Path[0]
+		if !path.IsAbs(pathname) {
+			pathname = path.Join(ctx.CWD, pathname)
+		}
+	}
+
+	snap, idx, err := utils.LocateAt(repo, cmd.LocateOptions, cmd.At, pathname, cmd.Source)
+	if err != nil {
+		return err
+	}
+	snapshotID := snap.Header.Identifier
+	fmt.Fprintf(ctx.Stderr, "ls: using snapshot %x from %s\n", snapshotID[:4],
+		snap.Header.Timestamp.UTC().Format(time.RFC3339))
+	snap.Close()
+
+	return cmd.list_snapshot(ctx, repo, fmt.Sprintf("%x:%s", snapshotID, pathname), strconv.Itoa(idx), cmd.Recursive)
+}
+
+func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Repository, snapshotPath, source string, recursive bool) error {
+	snap, pathname, _, err := utils.OpenSnapshotSource(repo, snapshotPath, source)
 	if err != nil {
 		return err
 	}
@@ -151,6 +221,11 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 		return err
 	}
 
+	var out *utils.JSONWriter
+	if cmd.JSON {
+		out = utils.NewJSONWriter(ctx.Stdout)
+	}
+
 	resolved := false
 	return pvfs.WalkDir(pathname, func(path string, d *vfs.Entry, err error) error {
 		if err != nil {
@@ -171,6 +246,16 @@ func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Reposi
 			return nil
 		}
 
+		if out != nil {
+			if err := out.Write("entry", d); err != nil {
+				return err
+			}
+			if !recursive && pathname != path && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
 		sb, err := d.Info()
 		if err != nil {
 			return err
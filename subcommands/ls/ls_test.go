@@ -3,6 +3,8 @@ package ls
 import (
 	"bytes"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -13,6 +15,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -209,3 +212,199 @@ func TestExecuteCmdLsFilterUuid(t *testing.T) {
 	require.Equal(t, hex.EncodeToString(indexId[:]), fields[1])
 	require.Equal(t, snap.Header.GetSource(0).Importer.Directory, fields[len(fields)-1])
 }
+
+func TestLsMultiSource(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("first.txt", 0644, "first"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockFile("second.txt", 0644, "second"),
+	}))
+	defer snap.Close()
+
+	snapshotID := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	// without -source, the first source is listed
+	cmd := &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{snapshotID}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "first.txt")
+	require.NotContains(t, bufOut.String(), "second.txt")
+
+	bufOut.Reset()
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-source", "other", snapshotID}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "second.txt")
+	require.NotContains(t, bufOut.String(), "first.txt")
+
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-source", "nope", snapshotID}))
+	status, err = cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+
+	// the snapshot listing shows every root
+	bufOut.Reset()
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	fields := strings.Fields(strings.TrimSpace(bufOut.String()))
+	require.Equal(t, "/,/", fields[len(fields)-1])
+}
+
+func TestLsAt(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	older := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("older.txt", 0644, "older"),
+	}, ptesting.WithTimestamp(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)))
+	defer older.Close()
+	newer := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("newer.txt", 0644, "newer"),
+	}, ptesting.WithTimestamp(time.Date(2026, 10, 3, 12, 0, 0, 0, time.UTC)))
+	defer newer.Close()
+
+	cmd := &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-at", "2026-10-02", "/"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "older.txt")
+	require.NotContains(t, bufOut.String(), "newer.txt")
+	require.Contains(t, bufErr.String(), fmt.Sprintf("ls: using snapshot %x from 2026-10-01T12:00:00Z", older.Header.Identifier[:4]))
+
+	// without a path, the root of the newest snapshot is listed
+	bufOut.Reset()
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-at", "2026-10-04"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "newer.txt")
+
+	// the locate filters still apply
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-at", "2026-10-04", "-origin", "elsewhere"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no snapshot found at or before")
+	require.Equal(t, 1, status)
+}
+
+func decodeJSONRecords(t *testing.T, output string) []utils.JSONRecord {
+	records := []utils.JSONRecord{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var record utils.JSONRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		require.Equal(t, utils.JSONVersion, record.Version)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLsJSON(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+	})
+	defer snap.Close()
+
+	cmd := &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	records := decodeJSONRecords(t, bufOut.String())
+	require.Len(t, records, 1)
+	require.Equal(t, "snapshot", records[0].Type)
+	item := records[0].Item.(map[string]any)
+	require.Equal(t, hex.EncodeToString(snap.Header.Identifier[:]), item["identifier"])
+	require.Len(t, item["sources"], 1)
+
+	bufOut.Reset()
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", hex.EncodeToString(snap.Header.Identifier[:]) + ":/subdir"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	names := []string{}
+	for _, record := range decodeJSONRecords(t, bufOut.String()) {
+		require.Equal(t, "entry", record.Type)
+		item := record.Item.(map[string]any)
+		require.Equal(t, "/subdir", item["parent_path"])
+		names = append(names, item["file_info"].(map[string]any)["name"].(string))
+	}
+	require.Equal(t, []string{"dummy.txt", "foo.txt"}, names)
+}
+
+func TestLsHeld(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	until := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("dummy.txt", 0644, "hello dummy"),
+	}, ptesting.WithHold("case 42", until))
+	defer snap.Close()
+
+	cmd := &Ls{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.True(t, strings.HasSuffix(bufOut.String(), " held=case 42 (until 2099-01-01T00:00:00Z)\n"), bufOut.String())
+}
+
+func TestLsCheckpoint(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("complete.txt", 0644, "complete"),
+	}, ptesting.WithTimestamp(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)))
+	defer snap.Close()
+	checkpoint := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("partial.txt", 0644, "partial"),
+	}, ptesting.WithTimestamp(time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC)), ptesting.WithTags(utils.CheckpointTag))
+	defer checkpoint.Close()
+
+	cmd := &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-uuid"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), hex.EncodeToString(snap.Header.Identifier[:]))
+	require.NotContains(t, bufOut.String(), hex.EncodeToString(checkpoint.Header.Identifier[:]))
+
+	bufOut.Reset()
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-uuid", "-tag", utils.CheckpointTag}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, bufOut.String(), hex.EncodeToString(snap.Header.Identifier[:]))
+	require.Contains(t, bufOut.String(), hex.EncodeToString(checkpoint.Header.Identifier[:]))
+
+	bufOut.Reset()
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-at", "2026-10-03"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "complete.txt")
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"time"
@@ -29,8 +30,12 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/retention"
+	"github.com/PlakarKorp/plakar/server/worm"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -40,12 +45,25 @@ func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &Maintenance{} }, 0, "maintenance")
 }
 
-func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
-	flags := flag.NewFlagSet("maintenance", flag.ExitOnError)
+func newFlagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet("maintenance", flag.ContinueOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
 	}
-	flags.Parse(args)
+	return flags
+}
+
+// CheckArgs validates the arguments of a maintenance without running it.
+func (cmd *Maintenance) CheckArgs(args []string) error {
+	flags := newFlagSet()
+	flags.SetOutput(io.Discard)
+	return flags.Parse(args)
+}
+
+func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
+	if err := newFlagSet().Parse(args); err != nil {
+		return err
+	}
 
 	cmd.RepositorySecret = ctx.GetSecret()
 
@@ -58,54 +76,119 @@ type Maintenance struct {
 	repository    *repository.Repository
 	maintenanceID objects.MAC
 	cutoff        time.Time
+
+	// the policy of a WORM store, as read through its server
+	worm *worm.Policy
+
+	// the packfiles of the hold records of the snapshots kept
+	holds map[objects.MAC]struct{}
 }
 
-// Builds the local cache of snapshot -> packfiles
-func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
-	wg := new(errgroup.Group)
-	wg.SetLimit(ctx.MaxConcurrency)
+// keepHold records the packfiles of the hold records of a snapshot, kept
+// along with it.
+func (cmd *Maintenance) keepHold(snapshotID objects.MAC) error {
+	packfiles, err := utils.HoldPackfiles(cmd.repository, snapshotID)
+	if err != nil {
+		return err
+	}
+	for _, packfile := range packfiles {
+		cmd.holds[packfile] = struct{}{}
+	}
+	return nil
+}
 
-	for snapshotID, err := range cmd.repository.ListSnapshots() {
+// cacheSnapshot records the packfiles of a snapshot in the local cache.
+func (cmd *Maintenance) cacheSnapshot(ctx *appcontext.AppContext, cache *caching.MaintenanceCache, snapshotID objects.MAC) error {
+	snapshot, err := snapshot.Load(cmd.repository, snapshotID)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	ok, err := cache.HasSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return nil
+	}
+
+	// Packfiles are listed per source, make sure we
+	// don't miss those only referenced by a secondary
+	// source or they would be swept.
+	for source, err := range utils.SnapshotSources(cmd.repository, snapshot) {
 		if err != nil {
 			return err
 		}
-		wg.Go(func() error {
-			snapshot, err := snapshot.Load(cmd.repository, snapshotID)
-			if err != nil {
-				return err
-			}
-			defer snapshot.Close()
 
-			ok, err := cache.HasSnapshot(snapshotID)
+		iter, err := source.ListPackfiles()
+		if err != nil {
+			return err
+		}
+
+		for packfile, err := range iter {
 			if err != nil {
 				return err
 			}
 
-			if ok {
-				return nil
+			if err := ctx.Err(); err != nil {
+				return err
 			}
 
-			iter, err := snapshot.ListPackfiles()
-			if err != nil {
+			if err := cache.PutPackfile(snapshotID, packfile); err != nil {
 				return err
 			}
+		}
+	}
 
-			for packfile, err := range iter {
-				if err != nil {
-					return err
-				}
+	cache.PutSnapshot(snapshotID, nil)
+	return nil
+}
 
-				if err := ctx.Err(); err != nil {
-					return err
-				}
+// liveRoots returns the roots of the sources of the snapshots.
+func (cmd *Maintenance) liveRoots() (map[objects.MAC]struct{}, error) {
+	roots := make(map[objects.MAC]struct{})
+	for snapshotID, err := range cmd.repository.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		hdr, _, err := snapshot.GetSnapshot(cmd.repository, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range hdr.Sources {
+			roots[hdr.Sources[i].VFS.Root] = struct{}{}
+		}
+	}
+	return roots, nil
+}
 
-				if err := cache.PutPackfile(snapshotID, packfile); err != nil {
-					return err
-				}
-			}
+// replaced returns whether the content of a snapshot is that of others.
+func replaced(hdr *header.Header, roots map[objects.MAC]struct{}) bool {
+	for i := range hdr.Sources {
+		if _, found := roots[hdr.Sources[i].VFS.Root]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Builds the local cache of snapshot -> packfiles
+func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+	wg := new(errgroup.Group)
+	wg.SetLimit(ctx.MaxConcurrency)
 
-			cache.PutSnapshot(snapshotID, nil)
-			return nil
+	cmd.holds = make(map[objects.MAC]struct{})
+	for snapshotID, err := range cmd.repository.ListSnapshots() {
+		if err != nil {
+			return err
+		}
+		if err := cmd.keepHold(snapshotID); err != nil {
+			return err
+		}
+		wg.Go(func() error {
+			return cmd.cacheSnapshot(ctx, cache, snapshotID)
 		})
 	}
 
@@ -115,7 +198,45 @@ func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.M
 
 	// While ListSnapshots doesn't return deleted snapshots, we still need to
 	// go over them to remove previously added one to our local cache.
+	now := time.Now()
+	var liveRoots map[objects.MAC]struct{}
 	for snapshotID := range cmd.repository.ListDeletedSnapShots() {
+		// A held snapshot removed anyway, e.g. by an older client, keeps
+		// its packfiles so that it can still be recovered, unless it was
+		// replaced by a copy as done by tag.  So does a snapshot of a WORM
+		// store removed within the retention, as any client allowed to
+		// append may remove snapshots.
+		if hdr, _, err := snapshot.GetSnapshot(cmd.repository, snapshotID); err == nil {
+			hold, err := utils.LoadHold(cmd.repository, snapshotID, now)
+			if err != nil {
+				return err
+			}
+
+			var kept string
+			if hold != nil {
+				kept = fmt.Sprintf("held (%s)", hold)
+			} else if cmd.worm != nil && !cmd.worm.Expired(hdr.Timestamp) {
+				kept = fmt.Sprintf("within the WORM retention of %s", cmd.worm.Retention)
+			}
+			if kept != "" {
+				if liveRoots == nil {
+					if liveRoots, err = cmd.liveRoots(); err != nil {
+						return err
+					}
+				}
+				if !replaced(hdr, liveRoots) {
+					fmt.Fprintf(ctx.Stderr, "maintenance: Snapshot %x was removed while %s, keeping its packfiles\n", snapshotID[:4], kept)
+					if err := cmd.cacheSnapshot(ctx, cache, snapshotID); err != nil {
+						return err
+					}
+					if err := cmd.keepHold(snapshotID); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		}
+
 		ok, err := cache.HasSnapshot(snapshotID)
 		if err != nil {
 			return err
@@ -197,6 +318,9 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 		if cache.HasPackfile(packfile) {
 			continue
 		}
+		if _, held := cmd.holds[packfile]; held {
+			continue
+		}
 
 		has, err := cmd.repository.HasDeletedPackfile(packfile)
 		if err != nil {
@@ -332,6 +456,29 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 
 	cmd.repository = repo
 
+	// WORM stores are only maintained through their server, with the admin
+	// token, which hides the policy.
+	if policy, err := worm.Load(ctx, repo.Store()); err != nil {
+		return 1, err
+	} else if policy != nil {
+		return 1, fmt.Errorf("%w, maintenance must go through plakar server with its admin token", worm.ErrEnabled)
+	}
+	// Through the server, the packfiles of the snapshots removed within the
+	// retention must be kept: not knowing whether there is a policy is an
+	// error.
+	policy, err := worm.Fetch(ctx, repo.Store())
+	if err != nil {
+		return 1, fmt.Errorf("failed to read the WORM policy: %w", err)
+	}
+	cmd.worm = policy
+
+	// The snapshots the retention policy removes are swept right away.
+	if ctx.Retention != "" {
+		if _, err := retention.Apply(ctx, repo, ctx.Retention); err != nil {
+			return 1, fmt.Errorf("failed to apply the retention policy %s: %w", ctx.Retention, err)
+		}
+	}
+
 	// This need to be configurable per repo, but we don't have a mechanism yet (comes in a PR soon!)
 	duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
 	if err != nil {
@@ -2,6 +2,7 @@ package maintenance
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -12,8 +13,12 @@ import (
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/server/worm"
+	"github.com/PlakarKorp/plakar/subcommands/tag"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -878,3 +883,149 @@ func TestNoStrayMessagesWhenNothingToDo(t *testing.T) {
 	require.NotContains(t, errOut, "Concurrent backup",
 		"no concurrent-backup warning when nothing was coloured")
 }
+
+func TestColourPassKeepsPackfilesOfSecondarySources(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, extraFiles("shared"), ptesting.WithName("snap1"))
+	// snap2 only references the shared data through its second source.
+	snap2 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap2"),
+		ptesting.WithSource("other", extraFiles("shared")))
+
+	view, err := utils.SnapshotSource(repo, snap2, 1)
+	require.NoError(t, err)
+	iter, err := view.ListPackfiles()
+	require.NoError(t, err)
+	referenced := make(map[objects.MAC]struct{})
+	for mac, err := range iter {
+		require.NoError(t, err)
+		referenced[mac] = struct{}{}
+	}
+	require.NotEmpty(t, referenced)
+
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+	colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+
+	for mac := range colouredPackfiles(t, repo) {
+		_, ok := referenced[mac]
+		require.False(t, ok, "packfile %x is still referenced by snap2", mac)
+	}
+}
+
+// --- Retention -------------------------------------------------------------
+
+func TestMaintenanceAppliesRetention(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("old"),
+		ptesting.WithTimestamp(time.Now().Add(-30*24*time.Hour)))
+	recent := ptesting.GenerateSnapshot(t, repo, extraFiles("recent"), ptesting.WithName("recent"))
+
+	ctx.ConfigDir = t.TempDir()
+	configFile := ctx.ConfigDir + "/policies.yml"
+	cfg, err := utils.LoadPolicyConfigFile(configFile)
+	require.NoError(t, err)
+	cfg.Add("week")
+	require.NoError(t, cfg.Set("week", "days", "7"))
+	require.NoError(t, cfg.SaveToFile(configFile))
+	ctx.Retention = "week"
+
+	status, err, out, _ := runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, out, "retention: policy week removed 1 snapshots")
+
+	require.NoError(t, repo.RebuildState())
+	var remaining []objects.MAC
+	for id, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		remaining = append(remaining, id)
+	}
+	require.Equal(t, []objects.MAC{recent.Header.GetIndexID()}, remaining)
+
+	// a policy that doesn't exist stops the maintenance
+	ctx.Retention = "missing"
+	status, err, _, _ = runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.ErrorContains(t, err, `policy "missing" not found`)
+	require.Equal(t, 1, status)
+}
+
+func TestMaintenanceKeepsRemovedHeldSnapshots(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	held := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("held"),
+		ptesting.WithHold("case 42", time.Time{}))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("alive"), ptesting.WithName("alive"))
+
+	// removed without looking at its hold, as an older client would
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, held.Header.GetIndexID())
+
+	status, err, out, errOut := runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, errOut, fmt.Sprintf("maintenance: Snapshot %x was removed while held (case 42), keeping its packfiles", held.Header.Identifier[:4]))
+	require.Contains(t, out, "maintenance: Coloured 0 packfiles (0 orphaned) for deletion")
+}
+
+func TestMaintenanceKeepsHoldRecords(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	held := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("held"),
+		ptesting.WithHold("case 42", time.Time{}))
+
+	status, err, out, _ := runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, out, "maintenance: Coloured 0 packfiles (0 orphaned) for deletion")
+
+	hold, err := utils.LoadHold(repo, held.Header.Identifier, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, &utils.Hold{Reason: "case 42"}, hold)
+}
+
+func TestMaintenanceReleasesReplacedHeldSnapshots(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	held := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("held"),
+		ptesting.WithHold("case 42", time.Time{}))
+
+	// tagging it replaces the snapshot by a copy sharing its content,
+	// which the hold is carried over to
+	_, err := tag.Edit(ctx, repo, held, func(hdr *header.Header) bool {
+		hdr.Tags = append(hdr.Tags, "x")
+		return true
+	})
+	require.NoError(t, err)
+	require.NoError(t, repo.RebuildState())
+
+	status, err, _, errOut := runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, errOut, "removed while held")
+}
+
+func TestMaintenanceKeepsSnapshotsRemovedWithinWORMRetention(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	removed := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("removed"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("alive"), ptesting.WithName("alive"))
+
+	// any client allowed to append may remove a snapshot
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, removed.Header.GetIndexID())
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	cmd.repository = repo
+	cmd.worm = &worm.Policy{Retention: 24 * time.Hour}
+
+	cache, err := repo.AppContext().GetCache().Maintenance(repo.Configuration().RepositoryID)
+	require.NoError(t, err)
+
+	bufErr.Reset()
+	require.NoError(t, cmd.updateCache(ctx, cache))
+	require.Contains(t, bufErr.String(), fmt.Sprintf("maintenance: Snapshot %x was removed while within the WORM retention of 24h0m0s, keeping its packfiles", removed.Header.Identifier[:4]))
+	ok, err := cache.HasSnapshot(removed.Header.GetIndexID())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// once past the retention, its packfiles are released
+	cmd.worm.Retention = time.Nanosecond
+	require.NoError(t, cmd.updateCache(ctx, cache))
+	ok, err = cache.HasSnapshot(removed.Header.GetIndexID())
+	require.NoError(t, err)
+	require.False(t, ok)
+}
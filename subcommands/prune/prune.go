@@ -180,6 +180,7 @@ type planEntry struct {
 }
 
 func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	utils.IgnoreCheckpoints(cmd.LocateOptions)
 	_, reasons, err := locate.Match(repo, cmd.LocateOptions)
 	if err != nil {
 		return 1, err
@@ -188,11 +189,8 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	toDelete := make([]objects.MAC, 0, len(reasons))
 	entries := make([]planEntry, 0, len(reasons))
 
+	now := time.Now()
 	for id, r := range reasons {
-		if r.Action == "delete" {
-			toDelete = append(toDelete, id)
-		}
-
 		snap, err := snapshot.Load(repo, id)
 		if err != nil {
 			ctx.GetLogger().Warn("prune: skipping %x for timestamp lookup: %v", id[:4], err)
@@ -211,8 +209,22 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
 			tags)
 		snap.Close()
+		hold, err := utils.LoadHold(repo, id, now)
+		if err != nil {
+			return 1, err
+		}
 		entry := planEntry{prefix: prefix, id: id, key: r.Bucket, ts: snap.Header.Timestamp}
 
+		// held snapshots are kept whatever the policy decides
+		if r.Action == "delete" && hold != nil {
+			ctx.GetLogger().Info("prune: keeping %x, held: %s", id[:4], hold)
+			r = locate.Reason{Action: "keep", Note: "held: " + hold.String()}
+			reasons[id] = r
+		}
+		if r.Action == "delete" {
+			toDelete = append(toDelete, id)
+		}
+
 		r, ok := reasons[id]
 		// Default to "skip" if we couldn't evaluate (e.g., missing timestamp)
 		entry.reason = r
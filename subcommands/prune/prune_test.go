@@ -16,6 +16,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -330,3 +331,69 @@ func TestMergeFilters(t *testing.T) {
 		}
 	})
 }
+
+func TestPrune_HeldKept(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	// two snapshots in the same minute, the older one held
+	at := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	held := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello A"),
+	}, ptesting.WithTimestamp(at.Add(time.Second)), ptesting.WithHold("case 42", time.Time{}))
+	defer held.Close()
+	newest := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "hello B"),
+	}, ptesting.WithTimestamp(at.Add(2*time.Second)))
+	defer newest.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 2 and delete 0 snapshot(s)")
+	require.Contains(t, bufOut.String(), "reason=held: case 42")
+
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply", "--per-minute=1"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, bufOut.String(), "completed successfully")
+
+	require.NoError(t, repo.RebuildState())
+	count := 0
+	for _, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		count++
+	}
+	require.Equal(t, 2, count)
+}
+
+func TestPrune_CheckpointKept(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	// the checkpoint is neither pruned nor kept in place of the snapshot
+	at := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "hello A"),
+	}, ptesting.WithTimestamp(at.Add(time.Second)))
+	defer snap.Close()
+	checkpoint := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "hello B"),
+	}, ptesting.WithTimestamp(at.Add(2*time.Second)), ptesting.WithTags(utils.CheckpointTag))
+	defer checkpoint.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 1 and delete 0 snapshot(s)")
+	require.Contains(t, bufOut.String(), fmt.Sprintf("%x", snap.Header.Identifier[:4]))
+	require.NotContains(t, bufOut.String(), fmt.Sprintf("%x", checkpoint.Header.Identifier[:4]))
+}
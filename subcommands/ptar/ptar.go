@@ -39,6 +39,7 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/versioning"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/ratelimit"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/google/uuid"
@@ -119,7 +120,7 @@ func (cmd *Ptar) Parse(ctx *appcontext.AppContext, args []string) error {
 			return fmt.Errorf("peer repository: %w", err)
 		}
 
-		peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+		peerStore, peerStoreSerializedConfig, err := ratelimit.Open(ctx.GetInner(), storeConfig, ctx.Limits)
 		if err != nil {
 			return err
 		}
@@ -277,6 +278,7 @@ func (cmd *Ptar) Execute(ctx *appcontext.AppContext, _ *repository.Repository) (
 	if err != nil {
 		return 1, err
 	}
+	st = ctx.Limits.Wrap(st)
 
 	repo, err := repository.New(ctx.GetInner(), key, st, wrappedConfig)
 	if err != nil {
@@ -298,7 +300,7 @@ func (cmd *Ptar) Execute(ctx *appcontext.AppContext, _ *repository.Repository) (
 			return 1, fmt.Errorf("source repository: %w", err)
 		}
 
-		peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+		peerStore, peerStoreSerializedConfig, err := ratelimit.Open(ctx.GetInner(), storeConfig, ctx.Limits)
 		if err != nil {
 			return 1, fmt.Errorf("could not open source store %s: %s", syncTarget, err)
 		}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/dustin/go-humanize"
+)
+
+// restoreStats accounts for what was written for a restored item.
+type restoreStats struct {
+	Files       uint64
+	Directories uint64
+	Symlinks    uint64
+	Size        uint64
+	Errors      uint64
+	Skipped     uint64
+
+	// with -verify
+	Verified   uint64
+	Mismatches uint64
+}
+
+func (st *restoreStats) account(res *connectors.Result) {
+	if res.Err != nil {
+		st.Errors++
+		return
+	}
+	if res.Record.IsXattr || res.Record.Err != nil {
+		return
+	}
+
+	mode := res.Record.FileInfo.Mode()
+	switch {
+	case mode.IsDir():
+		st.Directories++
+	case mode&os.ModeSymlink != 0:
+		st.Symlinks++
+	case mode.IsRegular():
+		st.Files++
+		st.Size += uint64(res.Record.FileInfo.Size())
+	}
+}
+
+func (st *restoreStats) String() string {
+	return fmt.Sprintf("%d files, %d directories, %d symlinks, %s, %d errors, %d skipped",
+		st.Files, st.Directories, st.Symlinks, humanize.IBytes(st.Size), st.Errors, st.Skipped)
+}
+
+func (st *restoreStats) add(other *restoreStats) {
+	st.Files += other.Files
+	st.Directories += other.Directories
+	st.Symlinks += other.Symlinks
+	st.Size += other.Size
+	st.Errors += other.Errors
+	st.Skipped += other.Skipped
+	st.Verified += other.Verified
+	st.Mismatches += other.Mismatches
+}
+
+// multiExporter funnels the records of several successive exports into a
+// single Export call of the underlying exporter.  Connectors producing a
+// single stream, like tar, can't be exported to more than once, this way
+// all the restored items end up in the same output.
+type multiExporter struct {
+	exporter.Exporter
+
+	records chan *connectors.Record
+	results chan *connectors.Result
+	done    chan struct{}
+	err     error
+}
+
+func newMultiExporter(ctx context.Context, exp exporter.Exporter, bufsize int) *multiExporter {
+	m := &multiExporter{
+		Exporter: exp,
+		records:  make(chan *connectors.Record, bufsize),
+		results:  make(chan *connectors.Result, bufsize),
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(m.done)
+		m.err = exp.Export(ctx, m.records, m.results)
+	}()
+
+	return m
+}
+
+// Wait terminates the underlying export and returns its error.
+func (m *multiExporter) Wait() error {
+	close(m.records)
+	for range m.results {
+	}
+	<-m.done
+	return m.err
+}
+
+// Item returns an exporter writing below prefix, a sub-directory of the
+// underlying exporter, and accounting for the records it writes in stats.
+// Items must be exported one after the other.
+func (m *multiExporter) Item(prefix string, stats *restoreStats) exporter.Exporter {
+	return &itemExporter{
+		Exporter: m.Exporter,
+		multi:    m,
+		prefix:   prefix,
+		stats:    stats,
+	}
+}
+
+type itemExporter struct {
+	exporter.Exporter
+
+	multi  *multiExporter
+	prefix string
+	stats  *restoreStats
+}
+
+func (e *itemExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+
+	// The exporters only create the leaf directory, create the leading
+	// ones of the sub-target ourselves and hide them from the caller.
+	parents := make(map[string]struct{})
+	for dir := path.Dir(e.prefix); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		parents[dir] = struct{}{}
+	}
+
+	total := make(chan int, 1)
+	go func() {
+		n := 0
+		send := func(record *connectors.Record) bool {
+			select {
+			case e.multi.records <- record:
+				n++
+				return true
+			case <-e.multi.done:
+				return false
+			}
+		}
+
+		alive := true
+		// ancestors sort before their descendants
+		for _, dir := range slices.Sorted(maps.Keys(parents)) {
+			if alive = send(mkdirRecord(dir)); !alive {
+				break
+			}
+		}
+
+		for record := range records {
+			if !alive {
+				// keep draining so that the producer terminates
+				continue
+			}
+			if e.prefix != "" {
+				record.Pathname = path.Join(e.prefix, record.Pathname)
+			}
+			alive = send(record)
+		}
+		total <- n
+	}()
+
+	expected := -1
+	received := 0
+	for expected == -1 || received < expected {
+		select {
+		case n := <-total:
+			expected = n
+		case res, ok := <-e.multi.results:
+			if !ok {
+				<-e.multi.done
+				<-total
+				if e.multi.err != nil {
+					return e.multi.err
+				}
+				return errors.New("exporter terminated unexpectedly")
+			}
+			received++
+			if _, ok := parents[res.Record.Pathname]; ok {
+				continue
+			}
+			e.stats.account(res)
+			results <- res
+		}
+	}
+	return nil
+}
+
+func mkdirRecord(dir string) *connectors.Record {
+	fileinfo := objects.FileInfo{
+		Lname:    path.Base(dir),
+		Lmode:    0755 | os.ModeDir,
+		LmodTime: time.Now(),
+	}
+	return connectors.NewRecord(dir, "", fileinfo, nil, func() (io.ReadCloser, error) {
+		return nil, nil
+	})
+}
+
+// cleanSubtarget validates a sub-target and turns it into an absolute path
+// within the restore target.
+func cleanSubtarget(subtarget string) (string, error) {
+	if subtarget == "" {
+		return "", nil
+	}
+	for _, comp := range strings.Split(subtarget, "/") {
+		if comp == ".." {
+			return "", errors.New("sub-target must not contain ..")
+		}
+	}
+	cleaned := path.Clean("/" + subtarget)
+	if cleaned == "/" {
+		return "", nil
+	}
+	return cleaned, nil
+}
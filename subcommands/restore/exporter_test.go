@@ -0,0 +1,91 @@
+package restore
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingExporter remembers the pathnames it was asked to export and how
+// many times Export was called.
+type recordingExporter struct {
+	exporter.Exporter
+
+	calls     atomic.Int32
+	pathnames []string
+}
+
+func (e *recordingExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+	e.calls.Add(1)
+	for record := range records {
+		e.pathnames = append(e.pathnames, record.Pathname)
+		results <- record.Ok()
+	}
+	return nil
+}
+
+func exportItem(t *testing.T, exp exporter.Exporter, pathnames ...string) {
+	t.Helper()
+
+	records := make(chan *connectors.Record)
+	results := make(chan *connectors.Result)
+	go func() {
+		defer close(records)
+		for _, pathname := range pathnames {
+			fileinfo := objects.FileInfo{Lname: pathname, Lmode: 0644, Lsize: 10}
+			records <- connectors.NewRecord(pathname, "", fileinfo, nil, nil)
+		}
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- exp.Export(context.Background(), records, results) }()
+
+	n := 0
+	for range results {
+		n++
+	}
+	require.NoError(t, <-done)
+	require.Equal(t, len(pathnames), n)
+}
+
+func TestMultiExporterSingleExport(t *testing.T) {
+	rec := &recordingExporter{}
+	multi := newMultiExporter(context.Background(), rec, 1)
+
+	var first, second restoreStats
+	exportItem(t, multi.Item("", &first), "/a", "/b")
+	exportItem(t, multi.Item("/sub/dir", &second), "/c")
+	require.NoError(t, multi.Wait())
+
+	require.Equal(t, int32(1), rec.calls.Load())
+	require.Equal(t, []string{"/a", "/b", "/sub", "/sub/dir/c"}, rec.pathnames)
+
+	// the synthetic parent directories are not accounted
+	require.Equal(t, uint64(2), first.Files)
+	require.Equal(t, uint64(20), first.Size)
+	require.Equal(t, uint64(1), second.Files)
+	require.Equal(t, uint64(0), second.Directories)
+}
+
+func TestRestoreStatsAccount(t *testing.T) {
+	var stats restoreStats
+
+	dir := connectors.NewRecord("/d", "", objects.FileInfo{Lmode: 0755 | os.ModeDir}, nil, nil)
+	link := connectors.NewRecord("/l", "/d", objects.FileInfo{Lmode: 0777 | os.ModeSymlink}, nil, nil)
+	file := connectors.NewRecord("/f", "", objects.FileInfo{Lmode: 0644, Lsize: 42}, nil, nil)
+
+	stats.account(dir.Ok())
+	stats.account(link.Ok())
+	stats.account(file.Ok())
+	stats.account(file.Error(os.ErrPermission))
+
+	require.Equal(t, restoreStats{Files: 1, Directories: 1, Symlinks: 1, Size: 42, Errors: 1}, stats)
+	require.Equal(t, "1 files, 1 directories, 1 symlinks, 42 B, 1 errors, 0 skipped", stats.String())
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/exclude"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+// conflictPolicy tells what to do with a file that already exists at
+// the destination.
+type conflictPolicy string
+
+const (
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictSkip      conflictPolicy = "skip"
+	conflictKeepNewer conflictPolicy = "keep-newer"
+	conflictRename    conflictPolicy = "rename"
+)
+
+func parseConflictPolicy(policy string) (conflictPolicy, error) {
+	switch p := conflictPolicy(policy); p {
+	case conflictOverwrite, conflictSkip, conflictKeepNewer, conflictRename:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy %q: must be one of overwrite, skip, keep-newer or rename", policy)
+	}
+}
+
+// restoreAction is what happens to a record at the destination.
+type restoreAction string
+
+const (
+	actionCreate    restoreAction = "create"
+	actionOverwrite restoreAction = "overwrite"
+	actionSkip      restoreAction = "skip"
+	actionRename    restoreAction = "rename"
+
+	// the destination isn't local, it is unknown whether the file exists
+	actionWrite restoreAction = "write"
+)
+
+// writtenFile is a regular file restored to the local filesystem.
+type writtenFile struct {
+	target   string
+	pathname string
+}
+
+// filterExporter drops the records matching the ignore rules and applies
+// the conflict policy before handing them over to the exporter, so that
+// the content of the files that won't be written is never fetched.
+type filterExporter struct {
+	exporter.Exporter
+
+	// root is the snapshot directory the records are relative to, the
+	// ignore rules are matched against the snapshot paths.
+	root     string
+	excludes *exclude.RuleSet
+
+	// destination is the local directory the records are written to,
+	// or empty if the exporter isn't writing to the local filesystem.
+	destination string
+	onConflict  conflictPolicy
+
+	// with dryRun set, the records are listed to out with the action
+	// that would be taken rather than exported.
+	dryRun bool
+	out    io.Writer
+	prefix string
+
+	// with verify set, the regular files successfully written are
+	// collected in written.
+	verify  bool
+	written []writtenFile
+
+	stats *restoreStats
+}
+
+func (f *filterExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	if f.dryRun {
+		return f.list(records, results)
+	}
+
+	var mu sync.Mutex
+	// the snapshot path of the files to verify, by restored path
+	sources := make(map[string]string)
+
+	filtered := make(chan *connectors.Record, cap(records))
+	go func() {
+		defer close(filtered)
+
+		// excluded directories, whose content is dropped as well
+		ignored := make(map[string]struct{})
+
+		for record := range records {
+			if f.ignore(record, ignored) {
+				f.stats.Skipped++
+				continue
+			}
+			pathname := path.Join(f.root, record.Pathname)
+			if f.resolve(record) == actionSkip {
+				f.stats.Skipped++
+				continue
+			}
+			if f.verify && !record.IsXattr {
+				mu.Lock()
+				sources[record.Pathname] = pathname
+				mu.Unlock()
+			}
+			filtered <- record
+		}
+	}()
+
+	acks := make(chan *connectors.Result, cap(results))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(results)
+
+		for res := range acks {
+			if f.verify && res.Err == nil && !res.Record.IsXattr && res.Record.FileInfo.Lmode.IsRegular() {
+				// the item exporter moved the record below the sub-target
+				restored := res.Record.Pathname
+				if f.prefix != "" {
+					restored = path.Join("/", strings.TrimPrefix(restored, f.prefix))
+				}
+				mu.Lock()
+				pathname, ok := sources[restored]
+				delete(sources, restored)
+				mu.Unlock()
+				if ok {
+					f.written = append(f.written, writtenFile{
+						target:   filepath.Join(f.destination, filepath.FromSlash(restored)),
+						pathname: pathname,
+					})
+				}
+			}
+			results <- res
+		}
+	}()
+
+	err := f.Exporter.Export(ctx, filtered, acks)
+	<-done
+	return err
+}
+
+// list consumes the records, printing what would be restored.
+func (f *filterExporter) list(records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+
+	ignored := make(map[string]struct{})
+	for record := range records {
+		if f.ignore(record, ignored) {
+			f.stats.Skipped++
+			continue
+		}
+
+		action := f.resolve(record)
+		if action == actionSkip {
+			f.stats.Skipped++
+		}
+
+		if !record.IsXattr && record.Err == nil && !record.FileInfo.Lmode.IsDir() {
+			fmt.Fprintf(f.out, "%-9s %8s %s\n", action,
+				humanize.IBytes(uint64(record.FileInfo.Size())),
+				utils.SanitizeText(path.Join("/", f.prefix, record.Pathname)))
+		}
+
+		if action != actionSkip {
+			res := record.Ok()
+			f.stats.account(res)
+			results <- res
+		}
+	}
+	return nil
+}
+
+func (f *filterExporter) ignore(record *connectors.Record, ignored map[string]struct{}) bool {
+	if f.excludes == nil || record.Pathname == "/" {
+		return false
+	}
+
+	pathname := path.Join(f.root, record.Pathname)
+	for dir := path.Dir(pathname); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if _, ok := ignored[dir]; ok {
+			return true
+		}
+	}
+
+	isDir := record.FileInfo.Lmode.IsDir()
+	if !f.excludes.IsExcluded(pathname, isDir) {
+		return false
+	}
+	if isDir {
+		ignored[pathname] = struct{}{}
+	}
+	return true
+}
+
+// resolve applies the conflict policy to record, renaming it if needed,
+// and returns the resulting action.  Directories are merged with the
+// existing ones.
+func (f *filterExporter) resolve(record *connectors.Record) restoreAction {
+	if f.destination == "" || (!f.dryRun && f.onConflict == conflictOverwrite) {
+		// no need to look at the destination
+		return actionWrite
+	}
+	if record.IsXattr || record.Err != nil || record.FileInfo.Lmode.IsDir() {
+		return actionCreate
+	}
+
+	target := filepath.Join(f.destination, filepath.FromSlash(record.Pathname))
+	existing, err := os.Lstat(target)
+	if err != nil {
+		return actionCreate
+	}
+
+	switch f.onConflict {
+	case conflictSkip:
+		return actionSkip
+	case conflictKeepNewer:
+		if !existing.ModTime().Before(record.FileInfo.ModTime()) {
+			return actionSkip
+		}
+	case conflictRename:
+		record.Pathname = renamed(target, record.Pathname)
+		record.FileInfo.Lname = path.Base(record.Pathname)
+		return actionRename
+	}
+	return actionOverwrite
+}
+
+// renamed returns the first name derived from pathname that is not in
+// use at the destination.
+func renamed(target, pathname string) string {
+	suffix := ".restored"
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target + suffix); os.IsNotExist(err) {
+			return pathname + suffix
+		}
+		suffix = fmt.Sprintf(".restored.%d", i)
+	}
+}
@@ -0,0 +1,161 @@
+package restore
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, policy := range []string{"overwrite", "skip", "keep-newer", "rename"} {
+		p, err := parseConflictPolicy(policy)
+		require.NoError(t, err)
+		require.Equal(t, conflictPolicy(policy), p)
+	}
+
+	_, err := parseConflictPolicy("merge")
+	require.ErrorContains(t, err, "invalid conflict policy")
+
+	_, _, ctx := generateSnapshot(t)
+	cmd := &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-on-conflict", "merge"}))
+}
+
+func TestRestoreIgnore(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("app"),
+		ptesting.NewMockDir("app/node_modules"),
+		ptesting.NewMockDir("app/node_modules/dep"),
+		ptesting.NewMockDir("app/src"),
+		ptesting.NewMockFile("app/node_modules/dep/index.js", 0644, "dep"),
+		ptesting.NewMockFile("app/src/main.js", 0644, "main"),
+		ptesting.NewMockFile("app/src/debug.log", 0644, "log"),
+		ptesting.NewMockFile("app/build.log", 0644, "log"),
+		ptesting.NewMockFile("app/README", 0644, "readme"),
+	})
+	defer snap.Close()
+
+	ignoreFile := filepath.Join(t.TempDir(), "ignore")
+	require.NoError(t, os.WriteFile(ignoreFile, []byte("# dependencies\nnode_modules/\n"), 0644))
+
+	dir := mkRestoreDir(t)
+	id := snap.Header.GetIndexID()
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-to", dir,
+		"-ignore", "*.log",
+		"-ignore", "/app/README",
+		"-ignore-file", ignoreFile,
+		hex.EncodeToString(id[:]) + ":/app",
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(filepath.Join(dir, "src", "main.js"))
+	require.NoError(t, err)
+	require.Equal(t, "main", string(data))
+
+	for _, excluded := range []string{"node_modules", "src/debug.log", "build.log", "README"} {
+		_, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(excluded)))
+		require.True(t, os.IsNotExist(err), excluded)
+	}
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-ignore-file", "/no/such/file", hex.EncodeToString(id[:])}))
+	status, err = cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+}
+
+func TestRestoreOnConflict(t *testing.T) {
+	restoreWith := func(t *testing.T, policy string, setup func(dir string)) string {
+		dummy := ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy")
+		dummy.ModTime = time.Now().Add(-time.Hour)
+
+		repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("subdir"),
+			dummy,
+			ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+		})
+		defer snap.Close()
+
+		dir := mkRestoreDir(t)
+		setup(dir)
+
+		id := snap.Header.GetIndexID()
+		cmd := &Restore{}
+		require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-on-conflict", policy, hex.EncodeToString(id[:]) + ":/subdir"}))
+		status, err := cmd.Execute(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		return dir
+	}
+
+	existing := func(mtime time.Time) func(string) {
+		return func(dir string) {
+			dest := filepath.Join(dir, "dummy.txt")
+			require.NoError(t, os.WriteFile(dest, []byte("existing"), 0644))
+			require.NoError(t, os.Chtimes(dest, mtime, mtime))
+		}
+	}
+
+	read := func(t *testing.T, dir, name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		dir := restoreWith(t, "overwrite", existing(time.Now()))
+		require.Equal(t, "hello dummy", read(t, dir, "dummy.txt"))
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		dir := restoreWith(t, "skip", existing(time.Now()))
+		require.Equal(t, "existing", read(t, dir, "dummy.txt"))
+		require.Equal(t, "hello foo", read(t, dir, "foo.txt"))
+	})
+
+	t.Run("keep-newer", func(t *testing.T) {
+		dir := restoreWith(t, "keep-newer", existing(time.Now()))
+		require.Equal(t, "existing", read(t, dir, "dummy.txt"))
+
+		dir = restoreWith(t, "keep-newer", existing(time.Now().Add(-2*time.Hour)))
+		require.Equal(t, "hello dummy", read(t, dir, "dummy.txt"))
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		dir := restoreWith(t, "rename", func(dir string) {
+			existing(time.Now())(dir)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "dummy.txt.restored"), []byte("taken"), 0644))
+		})
+		require.Equal(t, "existing", read(t, dir, "dummy.txt"))
+		require.Equal(t, "taken", read(t, dir, "dummy.txt.restored"))
+		require.Equal(t, "hello dummy", read(t, dir, "dummy.txt.restored.1"))
+		require.Equal(t, "hello foo", read(t, dir, "foo.txt"))
+	})
+}
+
+func TestRestoreOnConflictRequiresLocalDestination(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	ctx.ConfigDir = t.TempDir()
+	require.NoError(t, ctx.ReloadConfig())
+	ctx.Config.Destinations["remote"] = map[string]string{"location": "ptar://" + filepath.Join(t.TempDir(), "out.ptar")}
+
+	id := snap.Header.GetIndexID()
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", "@remote", "-on-conflict", "skip", hex.EncodeToString(id[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+}
@@ -21,14 +21,19 @@ import (
 	"fmt"
 	"maps"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/exclude"
 	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/location"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -43,11 +48,18 @@ type Restore struct {
 	OptJob             string
 	OptTag             string
 	OptSkipPermissions bool
+	OptSource          string
+	OptAt              time.Time
+	OptOnConflict      string
+	OptDryRun          bool
+	OptVerify          bool
 	Opts               map[string]string
 
-	Target    string
-	Strip     string
-	Snapshots []string
+	Target       string
+	Strip        string
+	Snapshots    []string
+	Excludes     []string
+	ExcludeFiles []string
 }
 
 func init() {
@@ -61,7 +73,8 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags := flag.NewFlagSet("restore", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH][=SUBDIR]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] -at TIME [PATH[=SUBDIR]]...\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
@@ -76,14 +89,33 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.StringVar(&pullPath, "to", "", "base directory where pull will restore")
 	flags.BoolVar(&cmd.OptSkipPermissions, "skip-permissions", false, "do not restore file permissions")
+	flags.StringVar(&cmd.OptSource, "source", "", "restore from the given source of a multi-source snapshot")
+	flags.Var(locate.NewTimeFlag(&cmd.OptAt), "at", "restore paths from the newest snapshot taken at or before the given time")
+	flags.Func("ignore", "gitignore pattern to exclude files from the restore, can be specified multiple times", func(pattern string) error {
+		cmd.Excludes = append(cmd.Excludes, pattern)
+		return nil
+	})
+	flags.Func("ignore-file", "path to a file containing newline-separated gitignore patterns, treated as -ignore; can be specified multiple times", func(filename string) error {
+		cmd.ExcludeFiles = append(cmd.ExcludeFiles, filename)
+		return nil
+	})
+	flags.StringVar(&cmd.OptOnConflict, "on-conflict", string(conflictOverwrite), "what to do with existing files: overwrite, skip, keep-newer or rename")
+	flags.BoolVar(&cmd.OptDryRun, "dry-run", false, "list the files that would be restored without writing anything")
+	flags.BoolVar(&cmd.OptVerify, "verify", false, "verify the digests of the restored files against the snapshot")
 	flags.Parse(args)
 
-	if flags.NArg() != 0 {
+	if cmd.OptDryRun && cmd.OptVerify {
+		return fmt.Errorf("-dry-run and -verify can't be used together")
+	}
+
+	if _, err := parseConflictPolicy(cmd.OptOnConflict); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 0 && cmd.OptAt.IsZero() {
 		if cmd.OptName != "" || cmd.OptCategory != "" || cmd.OptEnvironment != "" || cmd.OptPerimeter != "" || cmd.OptJob != "" || cmd.OptTag != "" {
 			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 		}
-	} else if flags.NArg() > 1 {
-		return fmt.Errorf("multiple restore paths specified, please specify only one")
 	}
 
 	if pullPath == "" {
@@ -97,56 +129,223 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	return nil
 }
 
+// restoreItem is a snapshot path to restore, optionally below a
+// sub-directory of the target.
+type restoreItem struct {
+	snapshotPath string
+	source       string
+	subtarget    string
+
+	// The snapshot path of the whole argument, if the '=' introducing
+	// the sub-directory may also belong to the path.
+	whole string
+}
+
+// parseRestoreItem splits a SNAPSHOT[:PATH][=SUBDIR] argument on its
+// last '='.  A PATH containing '=' is given with a trailing '=', otherwise
+// which of the two the argument means is only known once the snapshot is
+// looked at, see resolveSubtarget.
+func parseRestoreItem(arg string) (restoreItem, error) {
+	item := restoreItem{snapshotPath: arg}
+	if idx := strings.LastIndex(arg, "="); idx != -1 {
+		item.snapshotPath, item.subtarget = arg[:idx], arg[idx+1:]
+		if idx != len(arg)-1 {
+			item.whole = arg
+		}
+	}
+
+	subtarget, err := cleanSubtarget(item.subtarget)
+	if err != nil {
+		return restoreItem{}, fmt.Errorf("%s: %w", arg, err)
+	}
+	item.subtarget = subtarget
+	return item, nil
+}
+
+// resolveSubtarget settles what an argument containing '=' means: the
+// path it designates as a whole if the snapshot holds it, and not the
+// path before its last '=', which is otherwise restored below SUBDIR.
+// The snapshot holding both is an error rather than a guess.
+func (cmd *Restore) resolveSubtarget(repo *repository.Repository, arg string, item *restoreItem) error {
+	if item.whole == "" {
+		return nil
+	}
+
+	source := item.source
+	if source == "" {
+		source = cmd.OptSource
+	}
+	exists := func(snapshotPath string) (string, bool, error) {
+		snap, pathname, _, err := utils.OpenSnapshotSource(repo, snapshotPath, source)
+		if err != nil {
+			return "", false, err
+		}
+		defer snap.Close()
+
+		pvfs, err := snap.Filesystem()
+		if err != nil {
+			return "", false, err
+		}
+		_, err = pvfs.GetEntry(pathname)
+		return pathname, err == nil, nil
+	}
+
+	whole, found, err := exists(item.whole)
+	if err != nil || !found {
+		return err
+	}
+	pathname, found, err := exists(item.snapshotPath)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("%s: ambiguous, the snapshot holds both %s and %s: end the argument with '=' to restore %s",
+			arg, whole, pathname, whole)
+	}
+
+	item.snapshotPath, item.subtarget, item.whole = item.whole, "", ""
+	return nil
+}
+
+func (cmd *Restore) locateOptions() *locate.LocateOptions {
+	locateOptions := locate.NewDefaultLocateOptions()
+	locateOptions.Filters.Latest = true
+	locateOptions.Filters.Name = cmd.OptName
+	locateOptions.Filters.Category = cmd.OptCategory
+	locateOptions.Filters.Environment = cmd.OptEnvironment
+	locateOptions.Filters.Perimeter = cmd.OptPerimeter
+	locateOptions.Filters.Job = cmd.OptJob
+	locateOptions.Filters.Tags = []string{cmd.OptTag}
+	return locateOptions
+}
+
+// locateAt resolves the -at arguments to the newest snapshots taken at
+// or before the requested time.
+func (cmd *Restore) locateAt(ctx *appcontext.AppContext, repo *repository.Repository) ([]restoreItem, error) {
+	args := cmd.Snapshots
+	if len(args) == 0 {
+		args = []string{""}
+	}
+
+	var items []restoreItem
+	for _, arg := range args {
+		item, err := parseRestoreItem(arg)
+		if err != nil {
+			return nil, err
+		}
+		pathname, whole := item.snapshotPath, item.whole
+		if pathname != "" && !path.IsAbs(pathname) {
+			pathname = path.Join(ctx.CWD, pathname)
+		}
+		if whole != "" && !path.IsAbs(whole) {
+			whole = path.Join(ctx.CWD, whole)
+		}
+
+		snap, idx, err := utils.LocateAt(repo, cmd.locateOptions(), cmd.OptAt, pathname, cmd.OptSource)
+		if err != nil {
+			return nil, err
+		}
+		snapshotID := snap.Header.Identifier
+		ctx.GetLogger().Info("restore: using snapshot %x from %s for %s", snapshotID[:4],
+			snap.Header.Timestamp.UTC().Format(time.RFC3339), path.Join("/", pathname))
+		snap.Close()
+
+		item.snapshotPath = fmt.Sprintf("%x:%s", snapshotID, pathname)
+		item.source = strconv.Itoa(idx)
+		if whole != "" {
+			item.whole = fmt.Sprintf("%x:%s", snapshotID, whole)
+		}
+		if err := cmd.resolveSubtarget(repo, arg, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// restoreFilter holds what's needed to build the filterExporter of each
+// restored item.
+type restoreFilter struct {
+	excludes    *exclude.RuleSet
+	destination string
+	onConflict  conflictPolicy
+	dryRun      bool
+	verify      bool
+}
+
 func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
-	var snapshots []string
-	if len(cmd.Snapshots) == 0 {
-		locateOptions := locate.NewDefaultLocateOptions()
-		locateOptions.Filters.Latest = true
-
-		locateOptions.Filters.Name = cmd.OptName
-		locateOptions.Filters.Category = cmd.OptCategory
-		locateOptions.Filters.Environment = cmd.OptEnvironment
-		locateOptions.Filters.Perimeter = cmd.OptPerimeter
-		locateOptions.Filters.Job = cmd.OptJob
-		locateOptions.Filters.Tags = []string{cmd.OptTag}
+	onConflict, err := parseConflictPolicy(cmd.OptOnConflict)
+	if err != nil {
+		return 1, err
+	}
+
+	var excludes *exclude.RuleSet
+	if len(cmd.Excludes) != 0 || len(cmd.ExcludeFiles) != 0 {
+		excludes = exclude.NewRuleSet()
+		if err := excludes.AddRulesFromArray(cmd.Excludes); err != nil {
+			return 1, fmt.Errorf("failed to setup exclude rules: %w", err)
+		}
+		for _, filename := range cmd.ExcludeFiles {
+			if err := excludes.AddRulesFromFile(filename); err != nil {
+				return 1, fmt.Errorf("failed to setup exclude rules from %s: %w", filename, err)
+			}
+		}
+	}
+
+	var items []restoreItem
+	if !cmd.OptAt.IsZero() {
+		if items, err = cmd.locateAt(ctx, repo); err != nil {
+			return 1, err
+		}
+	} else if len(cmd.Snapshots) == 0 {
+		locateOptions := cmd.locateOptions()
+		utils.IgnoreCheckpoints(locateOptions)
 
 		snapshotIDs, err := locate.LocateSnapshotIDs(repo, locateOptions)
 		if err != nil {
 			return 1, fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 		}
-		for _, snapshotID := range snapshotIDs {
-			snapshots = append(snapshots, fmt.Sprintf("%x:", snapshotID))
+		if len(snapshotIDs) == 0 {
+			return 1, fmt.Errorf("no snapshots found")
+		} else if len(snapshotIDs) > 1 {
+			return 1, fmt.Errorf("multiple snapshots found, please specify one")
 		}
+		items = append(items, restoreItem{snapshotPath: fmt.Sprintf("%x:", snapshotIDs[0])})
 	} else {
-		for _, snapshotPath := range cmd.Snapshots {
-			prefix, path := locate.ParseSnapshotPath(snapshotPath)
-
-			locateOptions := locate.NewDefaultLocateOptions()
-			locateOptions.Filters.Latest = true
-			locateOptions.Filters.Name = cmd.OptName
-			locateOptions.Filters.Category = cmd.OptCategory
-			locateOptions.Filters.Environment = cmd.OptEnvironment
-			locateOptions.Filters.Perimeter = cmd.OptPerimeter
-			locateOptions.Filters.Job = cmd.OptJob
-			locateOptions.Filters.Tags = []string{cmd.OptTag}
+		for _, arg := range cmd.Snapshots {
+			item, err := parseRestoreItem(arg)
+			if err != nil {
+				return 1, err
+			}
+
+			prefix, pathname := locate.ParseSnapshotPath(item.snapshotPath)
+
+			locateOptions := cmd.locateOptions()
 			locateOptions.Filters.IDs = []string{prefix}
 
 			snapshotIDs, err := locate.LocateSnapshotIDs(repo, locateOptions)
 			if err != nil {
 				return 1, fmt.Errorf("ls: could not fetch snapshots list: %w", err)
 			}
-			for _, snapshotID := range snapshotIDs {
-				snapshots = append(snapshots, fmt.Sprintf("%x:%s", snapshotID, path))
+			if len(snapshotIDs) == 0 {
+				return 1, fmt.Errorf("%s: no snapshots found", arg)
+			} else if len(snapshotIDs) > 1 {
+				return 1, fmt.Errorf("%s: multiple snapshots found, please specify one", arg)
+			}
+			item.snapshotPath = fmt.Sprintf("%x:%s", snapshotIDs[0], pathname)
+			// the '=' may only belong to the path, not to the snapshot
+			if wholePrefix, whole := locate.ParseSnapshotPath(item.whole); wholePrefix != prefix {
+				item.whole = ""
+			} else if item.whole != "" {
+				item.whole = fmt.Sprintf("%x:%s", snapshotIDs[0], whole)
 			}
+			if err := cmd.resolveSubtarget(repo, arg, &item); err != nil {
+				return 1, err
+			}
+			items = append(items, item)
 		}
 	}
 
-	if len(snapshots) == 0 {
-		return 1, fmt.Errorf("no snapshots found")
-	} else if len(snapshots) > 1 {
-		return 1, fmt.Errorf("multiple snapshots found, please specify one")
-	}
-
 	exporterConfig := map[string]string{
 		"location": cmd.Target,
 	}
@@ -165,7 +364,6 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	maps.Copy(exporterConfig, cmd.Opts)
 
 	var exporterInstance exporter.Exporter
-	var err error
 	options := ctx.ExporterOpts()
 
 	exporterInstance, err = exporter.NewExporter(ctx.GetInner(), options, exporterConfig)
@@ -174,31 +372,133 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	}
 	defer exporterInstance.Close(ctx)
 
-	opts := &snapshot.ExportOptions{}
-	if cmd.OptSkipPermissions {
-		opts.SkipPermissions = true
+	filter := &restoreFilter{
+		excludes:   excludes,
+		onConflict: onConflict,
+		dryRun:     cmd.OptDryRun,
+		verify:     cmd.OptVerify,
+	}
+	if exporterInstance.Flags()&location.FLAG_LOCALFS != 0 {
+		filter.destination = exporterInstance.Root()
+	} else if onConflict != conflictOverwrite {
+		return 1, fmt.Errorf("-on-conflict %s is only supported when restoring to the local filesystem", onConflict)
+	} else if cmd.OptVerify {
+		return 1, fmt.Errorf("-verify is only supported when restoring to the local filesystem")
 	}
 
-	for _, snapPath := range snapshots {
-		snap, pathname, relative, err := locate.OpenSnapshotByPathRelative(repo, snapPath)
-		if err != nil {
+	// all the items are restored through a single export of the
+	// exporter, a dry run doesn't export anything
+	var multi *multiExporter
+	if !cmd.OptDryRun {
+		multi = newMultiExporter(ctx, exporterInstance, ctx.MaxConcurrency*2+1)
+	}
+
+	verb := "restored"
+	if cmd.OptDryRun {
+		verb = "would restore"
+	}
+
+	var total restoreStats
+	for _, item := range items {
+		var stats restoreStats
+		if err := cmd.restoreItem(ctx, repo, exporterInstance, multi, filter, item, &stats); err != nil {
+			if multi != nil {
+				multi.Wait()
+			}
 			return 1, err
 		}
+		total.add(&stats)
 
-		if relative != "" {
-			if !strings.HasSuffix(relative, "/") {
-				opts.Strip = path.Dir(pathname)
-			} else {
-				opts.Strip = pathname
-			}
+		if len(items) > 1 {
+			ctx.GetLogger().Info("restore: %s %s to %s: %s", verb,
+				item.snapshotPath, path.Join(cmd.Target, item.subtarget), stats.String())
 		}
+	}
 
-		err = snap.Export(exporterInstance, pathname, opts)
-		if err != nil {
+	if multi != nil {
+		if err := multi.Wait(); err != nil {
 			return 1, err
 		}
+	}
 
-		snap.Close()
+	ctx.GetLogger().Info("restore: %s %d items to %s: %s", verb, len(items), cmd.Target, total.String())
+
+	if cmd.OptVerify {
+		ctx.GetLogger().Info("restore: verified %d files, %d mismatches", total.Verified, total.Mismatches)
+		if total.Mismatches != 0 {
+			return exitcodes.IntegrityFailure, fmt.Errorf("verification failed for %d files", total.Mismatches)
+		}
 	}
 	return 0, nil
 }
+
+func (cmd *Restore) restoreItem(ctx *appcontext.AppContext, repo *repository.Repository, exp exporter.Exporter, multi *multiExporter, filter *restoreFilter, item restoreItem, stats *restoreStats) error {
+	source := item.source
+	if source == "" {
+		source = cmd.OptSource
+	}
+
+	snap, pathname, relative, err := utils.OpenSnapshotSource(repo, item.snapshotPath, source)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	opts := &snapshot.ExportOptions{}
+	if cmd.OptSkipPermissions {
+		opts.SkipPermissions = true
+	}
+	if relative != "" {
+		if !strings.HasSuffix(relative, "/") {
+			opts.Strip = path.Dir(pathname)
+		} else {
+			opts.Strip = pathname
+		}
+	}
+
+	if multi != nil {
+		exp = multi.Item(item.subtarget, stats)
+	}
+	if filter.excludes == nil && filter.onConflict == conflictOverwrite && !filter.dryRun && !filter.verify {
+		return snap.Export(exp, pathname, opts)
+	}
+
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+	entry, err := pvfs.GetEntry(pathname)
+	if err != nil {
+		return err
+	}
+
+	// the records are relative to the exported directory
+	root := entry.Path()
+	if !entry.IsDir() {
+		root = path.Dir(root)
+	}
+
+	filtered := &filterExporter{
+		Exporter:   exp,
+		root:       root,
+		excludes:   filter.excludes,
+		onConflict: filter.onConflict,
+		dryRun:     filter.dryRun,
+		out:        ctx.Stdout,
+		prefix:     item.subtarget,
+		verify:     filter.verify,
+		stats:      stats,
+	}
+	if filter.destination != "" {
+		filtered.destination = filepath.Join(filter.destination, filepath.FromSlash(item.subtarget))
+	}
+
+	if err := snap.Export(filtered, pathname, opts); err != nil {
+		return err
+	}
+
+	if filter.verify {
+		verifyFiles(ctx, repo, pvfs, filtered.written, stats)
+	}
+	return nil
+}
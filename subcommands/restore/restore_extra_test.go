@@ -1,14 +1,17 @@
 package restore
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,18 +23,86 @@ func mkRestoreDir(t *testing.T) string {
 	return dir
 }
 
-func TestRestoreParseRejectsMultiplePaths(t *testing.T) {
-	// Only one positional argument is allowed (besides flags).
+func TestRestoreParseAcceptsMultiplePaths(t *testing.T) {
+	// Every positional argument is an item to restore.
 	_, _, ctx := generateSnapshot(t)
 	cmd := &Restore{}
-	err := cmd.Parse(ctx, []string{"abc", "def", "ghi"})
-	// Parse currently does not actually reject multi-path on its own — the
-	// check is `flags.NArg() > 1` inside an `else if` whose preceding branch
-	// already consumed the case. Pin down the present behavior so we notice
-	// if this gets tightened: Parse succeeds, Snapshots has all positional
-	// args.
+	err := cmd.Parse(ctx, []string{"abc", "def:/etc", "ghi:/var=var"})
 	require.NoError(t, err)
-	require.Len(t, cmd.Snapshots, 3)
+	require.Equal(t, []string{"abc", "def:/etc", "ghi:/var=var"}, cmd.Snapshots)
+}
+
+func TestRestoreParseRestoreItem(t *testing.T) {
+	for arg, expected := range map[string][3]string{
+		"abc":                 {"abc", "", ""},
+		"abc:/etc":            {"abc:/etc", "", ""},
+		"abc:/etc=etc":        {"abc:/etc", "/etc", "abc:/etc=etc"},
+		"abc:/etc=/a/b/":      {"abc:/etc", "/a/b", "abc:/etc=/a/b/"},
+		"abc:/etc=":           {"abc:/etc", "", ""},
+		"abc:/etc=/":          {"abc:/etc", "", "abc:/etc=/"},
+		"abc:/a=b/c=./d//e/.": {"abc:/a=b/c", "/d/e", "abc:/a=b/c=./d//e/."},
+		"abc:/a=b=":           {"abc:/a=b", "", ""},
+	} {
+		item, err := parseRestoreItem(arg)
+		require.NoError(t, err, arg)
+		require.Equal(t, expected[0], item.snapshotPath, arg)
+		require.Equal(t, expected[1], item.subtarget, arg)
+		require.Equal(t, expected[2], item.whole, arg)
+	}
+
+	_, err := parseRestoreItem("abc:/etc=../outside")
+	require.ErrorContains(t, err, "must not contain ..")
+	_, err = parseRestoreItem("abc:/etc=a/../../b")
+	require.Error(t, err)
+}
+
+func TestRestorePathWithEqual(t *testing.T) {
+	// /srv/k=v is restored as it was before sub-directories could be
+	// given, and an argument the snapshot holds both meanings of fails.
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("srv"),
+		ptesting.NewMockFile("srv/k=v", 0644, "hello k=v"),
+		ptesting.NewMockDir("etc"),
+		ptesting.NewMockFile("etc/passwd", 0644, "hello passwd"),
+		ptesting.NewMockDir("etc=x"),
+	})
+	defer snap.Close()
+	id := snap.Header.GetIndexID()
+	root := snap.Header.GetSource(0).Importer.Directory
+
+	dir := mkRestoreDir(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir,
+		fmt.Sprintf("%x:%s/srv/k=v", id, root),
+		fmt.Sprintf("%x:%s/etc/passwd=sub", id, root),
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	for file, content := range map[string]string{
+		"k=v":        "hello k=v",
+		"sub/passwd": "hello passwd",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		require.NoError(t, err, file)
+		require.Equal(t, content, string(data), file)
+	}
+
+	arg := fmt.Sprintf("%x:%s/etc=x", id, root)
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", mkRestoreDir(t), arg}))
+	_, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, arg+": ambiguous")
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", mkRestoreDir(t), arg + "="}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
 }
 
 func TestRestoreParseDefaultTargetIncludesCWD(t *testing.T) {
@@ -165,30 +236,87 @@ func TestRestoreInvalidSnapshotID(t *testing.T) {
 	require.Equal(t, 1, status)
 }
 
-func TestRestoreMultipleSnapshotsFound(t *testing.T) {
-	// Two snapshots match the (default, latest-less) locate filter, so Execute
-	// must refuse with "multiple snapshots found".
-	repo, snap, ctx := generateSnapshot(t)
-	defer snap.Close()
-	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+func TestRestoreMultipleSnapshots(t *testing.T) {
+	// Several snapshot paths are restored in a single run, each below its
+	// own sub-directory of the target.
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
 		ptesting.NewMockDir("subdir"),
 		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+	})
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("another_subdir"),
+		ptesting.NewMockFile("another_subdir/bar.txt", 0644, "hello bar"),
 	})
 	defer snap2.Close()
 
-	id1 := snap.Header.GetIndexID()
+	id1 := snap1.Header.GetIndexID()
 	id2 := snap2.Header.GetIndexID()
 
+	dir := mkRestoreDir(t)
 	cmd := &Restore{}
 	require.NoError(t, cmd.Parse(ctx, []string{
-		"-to", mkRestoreDir(t),
-		hex.EncodeToString(id1[:]) + ":",
-		hex.EncodeToString(id2[:]) + ":",
+		"-to", dir,
+		hex.EncodeToString(id1[:]) + ":/subdir=first",
+		hex.EncodeToString(id2[:]) + ":/another_subdir/bar.txt=second/deeper/",
+		hex.EncodeToString(id1[:]) + ":/subdir/foo.txt",
 	}))
 	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	for file, content := range map[string]string{
+		"first/dummy.txt":       "hello dummy",
+		"first/foo.txt":         "hello foo",
+		"second/deeper/bar.txt": "hello bar",
+		"foo.txt":               "hello foo",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		require.NoError(t, err, file)
+		require.Equal(t, content, string(data), file)
+	}
+
+	output := bufOut.String()
+	require.Contains(t, output, fmt.Sprintf("restore: restored %x:/subdir to %s: 2 files", id1, filepath.Join(dir, "first")))
+	require.Contains(t, output, fmt.Sprintf("restore: restored %x:/another_subdir/bar.txt to %s: 1 files", id2, filepath.Join(dir, "second/deeper")))
+	require.Contains(t, output, fmt.Sprintf("restore: restored 3 items to %s: 4 files", dir))
+}
+
+func TestRestoreMultipleSnapshotsFailsEarly(t *testing.T) {
+	// An argument that doesn't resolve aborts before anything is written.
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	id := snap.Header.GetIndexID()
+	dir := mkRestoreDir(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-to", dir,
+		hex.EncodeToString(id[:]) + ":/subdir",
+		"deadbeefdeadbeef:/etc",
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, err.Error(), "deadbeefdeadbeef:/etc: no snapshots found")
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-to", dir,
+		hex.EncodeToString(id[:]) + ":/subdir=../escape",
+	}))
+	status, err = cmd.Execute(ctx, repo)
 	require.Error(t, err)
 	require.Equal(t, 1, status)
-	require.Contains(t, err.Error(), "multiple snapshots found")
+
+	rest, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, rest)
 }
 
 func TestRestoreToAliasWithLocation(t *testing.T) {
@@ -293,3 +421,93 @@ func TestRestoreToUnresolvableAlias(t *testing.T) {
 	require.Equal(t, 1, status)
 	require.Contains(t, err.Error(), "exporter")
 }
+
+func TestRestoreAt(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	var ids []string
+	for day, content := range []string{"first", "second", "third"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("data"),
+			ptesting.NewMockFile("data/file.txt", 0644, content),
+		}, ptesting.WithTimestamp(time.Date(2026, 10, 1+day, 12, 0, 0, 0, time.UTC)))
+		ids = append(ids, fmt.Sprintf("%x", snap.Header.Identifier[:4]))
+		snap.Close()
+	}
+
+	dir := mkRestoreDir(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-to", dir,
+		"-at", "2026-10-02T13:00:00Z",
+		"/data=at",
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(filepath.Join(dir, "at", "file.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "second", string(data))
+	require.Contains(t, bufOut.String(), fmt.Sprintf("restore: using snapshot %s from 2026-10-02T12:00:00Z for /data", ids[1]))
+
+	// exactly at the time of a snapshot selects it
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-to", dir,
+		"-at", "2026-10-03T12:00:00Z",
+		"/data/file.txt=exact",
+	}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err = os.ReadFile(filepath.Join(dir, "exact", "file.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "third", string(data))
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-at", "2026-09-01", "/data"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no snapshot of /data found at or before 2026-09-01T00:00:00Z")
+	require.Equal(t, 1, status)
+}
+
+func TestRestoreSkipsCheckpoints(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("file.txt", 0644, "complete"),
+	}, ptesting.WithTimestamp(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)))
+	snap.Close()
+	checkpoint := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("file.txt", 0644, "partial"),
+	}, ptesting.WithTimestamp(time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC)), ptesting.WithTags(utils.CheckpointTag))
+	checkpointID := fmt.Sprintf("%x", checkpoint.Header.Identifier[:4])
+	checkpoint.Close()
+
+	for name, args := range map[string][]string{
+		"latest":     nil,
+		"at":         {"-at", "2026-10-03", "/file.txt"},
+		"checkpoint": {checkpointID},
+	} {
+		dir := mkRestoreDir(t)
+		cmd := &Restore{}
+		require.NoError(t, cmd.Parse(ctx, append([]string{"-to", dir}, args...)), name)
+		status, err := cmd.Execute(ctx, repo)
+		require.NoError(t, err, name)
+		require.Equal(t, 0, status, name)
+
+		data, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+		require.NoError(t, err, name)
+		if name == "checkpoint" {
+			require.Equal(t, "partial", string(data))
+		} else {
+			require.Equal(t, "complete", string(data), name)
+		}
+	}
+}
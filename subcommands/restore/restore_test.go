@@ -106,3 +106,36 @@ func TestExecuteCmdRestoreSpecificSnapshot(t *testing.T) {
 
 	checkRestored(t, tmpToRestoreDir)
 }
+
+func TestExecuteCmdRestoreSource(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("first.txt", 0644, "first"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/second.txt", 0644, "second"),
+	}))
+	defer snap.Close()
+
+	tmpToRestoreDir, err := os.MkdirTemp("", "tmp_to_restore")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpToRestoreDir)
+	})
+
+	indexId := snap.Header.GetIndexID()
+	args := []string{"-to", tmpToRestoreDir, "-source", "1", hex.EncodeToString(indexId[:]) + ":subdir"}
+	subcommand := &Restore{}
+	require.NoError(t, subcommand.Parse(ctx, args))
+
+	status, err := subcommand.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	content, err := os.ReadFile(filepath.Join(tmpToRestoreDir, "second.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "second", string(content))
+
+	_, err = os.Stat(filepath.Join(tmpToRestoreDir, "first.txt"))
+	require.True(t, os.IsNotExist(err))
+}
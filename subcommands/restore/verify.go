@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+var errDigestMismatch = errors.New("digest mismatch")
+
+// verifyFiles compares the digests of the restored files to the ones
+// recorded in the snapshot.
+func verifyFiles(ctx *appcontext.AppContext, repo *repository.Repository, pvfs *vfs.Filesystem, files []writtenFile, stats *restoreStats) {
+	for _, file := range files {
+		if err := verifyFile(repo, pvfs, file); err != nil {
+			ctx.GetLogger().Error("restore: %s: %s", file.target, err)
+			stats.Mismatches++
+		} else {
+			stats.Verified++
+		}
+	}
+}
+
+func verifyFile(repo *repository.Repository, pvfs *vfs.Filesystem, file writtenFile) error {
+	entry, err := pvfs.GetEntry(file.pathname)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Open(file.target)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	hasher := repo.GetMACHasher()
+	size, err := io.Copy(hasher, fp)
+	if err != nil {
+		return err
+	}
+
+	if size != entry.Stat().Size() {
+		return fmt.Errorf("%w: size is %d, expected %d", errDigestMismatch, size, entry.Stat().Size())
+	}
+
+	// empty files have no object
+	if entry.ResolvedObject == nil {
+		return nil
+	}
+	if !bytes.Equal(hasher.Sum(nil), entry.ResolvedObject.ContentMAC[:]) {
+		return errDigestMismatch
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/plakar/exitcodes"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreDryRun(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+		ptesting.NewMockFile("subdir/new.txt", 0644, "hello new"),
+		ptesting.NewMockFile("subdir/debug.log", 0644, "log"),
+	})
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dummy.txt"), []byte("existing"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("existing"), 0644))
+
+	id := snap.Header.GetIndexID()
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-to", dir,
+		"-dry-run",
+		"-ignore", "*.log",
+		"-on-conflict", "skip",
+		hex.EncodeToString(id[:]) + ":/subdir",
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := bufOut.String()
+	require.Regexp(t, `(?m)^skip\s+11 B /dummy.txt$`, output)
+	require.Regexp(t, `(?m)^skip\s+9 B /foo.txt$`, output)
+	require.Regexp(t, `(?m)^create\s+9 B /new.txt$`, output)
+	require.NotContains(t, output, "debug.log")
+	require.Contains(t, output, "restore: would restore 1 items to "+dir+": 1 files")
+
+	// nothing was written
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	data, err := os.ReadFile(filepath.Join(dir, "dummy.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "existing", string(data))
+
+	// with the default policy the existing files would be overwritten
+	bufOut.Reset()
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-dry-run", hex.EncodeToString(id[:]) + ":/subdir=sub"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Regexp(t, `(?m)^create\s+11 B /sub/dummy.txt$`, bufOut.String())
+
+	bufOut.Reset()
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-dry-run", hex.EncodeToString(id[:]) + ":/subdir"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Regexp(t, `(?m)^overwrite\s+11 B /dummy.txt$`, bufOut.String())
+}
+
+func TestRestoreDryRunAndVerifyExclusive(t *testing.T) {
+	_, _, ctx := generateSnapshot(t)
+	cmd := &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-dry-run", "-verify"}))
+}
+
+func TestRestoreVerify(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/empty", 0644, ""),
+		ptesting.NewMockFile("subdir/large", 0644, strings.Repeat("plakar", 100000)),
+	})
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	id := snap.Header.GetIndexID()
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-verify", hex.EncodeToString(id[:]) + ":/subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "restore: verified 3 files, 0 mismatches")
+}
+
+func TestRestoreVerifySubtarget(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+	})
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	id := snap.Header.GetIndexID()
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-verify", hex.EncodeToString(id[:]) + ":/subdir=a/b"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "restore: verified 2 files, 0 mismatches")
+
+	data, err := os.ReadFile(filepath.Join(dir, "a", "b", "foo.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello foo", string(data))
+}
+
+func TestVerifyFileMismatch(t *testing.T) {
+	repo, snap, _ := generateSnapshot(t)
+	defer snap.Close()
+
+	pvfs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	target := filepath.Join(t.TempDir(), "dummy.txt")
+
+	require.NoError(t, os.WriteFile(target, []byte("hello dummy"), 0644))
+	require.NoError(t, verifyFile(repo, pvfs, writtenFile{target: target, pathname: "/subdir/dummy.txt"}))
+
+	require.NoError(t, os.WriteFile(target, []byte("hello DUMMY"), 0644))
+	require.ErrorIs(t, verifyFile(repo, pvfs, writtenFile{target: target, pathname: "/subdir/dummy.txt"}), errDigestMismatch)
+
+	require.NoError(t, os.WriteFile(target, []byte("hello"), 0644))
+	require.ErrorIs(t, verifyFile(repo, pvfs, writtenFile{target: target, pathname: "/subdir/dummy.txt"}), errDigestMismatch)
+
+	require.NoError(t, os.Remove(target))
+	require.Error(t, verifyFile(repo, pvfs, writtenFile{target: target, pathname: "/subdir/dummy.txt"}))
+}
+
+// tamperingExporter writes garbage instead of the content of the files
+// to the local directory it is given.
+type tamperingExporter struct {
+	exporter.Exporter
+	root string
+}
+
+func init() {
+	exporter.Register("tampering", location.FLAG_LOCALFS, func(ctx context.Context, opts *connectors.Options, name string, config map[string]string) (exporter.Exporter, error) {
+		return &tamperingExporter{root: strings.TrimPrefix(config["location"], "tampering://")}, nil
+	})
+}
+
+func (e *tamperingExporter) Root() string                    { return e.root }
+func (e *tamperingExporter) Flags() location.Flags           { return location.FLAG_LOCALFS }
+func (e *tamperingExporter) Close(ctx context.Context) error { return nil }
+
+func (e *tamperingExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+	for record := range records {
+		pathname := filepath.Join(e.root, filepath.FromSlash(record.Pathname))
+		var err error
+		if record.FileInfo.Lmode.IsDir() {
+			err = os.MkdirAll(pathname, 0755)
+		} else if record.FileInfo.Lmode.IsRegular() {
+			err = os.WriteFile(pathname, []byte("tampered"), 0644)
+		}
+		if err != nil {
+			results <- record.Error(err)
+		} else {
+			results <- record.Ok()
+		}
+	}
+	return nil
+}
+
+func TestRestoreVerifyFailure(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "hello foo"),
+	})
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	id := snap.Header.GetIndexID()
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", "tampering://" + dir, "-verify", hex.EncodeToString(id[:]) + ":/subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "verification failed for 2 files")
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+	require.Contains(t, bufErr.String(), filepath.Join(dir, "dummy.txt")+": digest mismatch")
+}
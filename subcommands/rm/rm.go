@@ -20,6 +20,7 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
 	"sort"
 	"strings"
 	"sync"
@@ -47,10 +48,10 @@ func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &Rm{} }, 0, "rm")
 }
 
-func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
+// parseFlags parses and validates the arguments, without acting on them.
+func (cmd *Rm) parseFlags(flags *flag.FlagSet, args []string) error {
 	cmd.LocateOptions = locate.NewDefaultLocateOptions()
 
-	flags := flag.NewFlagSet("rm", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT...\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
@@ -58,13 +59,29 @@ func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 	flags.BoolVar(&cmd.Apply, "apply", false, "do the actual removal")
 	cmd.LocateOptions.InstallDeletionFlags(flags)
-	flags.Parse(args)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
 
 	if flags.NArg() == 0 && cmd.LocateOptions.Empty() {
 		return fmt.Errorf("no filter specified, not going to remove everything")
 	}
 
 	cmd.LocateOptions.Filters.IDs = flags.Args()
+	return nil
+}
+
+// CheckArgs validates the arguments of a removal without running it.
+func (cmd *Rm) CheckArgs(args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return cmd.parseFlags(flags, args)
+}
+
+func (cmd *Rm) Parse(ctx *appcontext.AppContext, args []string) error {
+	if err := cmd.parseFlags(flag.NewFlagSet("rm", flag.ContinueOnError), args); err != nil {
+		return err
+	}
 
 	cmd.RepositorySecret = ctx.GetSecret()
 
@@ -82,6 +99,24 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		return 0, nil
 	}
 
+	// held snapshots are never removed
+	now := time.Now()
+	held := 0
+	removable := make([]objects.MAC, 0, len(matches))
+	for _, id := range matches {
+		hold, err := utils.LoadHold(repo, id, now)
+		if err != nil {
+			return 1, err
+		}
+		if hold != nil {
+			ctx.GetLogger().Warn("rm: not removing %x, held: %s", id[:4], hold)
+			held++
+			continue
+		}
+		removable = append(removable, id)
+	}
+	matches = removable
+
 	// plan
 	if !cmd.Apply {
 		type planEntry struct {
@@ -162,6 +197,9 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 	if errors != 0 {
 		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
 	}
+	if held != 0 {
+		return 1, fmt.Errorf("%d held snapshots were not removed", held)
+	}
 
 	return 0, nil
 }
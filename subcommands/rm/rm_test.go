@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
@@ -152,3 +154,44 @@ func TestRm_DryRun_ShowsPlan(t *testing.T) {
 	require.Contains(t, out, "rm: would remove these 1 snapshot(s), run with -apply to proceed")
 	require.NotContains(t, out, "rm: removal of") // no actual deletion
 }
+
+func TestRmHeld(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	held := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "a"),
+	}, ptesting.WithHold("case 42", time.Time{}))
+	defer held.Close()
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("b.txt", 0644, "b"),
+	})
+	defer other.Close()
+	args := []string{
+		hex.EncodeToString(held.Header.Identifier[:]),
+		hex.EncodeToString(other.Header.Identifier[:]),
+	}
+
+	cmd := &Rm{}
+	require.NoError(t, cmd.Parse(ctx, args))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "rm: would remove these 1 snapshot(s)")
+	require.Contains(t, bufOut.String()+bufErr.String(),
+		fmt.Sprintf("rm: not removing %x, held: case 42", held.Header.Identifier[:4]))
+
+	cmd = &Rm{}
+	require.NoError(t, cmd.Parse(ctx, append([]string{"-apply"}, args...)))
+	status, err = cmd.Execute(ctx, repo)
+	require.EqualError(t, err, "1 held snapshots were not removed")
+	require.Equal(t, 1, status)
+
+	require.NoError(t, repo.RebuildState())
+	var remaining []objects.MAC
+	for id, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		remaining = append(remaining, id)
+	}
+	require.Equal(t, []objects.MAC{held.Header.Identifier}, remaining)
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type RequestPkt struct {
+	Command string
+}
+
+type ResponsePkt struct {
+	Err   string
+	Tasks []TaskStatus
+}
+
+func request(ctx *appcontext.AppContext, command string, ignoreVersion bool) (*ResponsePkt, error) {
+	conn, err := net.Dial("unix", socketPath(ctx))
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer conn.Close()
+
+	enc := msgpack.NewEncoder(conn)
+	dec := msgpack.NewDecoder(conn)
+
+	ourvers := []byte(utils.GetVersion())
+	if err := enc.Encode(ourvers); err != nil {
+		return nil, err
+	}
+
+	var schedvers []byte
+	if err := dec.Decode(&schedvers); err != nil {
+		return nil, err
+	}
+
+	if !ignoreVersion && !slices.Equal(ourvers, schedvers) {
+		return nil, fmt.Errorf("scheduler is running with a different version of plakar (%v)", string(schedvers))
+	}
+
+	if err := enc.Encode(&RequestPkt{Command: command}); err != nil {
+		return nil, err
+	}
+
+	response := &ResponsePkt{}
+	if err := dec.Decode(response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if response.Err != "" {
+		return nil, fmt.Errorf("%s", response.Err)
+	}
+	return response, nil
+}
+
+type SchedulerStatus struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *SchedulerStatus) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler status", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: plakar %s\n", flags.Name())
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+func (cmd *SchedulerStatus) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	response, err := request(ctx, "status", false)
+	if err != nil {
+		return 1, err
+	}
+
+	for _, t := range response.Tasks {
+		state := "idle"
+		if t.Running {
+			state = "running"
+			if t.Queued {
+				state = "running, one run queued"
+			}
+		}
+
+		store := t.Store
+		if store == "" {
+			store = "default store"
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%s: %s on %s, %s (%s)\n", t.Name, t.Command, store, t.Schedule, state)
+		if !t.LastStart.IsZero() {
+			result := "ok"
+			if t.LastError != "" {
+				result = "failed: " + t.LastError
+			} else if t.LastStatus != 0 {
+				result = fmt.Sprintf("exited with status %d", t.LastStatus)
+			}
+			if t.Running {
+				fmt.Fprintf(ctx.Stdout, "  last run: %s\n", t.LastStart.UTC().Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(ctx.Stdout, "  last run: %s (%s) %s\n", t.LastStart.UTC().Format(time.RFC3339),
+					t.LastDuration.Round(time.Second), result)
+			}
+		}
+		if !t.NextRun.IsZero() {
+			fmt.Fprintf(ctx.Stdout, "  next run: %s\n", t.NextRun.UTC().Format(time.RFC3339))
+		}
+		fmt.Fprintf(ctx.Stdout, "  runs: %d, skipped: %d\n", t.Runs, t.Skipped)
+	}
+	return 0, nil
+}
+
+type SchedulerStop struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *SchedulerStop) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler stop", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: plakar %s\n", flags.Name())
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+	return nil
+}
+
+func (cmd *SchedulerStop) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	// Stopping must work even across upgrades, do not enforce the
+	// version check.
+	if _, err := request(ctx, "stop", true); err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
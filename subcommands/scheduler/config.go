@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/plakar/subcommands"
+	"go.yaml.in/yaml/v3"
+)
+
+const CONFIG_FILENAME = "scheduler.yml"
+
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+)
+
+var supportedCommands = []string{"backup", "check", "sync", "rm", "maintenance"}
+
+type Config struct {
+	Version string       `yaml:"version"`
+	Tasks   []TaskConfig `yaml:"tasks"`
+}
+
+type TaskConfig struct {
+	Name     string   `yaml:"name"`
+	Command  string   `yaml:"command"`
+	Store    string   `yaml:"store,omitempty"`
+	Args     []string `yaml:"args,omitempty"`
+	Interval string   `yaml:"interval,omitempty"`
+	Cron     string   `yaml:"cron,omitempty"`
+	Overlap  string   `yaml:"overlap,omitempty"`
+}
+
+func (tc *TaskConfig) Schedule() (Schedule, error) {
+	if tc.Interval != "" && tc.Cron != "" {
+		return nil, fmt.Errorf("interval and cron are mutually exclusive")
+	}
+
+	if tc.Interval != "" {
+		every, err := time.ParseDuration(tc.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+		if every < time.Minute {
+			return nil, fmt.Errorf("interval must be at least one minute")
+		}
+		return &intervalSchedule{every: every}, nil
+	}
+
+	if tc.Cron != "" {
+		return parseCron(tc.Cron)
+	}
+
+	return nil, fmt.Errorf("either interval or cron must be set")
+}
+
+func (tc *TaskConfig) validate() error {
+	if tc.Name == "" {
+		return fmt.Errorf("missing task name")
+	}
+	if !slices.Contains(supportedCommands, tc.Command) {
+		return fmt.Errorf("task %q: unsupported command %q", tc.Name, tc.Command)
+	}
+
+	switch tc.Overlap {
+	case "":
+		tc.Overlap = OverlapSkip
+	case OverlapSkip, OverlapQueue:
+	default:
+		return fmt.Errorf("task %q: invalid overlap policy %q", tc.Name, tc.Overlap)
+	}
+
+	if _, err := tc.Schedule(); err != nil {
+		return fmt.Errorf("task %q: %w", tc.Name, err)
+	}
+
+	cmd, _, args := subcommands.Lookup(append([]string{tc.Command}, tc.Args...))
+	if checker, ok := cmd.(subcommands.ArgsChecker); ok {
+		if err := checker.CheckArgs(args); err != nil {
+			return fmt.Errorf("task %q: invalid arguments: %w", tc.Name, err)
+		}
+	}
+	return nil
+}
+
+func ParseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	for i := range cfg.Tasks {
+		if err := cfg.Tasks[i].validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := seen[cfg.Tasks[i].Name]; ok {
+			return nil, fmt.Errorf("duplicate task %q", cfg.Tasks[i].Name)
+		}
+		seen[cfg.Tasks[i].Name] = struct{}{}
+	}
+
+	return &cfg, nil
+}
+
+func LoadConfig(configDir string) (*Config, error) {
+	path := filepath.Join(configDir, CONFIG_FILENAME)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerStatus{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "status")
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerStop{} },
+		subcommands.BeforeRepositoryOpen, "scheduler", "stop")
+	subcommands.Register(func() subcommands.Subcommand { return &Scheduler{} },
+		subcommands.BeforeRepositoryOpen, "scheduler")
+}
+
+var ErrNotRunning = errors.New("scheduler is not running")
+
+func socketPath(ctx *appcontext.AppContext) string {
+	return filepath.Join(ctx.CacheDir, "scheduler.sock")
+}
+
+type Scheduler struct {
+	subcommands.SubcommandBase
+
+	socketPath string
+	listener   net.Listener
+
+	config *Config
+	jobs   []*job
+
+	// runner executes a single occurrence of a task, it is only
+	// replaced by the tests.
+	runner func(ctx *appcontext.AppContext, tc *TaskConfig) (int, error)
+}
+
+func (cmd *Scheduler) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_foreground bool
+	var opt_logfile string
+	var opt_config string
+
+	flags := flag.NewFlagSet("scheduler", flag.ExitOnError)
+	flags.StringVar(&opt_logfile, "log", "", "log file")
+	flags.StringVar(&opt_config, "config", filepath.Join(ctx.ConfigDir, CONFIG_FILENAME), "scheduler configuration file")
+	flags.BoolVar(&opt_foreground, "foreground", false, "run in foreground")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s status\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s stop\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("too many arguments")
+	}
+
+	// Load the configuration before detaching so that errors are
+	// reported to the user rather than lost in syslog.
+	data, err := os.ReadFile(opt_config)
+	if err != nil {
+		return err
+	}
+	cmd.config, err = ParseConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", opt_config, err)
+	}
+	if len(cmd.config.Tasks) == 0 {
+		return fmt.Errorf("%s: no task configured", opt_config)
+	}
+
+	if !opt_foreground && os.Getenv("REEXEC") == "" {
+		return daemonize(os.Args)
+	}
+
+	if opt_logfile != "" {
+		f, err := os.OpenFile(opt_logfile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		ctx.GetLogger().SetOutput(f)
+	} else if !opt_foreground {
+		if err := setupSyslog(ctx); err != nil {
+			ctx.GetLogger().Error("failed to setup syslog: %s", err)
+			ctx.GetLogger().Error("will discard all future logs")
+			ctx.GetLogger().SetOutput(io.Discard)
+		}
+	}
+
+	cmd.socketPath = socketPath(ctx)
+	cmd.runner = runTask
+
+	return nil
+}
+
+func (cmd *Scheduler) Close() error {
+	if cmd.listener != nil {
+		cmd.listener.Close()
+	}
+	if err := os.Remove(cmd.socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (cmd *Scheduler) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	// Since we are detaching, we lose all stack traces, with no possibility
+	// to recover them, try to log them to a known location.
+	crashLog := filepath.Join(ctx.GetInner().CacheDir, "crash-scheduler.log")
+	f, err := os.OpenFile(crashLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return 1, err
+	}
+
+	debug.SetCrashOutput(f, debug.CrashOptions{})
+
+	// Safe to ignore here.
+	f.Close()
+
+	if err := cmd.ListenAndServe(ctx); err != nil {
+		return 1, err
+	}
+
+	ctx.GetLogger().Info("scheduler gracefully stopped")
+	return 0, nil
+}
+
+func (cmd *Scheduler) ListenAndServe(ctx *appcontext.AppContext) error {
+	lock, err := cached.LockedFile(cmd.socketPath + ".scheduler-lock")
+	if err != nil {
+		return fmt.Errorf("failed to obtain lock")
+	}
+	conn, err := net.Dial("unix", cmd.socketPath)
+	if err == nil {
+		lock.Unlock()
+		conn.Close()
+		return fmt.Errorf("scheduler already running")
+	}
+	os.Remove(cmd.socketPath)
+
+	listener, err := net.Listen("unix", cmd.socketPath)
+	lock.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to bind the socket: %w", err)
+	}
+	cmd.listener = listener
+	defer cmd.Close()
+
+	var wg sync.WaitGroup
+	cmd.jobs = cmd.jobs[:0]
+	for i := range cmd.config.Tasks {
+		t, err := newJob(cmd.config.Tasks[i])
+		if err != nil {
+			return err
+		}
+		cmd.jobs = append(cmd.jobs, t)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd.loop(ctx, t, &wg)
+		}()
+	}
+
+	ctx.GetLogger().Info("scheduler started with %d task(s)", len(cmd.jobs))
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			if opErr, ok := err.(*net.OpError); ok && opErr.Err.Error() == "use of closed network connection" {
+				break
+			}
+			ctx.Cancel(err)
+			wg.Wait()
+			return err
+		}
+
+		go cmd.handleClient(ctx, conn)
+	}
+
+	// Running tasks share our context and were cancelled along with
+	// it, wait for them to wind down before exiting.
+	wg.Wait()
+	return nil
+}
+
+func (cmd *Scheduler) handleClient(ctx *appcontext.AppContext, conn net.Conn) {
+	defer conn.Close()
+
+	encoder := msgpack.NewEncoder(conn)
+	decoder := msgpack.NewDecoder(conn)
+
+	// handshake
+	var (
+		clientvers []byte
+		ourvers    = []byte(utils.GetVersion())
+	)
+	if err := decoder.Decode(&clientvers); err != nil {
+		return
+	}
+	if err := encoder.Encode(ourvers); err != nil {
+		return
+	}
+
+	pkt := &RequestPkt{}
+	if err := decoder.Decode(pkt); err != nil {
+		ctx.GetLogger().Warn("failed to decode request: %v", err)
+		return
+	}
+
+	response := &ResponsePkt{}
+	switch pkt.Command {
+	case "status":
+		response.Tasks = cmd.status()
+	case "stop":
+		ctx.GetLogger().Info("stop requested")
+	default:
+		response.Err = fmt.Sprintf("unknown command %q", pkt.Command)
+	}
+
+	if err := encoder.Encode(response); err != nil {
+		ctx.GetLogger().Warn("client write error: %v", err)
+	}
+
+	if pkt.Command == "stop" {
+		ctx.Cancel(fmt.Errorf("scheduler stopped"))
+	}
+}
+
+func (cmd *Scheduler) status() []TaskStatus {
+	ret := make([]TaskStatus, 0, len(cmd.jobs))
+	for _, t := range cmd.jobs {
+		ret = append(ret, t.status())
+	}
+	return ret
+}
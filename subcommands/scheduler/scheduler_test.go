@@ -0,0 +1,383 @@
+package scheduler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/PlakarKorp/plakar/subcommands/backup"
+	_ "github.com/PlakarKorp/plakar/subcommands/check"
+	_ "github.com/PlakarKorp/plakar/subcommands/maintenance"
+	_ "github.com/PlakarKorp/plakar/subcommands/rm"
+	_ "github.com/PlakarKorp/plakar/subcommands/sync"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func newSchedulerCtx(t *testing.T) *appcontext.AppContext {
+	t.Helper()
+	ctx := appcontext.NewAppContext()
+	ctx.Stdout = bytes.NewBuffer(nil)
+	ctx.Stderr = bytes.NewBuffer(nil)
+	// Use a short temp path: macOS sun_path is limited to 104 bytes.
+	dir, err := os.MkdirTemp("", "sch")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ctx.CacheDir = dir
+	ctx.ConfigDir = dir
+	ctx.SetLogger(logging.NewLogger(ctx.Stdout, ctx.Stderr))
+	return ctx
+}
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339, s)
+	require.NoError(t, err)
+	return ts
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"0 3 * * *", "2025-06-01T02:59:00Z", "2025-06-01T03:00:00Z"},
+		{"0 3 * * *", "2025-06-01T03:00:00Z", "2025-06-02T03:00:00Z"},
+		{"*/15 * * * *", "2025-06-01T10:07:30Z", "2025-06-01T10:15:00Z"},
+		{"30 2 * * 1-5", "2025-06-07T00:00:00Z", "2025-06-09T02:30:00Z"}, // saturday -> monday
+		{"0 0 1 1 *", "2025-06-01T00:00:00Z", "2026-01-01T00:00:00Z"},
+		{"@hourly", "2025-06-01T10:00:00Z", "2025-06-01T11:00:00Z"},
+		{"0 12 * * 7", "2025-06-02T00:00:00Z", "2025-06-08T12:00:00Z"}, // 7 is sunday
+		{"0 0 13 * 5", "2025-06-01T00:00:00Z", "2025-06-06T00:00:00Z"}, // friday or the 13th
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		require.NoError(t, err, tt.spec)
+		require.Equal(t, mustTime(t, tt.want), s.Next(mustTime(t, tt.from)), tt.spec)
+	}
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	s, err := parseCron("0 0 30 2 *")
+	require.NoError(t, err)
+	require.True(t, s.Next(mustTime(t, "2025-01-01T00:00:00Z")).IsZero())
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		_, err := parseCron(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+version: v1.0.0
+tasks:
+  - name: nightly
+    command: backup
+    store: "@home"
+    args: ["-tag", "nightly", "/home"]
+    cron: "0 3 * * *"
+  - name: verify
+    command: check
+    interval: 6h
+    overlap: queue
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Tasks, 2)
+
+	require.Equal(t, "nightly", cfg.Tasks[0].Name)
+	require.Equal(t, []string{"-tag", "nightly", "/home"}, cfg.Tasks[0].Args)
+	require.Equal(t, OverlapSkip, cfg.Tasks[0].Overlap)
+
+	require.Equal(t, OverlapQueue, cfg.Tasks[1].Overlap)
+	s, err := cfg.Tasks[1].Schedule()
+	require.NoError(t, err)
+	require.Equal(t, "every 6h0m0s", s.String())
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"missing name": "tasks:\n  - command: backup\n    interval: 1h\n",
+		"bad command":  "tasks:\n  - name: a\n    command: restore\n    interval: 1h\n",
+		"no schedule":  "tasks:\n  - name: a\n    command: backup\n",
+		"both":         "tasks:\n  - name: a\n    command: backup\n    interval: 1h\n    cron: '@daily'\n",
+		"short":        "tasks:\n  - name: a\n    command: backup\n    interval: 10s\n",
+		"bad overlap":  "tasks:\n  - name: a\n    command: backup\n    interval: 1h\n    overlap: kill\n",
+		"duplicate":    "tasks:\n  - name: a\n    command: backup\n    interval: 1h\n  - name: a\n    command: check\n    interval: 1h\n",
+		"bad cron":     "tasks:\n  - name: a\n    command: backup\n    cron: '0 3 * *'\n",
+		"invalid yaml": "tasks: [",
+		"bad interval": "tasks:\n  - name: a\n    command: backup\n    interval: often\n",
+	}
+
+	for name, data := range tests {
+		_, err := ParseConfig([]byte(data))
+		require.Error(t, err, name)
+	}
+}
+
+func TestParseConfigInvalidArgs(t *testing.T) {
+	tests := map[string]string{
+		"unknown flag":   "tasks:\n  - name: a\n    command: backup\n    args: ['-nope']\n    interval: 1h\n",
+		"help":           "tasks:\n  - name: a\n    command: check\n    args: ['-h']\n    interval: 1h\n",
+		"bad value":      "tasks:\n  - name: a\n    command: backup\n    args: ['-retry', 'x']\n    interval: 1h\n",
+		"bad combo":      "tasks:\n  - name: a\n    command: backup\n    args: ['-json']\n    interval: 1h\n",
+		"no direction":   "tasks:\n  - name: a\n    command: sync\n    args: ['@peer']\n    interval: 1h\n",
+		"mirror only":    "tasks:\n  - name: a\n    command: sync\n    args: ['-max-delete', '5', 'to', '@peer']\n    interval: 1h\n",
+		"remove all":     "tasks:\n  - name: a\n    command: rm\n    args: ['-apply']\n    interval: 1h\n",
+		"maintenance -x": "tasks:\n  - name: a\n    command: maintenance\n    args: ['-x']\n    interval: 1h\n",
+	}
+
+	for name, data := range tests {
+		_, err := ParseConfig([]byte(data))
+		require.ErrorContains(t, err, `task "a": invalid arguments`, name)
+	}
+
+	_, err := ParseConfig([]byte("tasks:\n  - name: a\n    command: sync\n    args: ['-min-age', '7d', 'mirror', '@peer']\n    interval: 1h\n" +
+		"  - name: b\n    command: rm\n    args: ['-apply', '-name', 'tmp']\n    interval: 1h\n"))
+	require.NoError(t, err)
+}
+
+func TestTaskParseReturnsErrors(t *testing.T) {
+	// the tasks are parsed within the scheduler, a bad flag must not exit
+	for _, command := range supportedCommands {
+		for _, arg := range []string{"-nope", "-h"} {
+			cmd, _, args := subcommands.Lookup([]string{command, arg})
+			require.NotNil(t, cmd, command)
+			require.Error(t, cmd.Parse(newSchedulerCtx(t), args), command)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	_, err := LoadConfig(dir)
+	require.Error(t, err)
+
+	data := "tasks:\n  - name: a\n    command: maintenance\n    cron: '@weekly'\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, CONFIG_FILENAME), []byte(data), 0600))
+
+	cfg, err := LoadConfig(dir)
+	require.NoError(t, err)
+	require.Len(t, cfg.Tasks, 1)
+}
+
+func TestSchedulerParseNoConfig(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	cmd := &Scheduler{}
+	require.Error(t, cmd.Parse(ctx, []string{"-foreground"}))
+}
+
+func TestSchedulerParseEmptyConfig(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	require.NoError(t, os.WriteFile(filepath.Join(ctx.ConfigDir, CONFIG_FILENAME), []byte("version: v1.0.0\n"), 0600))
+	cmd := &Scheduler{}
+	err := cmd.Parse(ctx, []string{"-foreground"})
+	require.ErrorContains(t, err, "no task configured")
+}
+
+func TestSchedulerParse(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	data := "tasks:\n  - name: a\n    command: check\n    interval: 1h\n"
+	require.NoError(t, os.WriteFile(filepath.Join(ctx.ConfigDir, CONFIG_FILENAME), []byte(data), 0600))
+
+	cmd := &Scheduler{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-foreground"}))
+	require.Equal(t, filepath.Join(ctx.CacheDir, "scheduler.sock"), cmd.socketPath)
+	require.NotNil(t, cmd.runner)
+	require.Len(t, cmd.config.Tasks, 1)
+}
+
+// blockingRunner lets the test decide when a run completes.
+type blockingRunner struct {
+	mu      sync.Mutex
+	calls   int
+	release chan struct{}
+	started chan struct{}
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{
+		release: make(chan struct{}),
+		started: make(chan struct{}, 16),
+	}
+}
+
+func (r *blockingRunner) run(ctx *appcontext.AppContext, tc *TaskConfig) (int, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+	r.started <- struct{}{}
+	<-r.release
+	return 0, nil
+}
+
+func (r *blockingRunner) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func newTestJob(t *testing.T, overlap string) *job {
+	j, err := newJob(TaskConfig{Name: "t", Command: "check", Interval: "1h", Overlap: overlap})
+	require.NoError(t, err)
+	return j
+}
+
+func TestTriggerSkipsOverlappingRuns(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	runner := newBlockingRunner()
+	cmd := &Scheduler{runner: runner.run}
+	j := newTestJob(t, OverlapSkip)
+
+	var wg sync.WaitGroup
+	cmd.trigger(ctx, j, &wg)
+	<-runner.started
+
+	cmd.trigger(ctx, j, &wg)
+	cmd.trigger(ctx, j, &wg)
+
+	st := j.status()
+	require.True(t, st.Running)
+	require.False(t, st.Queued)
+	require.Equal(t, 2, st.Skipped)
+
+	close(runner.release)
+	wg.Wait()
+
+	st = j.status()
+	require.False(t, st.Running)
+	require.Equal(t, 1, st.Runs)
+	require.Equal(t, 1, runner.count())
+}
+
+func TestTriggerQueuesOverlappingRuns(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	runner := newBlockingRunner()
+	cmd := &Scheduler{runner: runner.run}
+	j := newTestJob(t, OverlapQueue)
+
+	var wg sync.WaitGroup
+	cmd.trigger(ctx, j, &wg)
+	<-runner.started
+
+	// several activations while running collapse into one queued run
+	cmd.trigger(ctx, j, &wg)
+	cmd.trigger(ctx, j, &wg)
+	require.True(t, j.status().Queued)
+
+	close(runner.release)
+	wg.Wait()
+
+	st := j.status()
+	require.False(t, st.Running)
+	require.False(t, st.Queued)
+	require.Equal(t, 0, st.Skipped)
+	require.Equal(t, 2, st.Runs)
+	require.Equal(t, 2, runner.count())
+}
+
+func TestRunRecordsFailure(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	cmd := &Scheduler{runner: func(ctx *appcontext.AppContext, tc *TaskConfig) (int, error) {
+		return 1, os.ErrNotExist
+	}}
+	j := newTestJob(t, OverlapSkip)
+
+	var wg sync.WaitGroup
+	cmd.trigger(ctx, j, &wg)
+	wg.Wait()
+
+	st := j.status()
+	require.Equal(t, 1, st.LastStatus)
+	require.Equal(t, os.ErrNotExist.Error(), st.LastError)
+	require.False(t, st.LastStart.IsZero())
+}
+
+func TestStatusAndStopOverSocket(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+
+	cfg, err := ParseConfig([]byte("tasks:\n  - name: nightly\n    command: backup\n    store: '@home'\n    cron: '0 3 * * *'\n"))
+	require.NoError(t, err)
+
+	cmd := &Scheduler{
+		socketPath: socketPath(ctx),
+		config:     cfg,
+		runner: func(ctx *appcontext.AppContext, tc *TaskConfig) (int, error) {
+			return 0, nil
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.ListenAndServe(ctx) }()
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(cmd.socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status := &SchedulerStatus{}
+	require.NoError(t, status.Parse(ctx, []string{}))
+	require.Eventually(t, func() bool {
+		ctx.Stdout.(*bytes.Buffer).Reset()
+		ret, err := status.Execute(ctx, nil)
+		return err == nil && ret == 0 && bytes.Contains(ctx.Stdout.(*bytes.Buffer).Bytes(), []byte("next run:"))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	out := ctx.Stdout.(*bytes.Buffer).String()
+	require.Contains(t, out, "nightly: backup on @home, 0 3 * * * (idle)")
+	require.Contains(t, out, "runs: 0, skipped: 0")
+
+	stop := &SchedulerStop{}
+	require.NoError(t, stop.Parse(ctx, []string{}))
+	ret, err := stop.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, ret)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+
+	_, err = os.Stat(cmd.socketPath)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestStatusNotRunning(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	status := &SchedulerStatus{}
+	_, err := status.Execute(ctx, nil)
+	require.ErrorIs(t, err, ErrNotRunning)
+}
+
+func TestStatusStopTooManyArgs(t *testing.T) {
+	ctx := newSchedulerCtx(t)
+	require.Error(t, (&SchedulerStatus{}).Parse(ctx, []string{"extra"}))
+	require.Error(t, (&SchedulerStop{}).Parse(ctx, []string{"extra"}))
+}
@@ -0,0 +1,316 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/versioning"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/ratelimit"
+	"github.com/PlakarKorp/plakar/retention"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/task"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+type TaskStatus struct {
+	Name     string
+	Command  string
+	Store    string
+	Schedule string
+	Overlap  string
+
+	Running bool
+	Queued  bool
+
+	Runs    int
+	Skipped int
+
+	LastStart    time.Time
+	LastDuration time.Duration
+	LastStatus   int
+	LastError    string
+
+	NextRun time.Time
+}
+
+type job struct {
+	config   TaskConfig
+	schedule Schedule
+
+	mtx          sync.Mutex
+	running      bool
+	queued       bool
+	runs         int
+	skipped      int
+	lastStart    time.Time
+	lastDuration time.Duration
+	lastStatus   int
+	lastError    string
+	nextRun      time.Time
+}
+
+func newJob(config TaskConfig) (*job, error) {
+	schedule, err := config.Schedule()
+	if err != nil {
+		return nil, fmt.Errorf("task %q: %w", config.Name, err)
+	}
+	return &job{
+		config:   config,
+		schedule: schedule,
+	}, nil
+}
+
+func (t *job) status() TaskStatus {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return TaskStatus{
+		Name:         t.config.Name,
+		Command:      t.config.Command,
+		Store:        t.config.Store,
+		Schedule:     t.schedule.String(),
+		Overlap:      t.config.Overlap,
+		Running:      t.running,
+		Queued:       t.queued,
+		Runs:         t.runs,
+		Skipped:      t.skipped,
+		LastStart:    t.lastStart,
+		LastDuration: t.lastDuration,
+		LastStatus:   t.lastStatus,
+		LastError:    t.lastError,
+		NextRun:      t.nextRun,
+	}
+}
+
+// loop waits for each activation of the task until the context is
+// cancelled.
+func (cmd *Scheduler) loop(ctx *appcontext.AppContext, t *job, wg *sync.WaitGroup) {
+	for {
+		next := t.schedule.Next(time.Now())
+		if next.IsZero() {
+			ctx.GetLogger().Warn("task %s: schedule will never fire again", t.config.Name)
+			return
+		}
+
+		t.mtx.Lock()
+		t.nextRun = next
+		t.mtx.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cmd.trigger(ctx, t, wg)
+	}
+}
+
+// trigger starts a run of the task unless one is already in progress,
+// in which case the overlap policy decides whether the activation is
+// dropped or queued behind the current run.  At most one run is
+// queued at any time.
+func (cmd *Scheduler) trigger(ctx *appcontext.AppContext, t *job, wg *sync.WaitGroup) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.running {
+		if t.config.Overlap == OverlapQueue {
+			if !t.queued {
+				ctx.GetLogger().Info("task %s: previous run still in progress, queueing", t.config.Name)
+			}
+			t.queued = true
+		} else {
+			ctx.GetLogger().Warn("task %s: previous run still in progress, skipping", t.config.Name)
+			t.skipped++
+		}
+		return
+	}
+
+	t.running = true
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cmd.run(ctx, t)
+	}()
+}
+
+func (cmd *Scheduler) run(ctx *appcontext.AppContext, t *job) {
+	for {
+		t0 := time.Now()
+		t.mtx.Lock()
+		t.lastStart = t0
+		t.mtx.Unlock()
+
+		ctx.GetLogger().Info("task %s: starting %s", t.config.Name, t.config.Command)
+		status, err := cmd.runner(ctx, &t.config)
+		if err != nil {
+			ctx.GetLogger().Error("task %s: %s", t.config.Name, err)
+		} else {
+			ctx.GetLogger().Info("task %s: done in %s", t.config.Name, time.Since(t0))
+		}
+
+		t.mtx.Lock()
+		t.runs++
+		t.lastDuration = time.Since(t0)
+		t.lastStatus = status
+		t.lastError = ""
+		if err != nil {
+			t.lastError = err.Error()
+		}
+
+		if t.queued && ctx.Err() == nil {
+			t.queued = false
+			t.mtx.Unlock()
+			continue
+		}
+
+		t.queued = false
+		t.running = false
+		t.mtx.Unlock()
+		return
+	}
+}
+
+func getPassphrase(ctx *appcontext.AppContext, params map[string]string) (string, error) {
+	if ctx.KeyFromFile != "" {
+		return ctx.KeyFromFile, nil
+	}
+
+	if pass, ok := params["passphrase"]; ok {
+		delete(params, "passphrase")
+		return pass, nil
+	}
+
+	if cmd, ok := params["passphrase_cmd"]; ok {
+		delete(params, "passphrase_cmd")
+		return utils.GetPassphraseFromCommand(cmd)
+	}
+
+	if pass, ok := os.LookupEnv("PLAKAR_PASSPHRASE"); ok {
+		return pass, nil
+	}
+
+	return "", nil
+}
+
+func getKey(config *storage.Configuration, passphrase string) ([]byte, error) {
+	if config.Encryption == nil {
+		return nil, nil
+	}
+
+	// There is nobody to prompt, the passphrase must be available
+	// from the configuration or the environment.
+	if passphrase == "" {
+		return nil, fmt.Errorf("no passphrase available for encrypted repository")
+	}
+
+	key, err := encryption.DeriveKey(config.Encryption.KDFParams, []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	if !encryption.VerifyCanary(config.Encryption, key) {
+		return nil, fmt.Errorf("could not unlock repository")
+	}
+	return key, nil
+}
+
+// runTask opens the store the task refers to and runs the configured
+// command against it, exactly like the command line would.
+func runTask(ctx *appcontext.AppContext, tc *TaskConfig) (int, error) {
+	taskCtx := appcontext.NewAppContextFrom(ctx)
+	defer taskCtx.Close()
+
+	if err := taskCtx.ReloadConfig(); err != nil {
+		return 1, fmt.Errorf("could not load configuration: %w", err)
+	}
+
+	storeName := tc.Store
+	if storeName == "" {
+		if taskCtx.Config.DefaultRepository == "" {
+			return 1, fmt.Errorf("no store specified")
+		}
+		storeName = "@" + taskCtx.Config.DefaultRepository
+	}
+
+	storeConfig, err := taskCtx.Config.GetRepository(storeName)
+	if err != nil {
+		return 1, err
+	}
+
+	cmd, _, args := subcommands.Lookup(append([]string{tc.Command}, tc.Args...))
+	if cmd == nil {
+		return 1, fmt.Errorf("command not found: %s", tc.Command)
+	}
+
+	passphrase, err := getPassphrase(taskCtx, storeConfig)
+	if err != nil {
+		return 1, err
+	}
+	taskCtx.Retention = retention.FromConfig(storeConfig)
+
+	store, serializedConfig, err := ratelimit.Open(taskCtx.GetInner(), storeConfig, taskCtx.Limits)
+	if err != nil {
+		return 1, fmt.Errorf("failed to open the repository at %s: %w", storeConfig["location"], err)
+	}
+	defer store.Close(taskCtx)
+
+	repoConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return 1, err
+	}
+
+	if repoConfig.Version != versioning.FromString(storage.VERSION) {
+		return 1, fmt.Errorf("incompatible repository version: %s != %s",
+			repoConfig.Version, storage.VERSION)
+	}
+
+	key, err := getKey(repoConfig, passphrase)
+	if err != nil {
+		return 1, err
+	}
+	taskCtx.SetSecret(key)
+
+	repo, err := repository.NewNoRebuild(taskCtx.GetInner(), key, store, serializedConfig, true)
+	if err != nil {
+		return 1, err
+	}
+	defer repo.Close()
+
+	taskCtx.StoreConfig = storeConfig
+
+	if err := cmd.Parse(taskCtx, args); err != nil {
+		return 1, err
+	}
+
+	if _, err := cached.RebuildStateFromStore(taskCtx, repo.Configuration().RepositoryID, storeConfig, false); err != nil {
+		return 1, err
+	}
+
+	return task.RunCommand(taskCtx, cmd, repo, tc.Name)
+}
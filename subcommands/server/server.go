@@ -19,10 +19,14 @@ package server
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/server/httpd"
+	"github.com/PlakarKorp/plakar/server/worm"
 	"github.com/PlakarKorp/plakar/subcommands"
 )
 
@@ -43,6 +47,10 @@ func (cmd *Server) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.BoolVar(&opt_allowdelete, "allow-delete", false, "enable delete operations")
 	flags.StringVar(&cmd.Cert, "cert", "", "Full certificate chain")
 	flags.StringVar(&cmd.Key, "key", "", "Certificate private key")
+	flags.StringVar(&cmd.AuthFile, "auth", "", "file listing the clients allowed to connect")
+	flags.StringVar(&cmd.AccessLog, "access-log", "", "file to log the requests to, - for stdout")
+	flags.DurationVar(&cmd.WORMRetention, "worm", 0, "enable WORM mode, keeping resources for the given duration")
+	flags.StringVar(&cmd.AdminTokenFile, "admin-token", "", "file holding the token allowed to delete in WORM mode")
 
 	flags.Parse(args)
 
@@ -61,9 +69,58 @@ type Server struct {
 	NoDelete   bool
 	Cert       string
 	Key        string
+	AuthFile   string
+	AccessLog  string
+
+	WORMRetention  time.Duration
+	AdminTokenFile string
 }
 
 func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	opts := &httpd.Options{
+		NoDelete: cmd.NoDelete,
+		Cert:     cmd.Cert,
+		Key:      cmd.Key,
+	}
+
+	if cmd.AuthFile != "" {
+		auth, err := httpd.LoadAuth(cmd.AuthFile)
+		if err != nil {
+			return 1, err
+		}
+		opts.Auth = auth
+	}
+
+	switch cmd.AccessLog {
+	case "":
+	case "-":
+		opts.AccessLog = ctx.Stdout
+	default:
+		fp, err := os.OpenFile(cmd.AccessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return 1, err
+		}
+		defer fp.Close()
+		opts.AccessLog = fp
+	}
+
+	if cmd.WORMRetention != 0 || cmd.AdminTokenFile != "" {
+		var token string
+		if cmd.AdminTokenFile != "" {
+			data, err := os.ReadFile(cmd.AdminTokenFile)
+			if err != nil {
+				return 1, err
+			}
+			token = strings.TrimSpace(string(data))
+		}
+
+		policy, err := worm.Enable(ctx, repo.Store(), cmd.WORMRetention, token)
+		if err != nil {
+			return 1, fmt.Errorf("failed to enable WORM mode: %w", err)
+		}
+		ctx.GetLogger().Info("WORM mode enabled, retention %s", policy.Retention)
+	}
+
 	var protocol string
 	if cmd.Cert != "" && cmd.Key != "" {
 		protocol = "https"
@@ -71,7 +128,7 @@ func (cmd *Server) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 		protocol = "http"
 	}
 	ctx.GetLogger().Info("listening on %s://%s", protocol, cmd.ListenAddr)
-	err := httpd.Server(ctx, repo, cmd.ListenAddr, cmd.NoDelete, cmd.Cert, cmd.Key)
+	err := httpd.Server(ctx, repo, cmd.ListenAddr, opts)
 	if err != nil {
 		return 1, err
 	}
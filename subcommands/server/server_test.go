@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -120,3 +122,96 @@ func TestExecuteCmdServerDefault(t *testing.T) {
 	// we dont test all the field from configuration
 	require.Equal(t, versioning.FromString(storage.VERSION), configInstance.Version)
 }
+
+func TestServerParseAuthAndAccessLog(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	defer ctx.Close()
+	_ = repo
+
+	cmd := &Server{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-auth", "clients.yaml", "-access-log", "-"}))
+	require.Equal(t, "clients.yaml", cmd.AuthFile)
+	require.Equal(t, "-", cmd.AccessLog)
+}
+
+func TestServerExecuteInvalidAuthReturns1(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	defer ctx.Close()
+
+	path := filepath.Join(t.TempDir(), "clients.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("tokens: [{name: x, scopes: [read]}]\n"), 0600))
+
+	cmd := &Server{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-listen", "127.0.0.1:0", "-auth", path}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "missing token")
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdServerAuth(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	defer ctx.Close()
+
+	dir := t.TempDir()
+	authFile := filepath.Join(dir, "clients.yaml")
+	require.NoError(t, os.WriteFile(authFile, []byte("tokens: [{name: laptop, token: s3cr3t, scopes: [read]}]\n"), 0600))
+	accessLog := filepath.Join(dir, "access.log")
+
+	cmd := &Server{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		"-listen", "127.0.0.1:12346",
+		"-auth", authFile,
+		"-access-log", accessLog,
+	}))
+
+	go cmd.Execute(ctx, repo)
+
+	// wait for the server to start
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(token string) int {
+		req, err := http.NewRequest("GET", "http://127.0.0.1:12346/", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get(""))
+	require.Equal(t, http.StatusOK, get("s3cr3t"))
+
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(accessLog)
+		return err == nil && strings.Contains(string(data), " laptop GET / 200 ")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerParseWORM(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	defer ctx.Close()
+	_ = repo
+
+	cmd := &Server{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-worm", "720h", "-admin-token", "admin.token"}))
+	require.Equal(t, 720*time.Hour, cmd.WORMRetention)
+	require.Equal(t, "admin.token", cmd.AdminTokenFile)
+}
+
+func TestServerExecuteWORMRequiresAdminToken(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	defer ctx.Close()
+
+	cmd := &Server{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-listen", "127.0.0.1:0", "-worm", "720h"}))
+
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "admin token is required")
+	require.Equal(t, 1, status)
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package snapshot
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+// SnapshotExtract makes a new snapshot of a subtree of a snapshot.
+type SnapshotExtract struct {
+	subcommands.SubcommandBase
+
+	options
+	SnapshotPath string
+}
+
+func (cmd *SnapshotExtract) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("snapshot extract", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT:PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	cmd.options.flags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("a single snapshot path is required")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.SnapshotPath = flags.Arg(0)
+
+	return nil
+}
+
+func (cmd *SnapshotExtract) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	st, err := openSubtree(repo, cmd.SnapshotPath, cmd.Source)
+	if err != nil {
+		return 1, fmt.Errorf("snapshot extract: %s: %w", cmd.SnapshotPath, err)
+	}
+	defer st.snap.Close()
+
+	snapshotID, err := build(repo, &cmd.options, []*subtree{st})
+	if err != nil {
+		return 1, fmt.Errorf("snapshot extract: %w", err)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "%x\n", snapshotID)
+	return 0, nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package snapshot
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+// SnapshotMerge makes a new snapshot of subtrees of several snapshots.
+type SnapshotMerge struct {
+	subcommands.SubcommandBase
+
+	options
+	SnapshotPaths []string
+}
+
+func (cmd *SnapshotMerge) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("snapshot merge", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT:PATH SNAPSHOT:PATH...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	cmd.options.flags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		return fmt.Errorf("at least two snapshot paths are required")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.SnapshotPaths = flags.Args()
+
+	return nil
+}
+
+func (cmd *SnapshotMerge) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	var subtrees []*subtree
+	defer func() {
+		for _, st := range subtrees {
+			st.snap.Close()
+		}
+	}()
+
+	for _, snapshotPath := range cmd.SnapshotPaths {
+		st, err := openSubtree(repo, snapshotPath, cmd.Source)
+		if err != nil {
+			return 1, fmt.Errorf("snapshot merge: %s: %w", snapshotPath, err)
+		}
+		subtrees = append(subtrees, st)
+	}
+
+	snapshotID, err := build(repo, &cmd.options, subtrees)
+	if err != nil {
+		return 1, fmt.Errorf("snapshot merge: %w", err)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "%x\n", snapshotID)
+	return 0, nil
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package snapshot
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &SnapshotExtract{} }, 0, "snapshot", "extract")
+	subcommands.Register(func() subcommands.Subcommand { return &SnapshotMerge{} }, 0, "snapshot", "merge")
+}
+
+// DerivedFrom is the annotation recording the SNAPSHOT:PATH a snapshot
+// built by extract or merge was made of.
+const DerivedFrom = "derived-from"
+
+type tagFlags []string
+
+func (t *tagFlags) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *tagFlags) Set(value string) error {
+	for tag := range strings.SplitSeq(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*t = append(*t, tag)
+		}
+	}
+	return nil
+}
+
+// options are those shared by extract and merge, they default to the
+// metadata of the first snapshot.
+type options struct {
+	Name   string
+	Tags   []string
+	Source string
+}
+
+func (opts *options) flags(flags *flag.FlagSet) {
+	flags.StringVar(&opts.Name, "name", "", "name of the new snapshot, defaults to that of the first snapshot")
+	flags.Var((*tagFlags)(&opts.Tags), "tag", "comma-separated list of tags of the new snapshot, defaults to those of the first snapshot")
+	flags.StringVar(&opts.Source, "source", "", "take the paths from the given source of multi-source snapshots")
+}
+
+// subtree is a directory or a file of a snapshot.
+type subtree struct {
+	snap     *snapshot.Snapshot
+	fs       *vfs.Filesystem
+	pathname string
+}
+
+func openSubtree(repo *repository.Repository, snapshotPath, selector string) (*subtree, error) {
+	snap, pathname, _, err := utils.OpenSnapshotSource(repo, snapshotPath, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		snap.Close()
+		return nil, err
+	}
+	if _, err := fs.GetEntryNoFollow(pathname); err != nil {
+		snap.Close()
+		return nil, fmt.Errorf("%s: %w", pathname, err)
+	}
+
+	return &subtree{snap: snap, fs: fs, pathname: pathname}, nil
+}
+
+// build commits a snapshot holding the given subtrees.  They are grouped
+// by the snapshot source they come from, each group making one source of
+// the new snapshot.  The entries are recorded as they are in the
+// snapshots they come from, whose filesystems serve as the cache of the
+// builder, so that their content is referenced and not read again.
+func build(repo *repository.Repository, opts *options, subtrees []*subtree) (objects.MAC, error) {
+	first := subtrees[0].snap.Header
+
+	builderOpts := &snapshot.BuilderOptions{
+		Name:        first.Name,
+		Tags:        first.Tags,
+		Category:    first.Category,
+		Environment: first.Environment,
+		Perimeter:   first.Perimeter,
+		Job:         first.Job,
+	}
+	if opts.Name != "" {
+		builderOpts.Name = opts.Name
+	}
+	if len(opts.Tags) != 0 {
+		builderOpts.Tags = opts.Tags
+	}
+
+	builder, err := snapshot.Create(repo, repository.DefaultType, "", objects.NilMac, builderOpts)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer builder.Close()
+
+	var (
+		groups  = make(map[string][]*subtree)
+		order   []string
+		derived []string
+	)
+	for _, st := range subtrees {
+		src := st.snap.Header.GetSource(0)
+		key := fmt.Sprintf("%x:%s:%s:%s", st.snap.Header.Identifier, src.Importer.Type, src.Importer.Origin, src.Importer.Directory)
+		if _, found := groups[key]; !found {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], st)
+		derived = append(derived, fmt.Sprintf("%x:%s", st.snap.Header.Identifier, st.pathname))
+	}
+
+	for _, key := range order {
+		var importers []importer.Importer
+		for _, st := range groups[key] {
+			importers = append(importers, newSubtreeImporter(st))
+		}
+
+		source, err := snapshot.NewSource(repo.AppContext(), importers...)
+		if err != nil {
+			return objects.MAC{}, err
+		}
+
+		builder.WithVFSCache(groups[key][0].fs)
+		if err := builder.Backup(source); err != nil {
+			return objects.MAC{}, fmt.Errorf("failed to import %s: %w", source.Root(), err)
+		}
+	}
+
+	utils.SetAnnotation(builder.Header, DerivedFrom, strings.Join(derived, " "))
+
+	if err := builder.Commit(); err != nil {
+		return objects.MAC{}, fmt.Errorf("failed to commit snapshot: %w", err)
+	}
+	return builder.Header.Identifier, nil
+}
+
+// subtreeImporter records a subtree of a snapshot, along with the
+// directories leading to it.
+type subtreeImporter struct {
+	typ    string
+	origin string
+	root   string
+	fs     *vfs.Filesystem
+}
+
+func newSubtreeImporter(st *subtree) *subtreeImporter {
+	src := st.snap.Header.GetSource(0)
+	return &subtreeImporter{
+		typ:    src.Importer.Type,
+		origin: src.Importer.Origin,
+		root:   st.pathname,
+		fs:     st.fs,
+	}
+}
+
+func (imp *subtreeImporter) Origin() string        { return imp.origin }
+func (imp *subtreeImporter) Type() string          { return imp.typ }
+func (imp *subtreeImporter) Root() string          { return imp.root }
+func (imp *subtreeImporter) Flags() location.Flags { return 0 }
+
+func (imp *subtreeImporter) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (imp *subtreeImporter) Close(ctx context.Context) error {
+	return nil
+}
+
+func (imp *subtreeImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	var parents []string
+	for dir := imp.root; dir != "/"; {
+		dir = path.Dir(dir)
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		entry, err := imp.fs.GetEntryNoFollow(parents[i])
+		if err != nil {
+			return err
+		}
+		imp.record(parents[i], entry, records)
+	}
+
+	for item, err := range imp.fs.Errors(imp.root) {
+		if err != nil {
+			return err
+		}
+		records <- connectors.NewError(item.Name, fmt.Errorf("%s", item.Error))
+	}
+
+	return imp.fs.WalkDir(imp.root, func(pathname string, entry *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		imp.record(pathname, entry, records)
+		return nil
+	})
+}
+
+func (imp *subtreeImporter) record(pathname string, entry *vfs.Entry, records chan<- *connectors.Record) {
+	records <- connectors.NewRecord(pathname, entry.SymlinkTarget, entry.FileInfo, entry.ExtendedAttributes,
+		func() (io.ReadCloser, error) {
+			return imp.fs.Open(pathname)
+		})
+
+	for _, attr := range entry.ExtendedAttributes {
+		records <- connectors.NewXattr(pathname, attr, objects.AttributeExtended,
+			func() (io.ReadCloser, error) {
+				rd, err := entry.Xattr(imp.fs, attr)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(rd), nil
+			})
+	}
+}
@@ -0,0 +1,178 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/subcommands"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRegisteredFactory(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"snapshot", "extract"})
+	require.IsType(t, &SnapshotExtract{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"snapshot", "merge"})
+	require.IsType(t, &SnapshotMerge{}, cmd)
+}
+
+func TestSnapshotParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	extract := &SnapshotExtract{}
+	require.ErrorContains(t, extract.Parse(ctx, []string{}), "a single snapshot path is required")
+	extract = &SnapshotExtract{}
+	require.ErrorContains(t, extract.Parse(ctx, []string{"a:/x", "b:/y"}), "a single snapshot path is required")
+	extract = &SnapshotExtract{}
+	require.NoError(t, extract.Parse(ctx, []string{"-name", "alice", "-tag", "legal,hold", "abcd:/home/alice"}))
+	require.Equal(t, "alice", extract.Name)
+	require.Equal(t, []string{"legal", "hold"}, extract.Tags)
+	require.Equal(t, "abcd:/home/alice", extract.SnapshotPath)
+
+	merge := &SnapshotMerge{}
+	require.ErrorContains(t, merge.Parse(ctx, []string{"a:/x"}), "at least two snapshot paths are required")
+	merge = &SnapshotMerge{}
+	require.NoError(t, merge.Parse(ctx, []string{"-source", "1", "a:/x", "b:/y"}))
+	require.Equal(t, "1", merge.Source)
+	require.Equal(t, []string{"a:/x", "b:/y"}, merge.SnapshotPaths)
+}
+
+// newSnapshot returns the snapshot whose identifier is printed in out.
+func newSnapshot(t *testing.T, repo *repository.Repository, out string) *snapshot.Snapshot {
+	id, err := hex.DecodeString(strings.TrimSpace(out))
+	require.NoError(t, err)
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, objects.MAC(id))
+	require.NoError(t, err)
+	t.Cleanup(func() { snap.Close() })
+	return snap
+}
+
+func TestSnapshotExtract(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("home"),
+		ptesting.NewMockDir("home/alice"),
+		ptesting.NewMockDir("home/alice/docs"),
+		ptesting.NewMockFile("home/alice/docs/contract.txt", 0644, "signed"),
+		ptesting.NewMockDir("home/bob"),
+		ptesting.NewMockFile("home/bob/notes.txt", 0644, "bob"),
+	}, ptesting.WithName("full-system"))
+	defer snap.Close()
+	origFS, err := snap.Filesystem()
+	require.NoError(t, err)
+	origEntry, err := origFS.GetEntry("/home/alice/docs/contract.txt")
+	require.NoError(t, err)
+
+	snapshotID := snap.Header.Identifier
+	cmd := &SnapshotExtract{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-tag", "legal", hex.EncodeToString(snapshotID[:4]) + ":/home/alice"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err, bufErr.String())
+	require.Equal(t, 0, status)
+
+	extracted := newSnapshot(t, repo, bufOut.String())
+	require.NotEqual(t, snapshotID, extracted.Header.Identifier)
+	require.Equal(t, "full-system", extracted.Header.Name)
+	require.Equal(t, []string{"legal"}, extracted.Header.Tags)
+	require.Equal(t, "derived-from", utils.Annotations(extracted.Header)[0].Key)
+	require.Equal(t, hex.EncodeToString(snapshotID[:])+":/home/alice", utils.Annotations(extracted.Header)[0].Value)
+
+	require.Len(t, extracted.Header.Sources, 1)
+	require.Equal(t, "/home/alice", extracted.Header.GetSource(0).Importer.Directory)
+	require.Equal(t, snap.Header.GetSource(0).Importer.Origin, extracted.Header.GetSource(0).Importer.Origin)
+
+	pvfs, err := extracted.Filesystem()
+	require.NoError(t, err)
+	data, err := fs.ReadFile(pvfs, "/home/alice/docs/contract.txt")
+	require.NoError(t, err)
+	require.Equal(t, "signed", string(data))
+	_, err = pvfs.GetEntry("/home/bob")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// the content is that of the original snapshot
+	entry, err := pvfs.GetEntry("/home/alice/docs/contract.txt")
+	require.NoError(t, err)
+	require.Equal(t, origEntry.Object, entry.Object)
+}
+
+func TestSnapshotExtractMissingPath(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	})
+	defer snap.Close()
+
+	snapshotID := snap.Header.Identifier
+	cmd := &SnapshotExtract{}
+	require.NoError(t, cmd.Parse(ctx, []string{hex.EncodeToString(snapshotID[:4]) + ":/missing"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "/missing")
+	require.Equal(t, 1, status)
+	require.Empty(t, bufOut.String())
+}
+
+func TestSnapshotMerge(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap1 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("etc"),
+		ptesting.NewMockFile("etc/hosts", 0644, "127.0.0.1 localhost"),
+		ptesting.NewMockDir("var"),
+		ptesting.NewMockFile("var/log", 0644, "log"),
+	}, ptesting.WithName("first"))
+	defer snap1.Close()
+	snap2 := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("srv"),
+		ptesting.NewMockFile("srv/index.html", 0644, "<html>"),
+	}, ptesting.WithName("second"))
+	defer snap2.Close()
+
+	id1 := hex.EncodeToString(snap1.Header.Identifier[:])
+	id2 := hex.EncodeToString(snap2.Header.Identifier[:])
+	cmd := &SnapshotMerge{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-name", "curated",
+		id1 + ":/etc", id2 + ":/srv", id1 + ":/var/log"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err, bufErr.String())
+	require.Equal(t, 0, status)
+
+	merged := newSnapshot(t, repo, bufOut.String())
+	require.Equal(t, "curated", merged.Header.Name)
+	require.Equal(t, id1+":/etc "+id2+":/srv "+id1+":/var/log", utils.Annotations(merged.Header)[0].Value)
+
+	// one source per snapshot the subtrees come from
+	require.Len(t, merged.Header.Sources, 2)
+	require.Equal(t, "/", merged.Header.GetSource(0).Importer.Directory)
+	require.Equal(t, "/srv", merged.Header.GetSource(1).Importer.Directory)
+
+	expected := []map[string]string{
+		{"/etc/hosts": "127.0.0.1 localhost", "/var/log": "log"},
+		{"/srv/index.html": "<html>"},
+	}
+	i := 0
+	for source, err := range utils.SnapshotSources(repo, merged) {
+		require.NoError(t, err)
+		pvfs, err := source.Filesystem()
+		require.NoError(t, err)
+		for name, content := range expected[i] {
+			data, err := fs.ReadFile(pvfs, name)
+			require.NoError(t, err, name)
+			require.Equal(t, content, string(data), name)
+		}
+		i++
+	}
+}
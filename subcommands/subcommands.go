@@ -24,6 +24,20 @@ type Subcommand interface {
 	setFlags(CommandFlags)
 }
 
+// Interrupter is implemented by the subcommands able to stop gracefully
+// on an interrupt signal.  Interrupt returns false if the subcommand must
+// be canceled instead.
+type Interrupter interface {
+	Interrupt() bool
+}
+
+// ArgsChecker is implemented by the subcommands able to validate their
+// arguments without acting on them, so that a scheduled task with invalid
+// arguments is rejected when the configuration is loaded.
+type ArgsChecker interface {
+	CheckArgs(args []string) error
+}
+
 type SubcommandBase struct {
 	RepositorySecret []byte
 	Flags            CommandFlags
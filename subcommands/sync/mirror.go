@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+// mirrorEntry is a snapshot of the destination a mirror removes.
+type mirrorEntry struct {
+	ID        objects.MAC
+	Timestamp time.Time
+	Name      string
+	Size      uint64
+}
+
+// mirrorCandidates returns the snapshots of dst not in keep, leaving out
+// the checkpoints, those younger than minAge and the held ones, oldest
+// first.  It also
+// returns how many were left out for each reason.
+func mirrorCandidates(dst *repository.Repository, dstSnapshotIDs, keep []objects.MAC, minAge time.Duration) ([]mirrorEntry, int, int, error) {
+	kept := make(map[objects.MAC]struct{}, len(keep))
+	for _, snapshotID := range keep {
+		kept[snapshotID] = struct{}{}
+	}
+
+	now := time.Now()
+	young, held := 0, 0
+	candidates := make([]mirrorEntry, 0)
+	for _, snapshotID := range dstSnapshotIDs {
+		if _, found := kept[snapshotID]; found {
+			continue
+		}
+
+		snap, err := snapshot.Load(dst, snapshotID)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+		}
+		entry := mirrorEntry{
+			ID:        snapshotID,
+			Timestamp: snap.Header.Timestamp,
+			Name:      snap.Header.Name,
+		}
+		for i := range snap.Header.Sources {
+			summary := &snap.Header.Sources[i].Summary
+			entry.Size += summary.Directory.Size + summary.Below.Size
+		}
+		isCheckpoint := snap.Header.HasTag(utils.CheckpointTag)
+		snap.Close()
+
+		hold, err := utils.LoadHold(dst, snapshotID, now)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("snapshot %x: %w", snapshotID[:4], err)
+		}
+
+		// a backup in progress on dst
+		if isCheckpoint {
+			continue
+		}
+		if hold != nil {
+			held++
+			continue
+		}
+		if minAge != 0 && now.Sub(entry.Timestamp) < minAge {
+			young++
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+
+	slices.SortFunc(candidates, func(a, b mirrorEntry) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return candidates, young, held, nil
+}
+
+// mirror removes from dst the snapshots which are not in keep, the
+// snapshots of the source matching the filters, and returns how many
+// were.  Nothing is removed if that would be more than MaxDelete percent
+// of the dstSnapshotIDs.
+func (cmd *Sync) mirror(ctx *appcontext.AppContext, dst *repository.Repository, dstSnapshotIDs, keep []objects.MAC) (int, error) {
+	candidates, young, held, err := mirrorCandidates(dst, dstSnapshotIDs, keep, cmd.MinAge)
+	if err != nil {
+		return 0, err
+	}
+	if young != 0 {
+		ctx.GetLogger().Info("sync: keeping %d snapshots of %s younger than %s", young, dst.Origin(), cmd.MinAge)
+	}
+	if held != 0 {
+		ctx.GetLogger().Info("sync: keeping %d held snapshots of %s", held, dst.Origin())
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	if len(candidates)*100 > cmd.MaxDelete*len(dstSnapshotIDs) {
+		return 0, fmt.Errorf("would remove %d of the %d snapshots of %s, more than the %d%% allowed by -max-delete",
+			len(candidates), len(dstSnapshotIDs), dst.Origin(), cmd.MaxDelete)
+	}
+
+	verb := "removed"
+	if cmd.DryRun {
+		verb = "would remove"
+	} else {
+		dst.NoStateToLocalDisk = true
+	}
+
+	removed := 0
+	for _, entry := range candidates {
+		if !cmd.DryRun {
+			if err := dst.DeleteSnapshot(entry.ID); err != nil {
+				ctx.GetLogger().Error("failed to remove snapshot %x from store %s: %s", entry.ID[:4], dst.Origin(), err)
+				continue
+			}
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %s %x %10s %s\n", verb,
+			entry.Timestamp.UTC().Format(time.RFC3339), entry.ID[:4],
+			humanize.IBytes(entry.Size), utils.SanitizeText(entry.Name))
+		removed++
+	}
+
+	if removed != len(candidates) {
+		return removed, fmt.Errorf("failed to remove %d snapshots from %s", len(candidates)-removed, dst.Origin())
+	}
+	return removed, nil
+}
@@ -0,0 +1,144 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMirrorParse(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+
+	err := (&Sync{}).Parse(fixture.localCtx, []string{"-max-delete", "50", "to", fixture.peerArg})
+	require.ErrorContains(t, err, "-max-delete only applies to mirror")
+
+	err = (&Sync{}).Parse(fixture.localCtx, []string{"-max-delete", "101", "mirror", fixture.peerArg})
+	require.ErrorContains(t, err, "between 0 and 100")
+
+	cmd := &Sync{}
+	require.NoError(t, cmd.Parse(fixture.localCtx, []string{"-min-age", "7d", "mirror", fixture.peerArg}))
+	require.Equal(t, "mirror", cmd.Direction)
+	require.Equal(t, 10, cmd.MaxDelete)
+	require.Equal(t, 7*24*time.Hour, cmd.MinAge)
+}
+
+func TestSyncMirror(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer local.Close()
+	stale := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3], ptesting.WithName("stale"))
+	defer stale.Close()
+
+	runSync(t, fixture, []string{"-max-delete", "100", "mirror", fixture.peerArg})
+
+	peerIDs := snapshotIDs(t, fixture.peerRepo)
+	require.Len(t, peerIDs, 1)
+	require.Contains(t, peerIDs, local.Header.Identifier)
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("removed %s %x", stale.Header.Timestamp.UTC().Format(time.RFC3339), stale.Header.Identifier[:4]))
+	require.Contains(t, fixture.output.String(), "completed: 1 snapshots synchronized, 1 removed")
+}
+
+func TestSyncMirrorDryRun(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer local.Close()
+	stale := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3])
+	defer stale.Close()
+
+	runSync(t, fixture, []string{"-dry-run", "-max-delete", "100", "mirror", fixture.peerArg})
+
+	peerIDs := snapshotIDs(t, fixture.peerRepo)
+	require.Len(t, peerIDs, 1)
+	require.Contains(t, peerIDs, stale.Header.Identifier)
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("would remove %s %x", stale.Header.Timestamp.UTC().Format(time.RFC3339), stale.Header.Identifier[:4]))
+}
+
+func TestSyncMirrorMaxDelete(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer local.Close()
+	for range 2 {
+		snap := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3])
+		snap.Close()
+	}
+
+	cmd := &Sync{}
+	require.NoError(t, cmd.Parse(fixture.localCtx, []string{"-max-delete", "50", "mirror", fixture.peerArg}))
+	status, err := cmd.Execute(fixture.localCtx, fixture.localRepo)
+	require.ErrorContains(t, err, "would remove 2 of the 2 snapshots")
+	require.ErrorContains(t, err, "more than the 50% allowed")
+	require.Equal(t, 1, status)
+
+	// the missing snapshot is still synchronized, nothing is removed
+	require.Len(t, snapshotIDs(t, fixture.peerRepo), 3)
+	require.NotContains(t, fixture.output.String(), "removed")
+}
+
+func TestSyncMirrorMinAge(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	month := ptesting.WithTimestamp(time.Now().Add(-30 * 24 * time.Hour))
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles, ptesting.WithName("kept"), month)
+	defer local.Close()
+	other := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles, ptesting.WithName("other"), month)
+	defer other.Close()
+	runSync(t, fixture, []string{"to", fixture.peerArg})
+
+	old := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3], month)
+	defer old.Close()
+	recent := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3])
+	defer recent.Close()
+
+	// the snapshots no longer matching the filters are removed as well
+	fixture.output.Reset()
+	runSync(t, fixture, []string{"-name", "kept", "-min-age", "7d", "-max-delete", "100", "mirror", fixture.peerArg})
+
+	peerIDs := snapshotIDs(t, fixture.peerRepo)
+	require.Len(t, peerIDs, 2)
+	require.Contains(t, peerIDs, local.Header.Identifier)
+	require.Contains(t, peerIDs, recent.Header.Identifier)
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("removed %s %x", old.Header.Timestamp.UTC().Format(time.RFC3339), old.Header.Identifier[:4]))
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("%x %10s other", other.Header.Identifier[:4], "49 B"))
+	require.Contains(t, fixture.output.String(), "keeping 1 snapshots")
+	require.Contains(t, fixture.output.String(), "completed: 0 snapshots synchronized, 2 removed")
+}
+
+func TestSyncMirrorHeld(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer local.Close()
+	held := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3], ptesting.WithHold("case 42", time.Time{}))
+	defer held.Close()
+	stale := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3], ptesting.WithName("stale"))
+	defer stale.Close()
+
+	runSync(t, fixture, []string{"-max-delete", "100", "mirror", fixture.peerArg})
+
+	peerIDs := snapshotIDs(t, fixture.peerRepo)
+	require.Len(t, peerIDs, 2)
+	require.Contains(t, peerIDs, local.Header.Identifier)
+	require.Contains(t, peerIDs, held.Header.Identifier)
+	require.Contains(t, fixture.output.String(), "keeping 1 held snapshots")
+	require.Contains(t, fixture.output.String(), "completed: 1 snapshots synchronized, 1 removed")
+}
+
+func TestSyncMirrorCheckpoint(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer local.Close()
+	partial := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles[:3], ptesting.WithTags(utils.CheckpointTag))
+	defer partial.Close()
+	checkpoint := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3], ptesting.WithTags(utils.CheckpointTag))
+	defer checkpoint.Close()
+
+	runSync(t, fixture, []string{"-max-delete", "100", "mirror", fixture.peerArg})
+
+	peerIDs := snapshotIDs(t, fixture.peerRepo)
+	require.Len(t, peerIDs, 2)
+	require.Contains(t, peerIDs, local.Header.Identifier)
+	require.Contains(t, peerIDs, checkpoint.Header.Identifier)
+	require.Contains(t, fixture.output.String(), "completed: 1 snapshots synchronized, 0 removed")
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package sync
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+// PlanEntry is a snapshot a sync would transfer.  Transfer is the part
+// of its file data missing from the destination, a chunk shared with a
+// snapshot planned before it being counted there.
+type PlanEntry struct {
+	ID        objects.MAC
+	Timestamp time.Time
+	Name      string
+	Size      uint64
+	Transfer  uint64
+}
+
+// planner estimates what a sync from src to dst transfers, by looking up
+// the chunks of the snapshots in the state of dst.
+type planner struct {
+	src, dst *repository.Repository
+
+	// the chunks need to be read and their MAC computed with the key
+	// of dst, unless both stores share it
+	sameMAC bool
+	seen    map[objects.MAC]struct{}
+}
+
+func newPlanner(src, dst *repository.Repository) *planner {
+	probe := []byte("plakar sync plan")
+	return &planner{
+		src:     src,
+		dst:     dst,
+		sameMAC: src.ComputeMAC(probe) == dst.ComputeMAC(probe),
+		seen:    make(map[objects.MAC]struct{}),
+	}
+}
+
+func (p *planner) plan(ctx *appcontext.AppContext, snapshotID objects.MAC) (*PlanEntry, error) {
+	snap, err := snapshot.Load(p.src, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer snap.Close()
+
+	if len(snap.Header.Sources) > 1 {
+		return nil, fmt.Errorf("snapshot %x has %d sources, synchronizing multi-source snapshots is not supported yet",
+			snapshotID[:4], len(snap.Header.Sources))
+	}
+
+	entry := &PlanEntry{
+		ID:        snapshotID,
+		Timestamp: snap.Header.Timestamp,
+		Name:      snap.Header.Name,
+	}
+	for i := range snap.Header.Sources {
+		summary := &snap.Header.Sources[i].Summary
+		entry.Size += summary.Directory.Size + summary.Below.Size
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+	err = fs.WalkDir("/", func(pathname string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !e.HasObject() {
+			return nil
+		}
+
+		object, err := p.object(e)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pathname, err)
+		}
+		for _, chunk := range object.Chunks {
+			missing, err := p.missing(chunk.ContentMAC)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pathname, err)
+			}
+			if missing {
+				entry.Transfer += uint64(chunk.Length)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (p *planner) object(e *vfs.Entry) (*objects.Object, error) {
+	if e.ResolvedObject != nil {
+		return e.ResolvedObject, nil
+	}
+	rd, err := p.src.GetBlob(resources.RT_OBJECT, e.Object)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	return objects.NewObjectFromBytes(data)
+}
+
+// missing returns whether a chunk of src needs to be sent to dst, a chunk
+// is only ever reported once.
+func (p *planner) missing(chunkMAC objects.MAC) (bool, error) {
+	if _, found := p.seen[chunkMAC]; found {
+		return false, nil
+	}
+	p.seen[chunkMAC] = struct{}{}
+
+	dstMAC := chunkMAC
+	if !p.sameMAC {
+		data, err := p.src.GetBlobBytes(resources.RT_CHUNK, chunkMAC)
+		if err != nil {
+			return false, err
+		}
+		dstMAC = p.dst.ComputeMAC(data)
+	}
+	return !p.dst.BlobExists(resources.RT_CHUNK, dstMAC), nil
+}
+
+// dryRun prints what a sync of snapshotIDs from src to dst would transfer.
+func dryRun(ctx *appcontext.AppContext, src, dst *repository.Repository, snapshotIDs []objects.MAC) error {
+	p := newPlanner(src, dst)
+
+	var size, transfer uint64
+	planned := 0
+	for _, snapshotID := range snapshotIDs {
+		entry, err := p.plan(ctx, snapshotID)
+		if err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			ctx.GetLogger().Error("failed to plan snapshot %x from store %s: %s", snapshotID[:4], src.Origin(), err)
+			continue
+		}
+
+		fmt.Fprintf(ctx.Stdout, "%s %x %10s %10s %s\n",
+			entry.Timestamp.UTC().Format(time.RFC3339), entry.ID[:4],
+			humanize.IBytes(entry.Size), humanize.IBytes(entry.Transfer),
+			utils.SanitizeText(entry.Name))
+		size += entry.Size
+		transfer += entry.Transfer
+		planned++
+	}
+
+	ctx.GetLogger().Info("sync: dry run from %s to %s: %d snapshots, %s to transfer out of %s",
+		src.Origin(), dst.Origin(), planned, humanize.IBytes(transfer), humanize.IBytes(size))
+
+	if orphans, err := orphanPackfiles(dst); err == nil && len(orphans) != 0 {
+		ctx.GetLogger().Info("sync: %d packfiles of an interrupted sync would be reused on %s", len(orphans), dst.Origin())
+	}
+	return nil
+}
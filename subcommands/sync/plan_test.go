@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"fmt"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncDryRun(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	first := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer first.Close()
+
+	// nothing is synchronized
+	runSync(t, fixture, []string{"-dry-run", "to", fixture.peerArg})
+	require.Empty(t, snapshotIDs(t, fixture.peerRepo))
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("%x", first.Header.Identifier[:4]))
+	require.Contains(t, fixture.output.String(), "dry run from")
+	require.Contains(t, fixture.output.String(), ": 1 snapshots, 49 B to transfer out of 49 B")
+
+	runSync(t, fixture, []string{"to", fixture.peerArg})
+
+	// only what the peer doesn't have yet is to be transferred, although
+	// the chunks have different MACs in both stores
+	second := ptesting.GenerateSnapshot(t, fixture.localRepo, append(mockFiles,
+		ptesting.NewMockFile("subdir/new.txt", 0644, "fresh content")))
+	defer second.Close()
+
+	fixture.output.Reset()
+	runSync(t, fixture, []string{"-dry-run", "to", fixture.peerArg})
+	require.Len(t, snapshotIDs(t, fixture.peerRepo), 1)
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("%x", second.Header.Identifier[:4]))
+	require.NotContains(t, fixture.output.String(), fmt.Sprintf("%x", first.Header.Identifier[:4]))
+	require.Contains(t, fixture.output.String(), ": 1 snapshots, 13 B to transfer out of 62 B")
+}
+
+func TestSyncDryRunWith(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	local := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer local.Close()
+	peer := ptesting.GenerateSnapshot(t, fixture.peerRepo, mockFiles[:3])
+	defer peer.Close()
+
+	runSync(t, fixture, []string{"-dry-run", "with", fixture.peerArg})
+	require.Len(t, snapshotIDs(t, fixture.localRepo), 1)
+	require.Len(t, snapshotIDs(t, fixture.peerRepo), 1)
+
+	// the peer's snapshot only has chunks the local store holds
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("%x", local.Header.Identifier[:4]))
+	require.Contains(t, fixture.output.String(), fmt.Sprintf("%x", peer.Header.Identifier[:4]))
+	require.Contains(t, fixture.output.String(), ": 1 snapshots, 0 B to transfer out of 11 B")
+}
+
+func TestSyncConcurrent(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	fixture.localCtx.MaxConcurrency = 4
+
+	ids := make(map[string]struct{})
+	for i := range 5 {
+		snap := ptesting.GenerateSnapshot(t, fixture.localRepo, append(mockFiles,
+			ptesting.NewMockFile("subdir/unique.txt", 0644, fmt.Sprintf("snapshot %d", i))))
+		ids[fmt.Sprintf("%x", snap.Header.Identifier)] = struct{}{}
+		snap.Close()
+	}
+
+	runSync(t, fixture, []string{"to", fixture.peerArg})
+	require.Contains(t, fixture.output.String(), "completed: 5 snapshots synchronized")
+
+	peerIDs := snapshotIDs(t, fixture.peerRepo)
+	require.Len(t, peerIDs, 5)
+	for id := range peerIDs {
+		require.Contains(t, ids, fmt.Sprintf("%x", id))
+	}
+}
+
+func TestSplitConcurrency(t *testing.T) {
+	for _, tc := range []struct {
+		budget, n, workers, share int
+	}{
+		{8, 1, 1, 8},
+		{8, 2, 2, 4},
+		{8, 3, 3, 2},
+		{8, 20, 8, 1},
+		{1, 5, 1, 1},
+		{0, 5, 1, 1},
+	} {
+		workers, share := splitConcurrency(tc.budget, tc.n)
+		require.Equal(t, tc.workers, workers, "budget %d, %d snapshots", tc.budget, tc.n)
+		require.Equal(t, tc.share, share, "budget %d, %d snapshots", tc.budget, tc.n)
+		require.LessOrEqual(t, workers*share, max(1, tc.budget))
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package sync
+
+import (
+	"io"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/repository/state"
+	"github.com/PlakarKorp/plakar/appcontext"
+)
+
+// orphanPackfiles returns the packfiles of the store of repo which no
+// state references, such as those written by an interrupted sync before
+// it could push its state.
+func orphanPackfiles(repo *repository.Repository) ([]objects.MAC, error) {
+	known := make(map[objects.MAC]struct{})
+	for packfileMAC := range repo.ListPackfiles() {
+		known[packfileMAC] = struct{}{}
+	}
+
+	packfiles, err := repo.GetPackfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []objects.MAC
+	for _, packfileMAC := range packfiles {
+		if _, found := known[packfileMAC]; found {
+			continue
+		}
+		// being removed by a maintenance
+		if deleted, err := repo.HasDeletedPackfile(packfileMAC); err != nil {
+			return nil, err
+		} else if deleted {
+			continue
+		}
+		orphans = append(orphans, packfileMAC)
+	}
+	return orphans, nil
+}
+
+// resume pushes a state for the orphan packfiles of the destination,
+// so that the blobs an interrupted sync already sent are known and not
+// sent again.  It returns the number of packfiles recovered.
+func resume(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	orphans, err := orphanPackfiles(repo)
+	if err != nil || len(orphans) == 0 {
+		return 0, err
+	}
+
+	stateID := objects.RandomMAC()
+	scanCache, err := repo.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return 0, err
+	}
+	defer scanCache.Close()
+
+	deltaState, err := state.NewLocalState(scanCache)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, packfileMAC := range orphans {
+		p, err := repo.GetPackfile(packfileMAC)
+		if err != nil {
+			return 0, err
+		}
+
+		if deltaState.Metadata.Timestamp.UnixNano() > p.Footer.Timestamp {
+			deltaState.Metadata.Timestamp = time.Unix(0, p.Footer.Timestamp)
+		}
+
+		for _, entry := range p.Index {
+			delta := &state.DeltaEntry{
+				Type:    entry.Type,
+				Version: entry.Version,
+				Blob:    entry.MAC,
+				Location: state.Location{
+					Packfile: packfileMAC,
+					Offset:   entry.Offset,
+					Length:   entry.Length,
+				},
+			}
+			if err := deltaState.PutDelta(delta); err != nil {
+				return 0, err
+			}
+		}
+
+		if err := deltaState.PutPackfile(stateID, packfileMAC); err != nil {
+			return 0, err
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+
+		if err := deltaState.SerializeToStream(pw); err != nil {
+			pw.CloseWithError(err)
+		}
+	}()
+	if err := repo.PutState(stateID, pr); err != nil {
+		return 0, err
+	}
+
+	if err := stateRefresher(ctx, repo)(stateID, false); err != nil {
+		return 0, err
+	}
+	return len(orphans), nil
+}
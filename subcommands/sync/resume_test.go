@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// interruptedSync leaves in the store of repo a packfile holding data, as
+// a sync killed before pushing its state would.
+func interruptedSync(t *testing.T, repo *repository.Repository, data []byte) objects.MAC {
+	id := objects.RandomMAC()
+	scanCache, err := repo.AppContext().GetCache().Scan(id)
+	require.NoError(t, err)
+	defer scanCache.Close()
+
+	mac := repo.ComputeMAC(data)
+	writer := repo.NewRepositoryWriter(scanCache, id, repository.DefaultType, "")
+	require.NoError(t, writer.PutBlob(resources.RT_CHUNK, mac, data, false))
+	writer.PackerManager.Wait()
+	return mac
+}
+
+func TestOrphanPackfiles(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+
+	orphans, err := orphanPackfiles(fixture.peerRepo)
+	require.NoError(t, err)
+	require.Empty(t, orphans)
+
+	chunkMAC := interruptedSync(t, fixture.peerRepo, []byte("hello foo"))
+	require.NoError(t, fixture.peerRepo.RebuildState())
+	require.False(t, fixture.peerRepo.BlobExists(resources.RT_CHUNK, chunkMAC))
+
+	orphans, err = orphanPackfiles(fixture.peerRepo)
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+}
+
+func TestSyncResumeSkipsSentChunks(t *testing.T) {
+	fixture := setupSync(t, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, fixture.localRepo, mockFiles)
+	defer snap.Close()
+
+	// the peer got the content of a file before the sync was killed
+	interruptedSync(t, fixture.peerRepo, []byte("hello foo"))
+
+	fixture.output.Reset()
+	runSync(t, fixture, []string{"-dry-run", "to", fixture.peerArg})
+	require.Contains(t, fixture.output.String(), "1 packfiles of an interrupted sync would be reused")
+
+	fixture.output.Reset()
+	runSync(t, fixture, []string{"to", fixture.peerArg})
+	require.Contains(t, fixture.output.String(), "resuming: 1 packfiles of an interrupted sync found")
+	require.Contains(t, snapshotIDs(t, fixture.peerRepo), snap.Header.Identifier)
+
+	// and it is now part of the state of the peer
+	orphans, err := orphanPackfiles(fixture.peerRepo)
+	require.NoError(t, err)
+	require.Empty(t, orphans)
+
+	// nothing left to recover
+	fixture.output.Reset()
+	runSync(t, fixture, []string{"to", fixture.peerArg})
+	require.NotContains(t, fixture.output.String(), "resuming")
+}
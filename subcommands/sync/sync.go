@@ -19,7 +19,12 @@ package sync
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/PlakarKorp/go-human2duration"
 
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/encryption"
@@ -30,8 +35,10 @@ import (
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/ratelimit"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
+	"golang.org/x/sync/errgroup"
 )
 
 type Sync struct {
@@ -43,6 +50,9 @@ type Sync struct {
 	Direction           string
 	PackfileTempStorage string
 	Cache               string
+	DryRun              bool
+	MaxDelete           int
+	MinAge              time.Duration
 
 	SrcLocateOptions *locate.LocateOptions
 }
@@ -51,49 +61,94 @@ func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &Sync{} }, 0, "sync")
 }
 
-func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
+// parseArgs parses and validates the arguments, without acting on them.
+func (cmd *Sync) parseArgs(flags *flag.FlagSet, args []string) (snapshotID, direction, peerRepositoryPath string, err error) {
 	cmd.SrcLocateOptions = locate.NewDefaultLocateOptions()
 
-	flags := flag.NewFlagSet("sync", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [SNAPSHOT] to REPOSITORY\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s [SNAPSHOT] from REPOSITORY\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "       %s [SNAPSHOT] with REPOSITORY\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [SNAPSHOT] mirror REPOSITORY\n", flags.Name())
 		flags.PrintDefaults()
 	}
 
 	cmd.SrcLocateOptions.InstallLocateFlags(flags)
 	flags.StringVar(&cmd.PackfileTempStorage, "packfiles", "", "memory or a path to a directory to store temporary packfiles")
 	flags.StringVar(&cmd.Cache, "cache", "vfs", "path to store vfs cache, 'no' for uncached and 'vfs' for the default in memory cache")
+	flags.BoolVar(&cmd.DryRun, "dry-run", false, "print the snapshots to synchronize and the data to transfer, without synchronizing")
+	flags.IntVar(&cmd.MaxDelete, "max-delete", 10, "mirror: never remove more than this percentage of the destination snapshots in one run")
+	flags.Func("min-age", "mirror: never remove destination snapshots younger than this duration (e.g. 7d)", func(value string) error {
+		d, err := human2duration.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		cmd.MinAge = d
+		return nil
+	})
 
-	flags.Parse(args)
+	if err := flags.Parse(args); err != nil {
+		return "", "", "", err
+	}
 
 	if flags.NArg() > 3 {
-		return fmt.Errorf("too many arguments")
+		return "", "", "", fmt.Errorf("too many arguments")
 	}
 
-	direction := ""
-	peerRepositoryPath := ""
-
 	args = flags.Args()
 	switch len(args) {
 	case 2:
 		direction = args[0]
 		peerRepositoryPath = args[1]
 	case 3:
-		if !cmd.SrcLocateOptions.Empty() {
-			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
-		}
-		cmd.SrcLocateOptions.Filters.IDs = []string{args[0]}
+		snapshotID = args[0]
 		direction = args[1]
 		peerRepositoryPath = args[2]
 
 	default:
-		return fmt.Errorf("usage: sync [SNAPSHOT] to|from|with REPOSITORY")
+		return "", "", "", fmt.Errorf("usage: sync [SNAPSHOT] to|from|with|mirror REPOSITORY")
+	}
+
+	if direction != "to" && direction != "from" && direction != "with" && direction != "mirror" {
+		return "", "", "", fmt.Errorf("invalid direction, must be to, from, with or mirror")
+	}
+
+	if direction != "mirror" {
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "max-delete" || f.Name == "min-age" {
+				err = fmt.Errorf("-%s only applies to mirror", f.Name)
+			}
+		})
+		if err != nil {
+			return "", "", "", err
+		}
 	}
+	if cmd.MaxDelete < 0 || cmd.MaxDelete > 100 {
+		return "", "", "", fmt.Errorf("-max-delete must be a percentage between 0 and 100")
+	}
+
+	return snapshotID, direction, peerRepositoryPath, nil
+}
+
+// CheckArgs validates the arguments of a synchronization without running
+// it, the peer store is not opened.
+func (cmd *Sync) CheckArgs(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	_, _, _, err := cmd.parseArgs(flags, args)
+	return err
+}
 
-	if direction != "to" && direction != "from" && direction != "with" {
-		return fmt.Errorf("invalid direction, must be to, from or with")
+func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
+	snapshotID, direction, peerRepositoryPath, err := cmd.parseArgs(flag.NewFlagSet("sync", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	if snapshotID != "" {
+		if !cmd.SrcLocateOptions.Empty() {
+			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
+		}
+		cmd.SrcLocateOptions.Filters.IDs = []string{snapshotID}
 	}
 
 	storeConfig, err := ctx.Config.GetRepository(peerRepositoryPath)
@@ -101,7 +156,7 @@ func (cmd *Sync) Parse(ctx *appcontext.AppContext, args []string) error {
 		return fmt.Errorf("peer store: %w", err)
 	}
 
-	peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+	peerStore, peerStoreSerializedConfig, err := ratelimit.Open(ctx.GetInner(), storeConfig, ctx.Limits)
 	if err != nil {
 		return err
 	}
@@ -177,7 +232,7 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		return 1, fmt.Errorf("peer store: %w", err)
 	}
 
-	peerStore, peerStoreSerializedConfig, err := storage.Open(ctx.GetInner(), storeConfig)
+	peerStore, peerStoreSerializedConfig, err := ratelimit.Open(ctx.GetInner(), storeConfig, ctx.Limits)
 	if err != nil {
 		return 1, fmt.Errorf("could not open peer store %s: %w", cmd.PeerRepositoryLocation, err)
 	}
@@ -231,7 +286,7 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 
 	srcStoreConfig := ctx.StoreConfig
 	switch cmd.Direction {
-	case "to":
+	case "to", "mirror":
 		srcRepository = repo
 		dstRepository = peerRepository
 	case "from":
@@ -245,7 +300,7 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		srcRepository = repo
 		dstRepository = peerRepository
 	default:
-		return 1, fmt.Errorf("could not synchronize %s: invalid direction, must be to, from or with", cmd.PeerRepositoryLocation)
+		return 1, fmt.Errorf("could not synchronize %s: invalid direction, must be to, from, with or mirror", cmd.PeerRepositoryLocation)
 	}
 
 	srcLocation := srcRepository.Origin()
@@ -253,6 +308,7 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 
 	srcSnapshotsMap := make(map[objects.MAC]struct{})
 	dstSnapshotsMap := make(map[objects.MAC]struct{})
+	dstSnapshotsList := make([]objects.MAC, 0)
 
 	for objMAC, err := range srcRepository.ListSnapshots() {
 		if err != nil {
@@ -266,10 +322,12 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 			return 1, err
 		}
 		dstSnapshotsMap[objMAC] = struct{}{}
+		dstSnapshotsList = append(dstSnapshotsList, objMAC)
 	}
 
 	srcSyncList := make([]objects.MAC, 0)
 
+	utils.IgnoreCheckpoints(cmd.SrcLocateOptions)
 	srcSnapshotIDs, err := locate.LocateSnapshotIDs(srcRepository, cmd.SrcLocateOptions)
 	if err != nil {
 		return 1, fmt.Errorf("could not locate snapshots in store %s: %s", dstLocation, err)
@@ -287,18 +345,23 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		}
 	}
 
-	srcSynced := 0
-	for _, snapshotID := range srcSyncList {
-		if err := ctx.Err(); err != nil {
+	var srcSynced int
+	if cmd.DryRun {
+		if err := dryRun(ctx, srcRepository, dstRepository, srcSyncList); err != nil {
 			return 1, err
 		}
-
-		err := cmd.synchronize(ctx, peerCtx, srcRepository, dstRepository, srcStoreConfig, snapshotID)
+		if cmd.Direction == "mirror" {
+			if _, err := cmd.mirror(ctx, dstRepository, dstSnapshotsList, srcSnapshotIDs); err != nil {
+				return 1, err
+			}
+		}
+		if cmd.Direction != "with" {
+			return 0, nil
+		}
+	} else {
+		srcSynced, err = cmd.syncSnapshots(ctx, peerCtx, srcRepository, dstRepository, srcStoreConfig, srcSyncList)
 		if err != nil {
-			ctx.GetLogger().Error("failed to synchronize snapshot %x from store %s: %s",
-				snapshotID[:4], srcLocation, err)
-		} else {
-			srcSynced++
+			return 1, err
 		}
 	}
 
@@ -324,19 +387,16 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 			}
 		}
 
-		dstSynced := 0
-		for _, snapshotID := range dstSyncList {
-			if err := ctx.Err(); err != nil {
+		if cmd.DryRun {
+			if err := dryRun(ctx, srcRepository, dstRepository, dstSyncList); err != nil {
 				return 1, err
 			}
+			return 0, nil
+		}
 
-			err := cmd.synchronize(ctx, peerCtx, srcRepository, dstRepository, srcStoreConfig, snapshotID)
-			if err != nil {
-				ctx.GetLogger().Error("failed to synchronize snapshot %x from peer store %s: %s",
-					snapshotID[:4], dstLocation, err)
-			} else {
-				dstSynced++
-			}
+		dstSynced, err := cmd.syncSnapshots(ctx, peerCtx, srcRepository, dstRepository, srcStoreConfig, dstSyncList)
+		if err != nil {
+			return 1, err
 		}
 		ctx.GetLogger().Info("sync: synchronization between %s and %s completed: %d snapshots synchronized",
 			srcLocation,
@@ -347,6 +407,22 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 			srcLocation,
 			dstLocation,
 			srcSynced)
+	case "mirror":
+		// a snapshot that failed to synchronize leaves the destination
+		// behind, it isn't the time to remove what it still has
+		if srcSynced != len(srcSyncList) {
+			return 1, fmt.Errorf("not removing snapshots from %s: %d snapshots failed to synchronize",
+				dstLocation, len(srcSyncList)-srcSynced)
+		}
+		removed, err := cmd.mirror(ctx, dstRepository, dstSnapshotsList, srcSnapshotIDs)
+		if err != nil {
+			return 1, err
+		}
+		ctx.GetLogger().Info("sync: mirror of %s to %s completed: %d snapshots synchronized, %d removed",
+			srcLocation,
+			dstLocation,
+			srcSynced,
+			removed)
 	default:
 		ctx.GetLogger().Info("sync: synchronization from %s to %s completed: %d snapshots synchronized",
 			dstLocation,
@@ -357,6 +433,70 @@ func (cmd *Sync) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 	return 0, nil
 }
 
+// syncSnapshots synchronizes snapshotIDs from srcRepository to
+// dstRepository, several at once within the concurrency budget, and
+// returns how many were.  A failure is reported and doesn't stop the
+// others.
+func (cmd *Sync) syncSnapshots(ctx, peerCtx *appcontext.AppContext, srcRepository, dstRepository *repository.Repository, srcStoreConfig map[string]string, snapshotIDs []objects.MAC) (int, error) {
+	if len(snapshotIDs) == 0 {
+		return 0, nil
+	}
+
+	if n, err := resume(peerCtx, dstRepository); err != nil {
+		ctx.GetLogger().Warn("failed to recover the packfiles of an interrupted sync on %s: %s", dstRepository.Origin(), err)
+	} else if n != 0 {
+		ctx.GetLogger().Info("resuming: %d packfiles of an interrupted sync found on %s", n, dstRepository.Origin())
+	}
+
+	// each snapshot packs its packfiles with as many workers as the
+	// concurrency of its repository, split the budget between them.
+	workers, share := splitConcurrency(ctx.MaxConcurrency, len(snapshotIDs))
+	dstStore := dstRepository.Store()
+	dstSerializedConfig, err := dstStore.Open(peerCtx)
+	if err != nil {
+		return 0, fmt.Errorf("could not open store %s: %w", dstRepository.Origin(), err)
+	}
+	dstCtx := *dstRepository.AppContext()
+	dstCtx.MaxConcurrency = share
+
+	var synced atomic.Int64
+	wg := new(errgroup.Group)
+	wg.SetLimit(workers)
+	for _, snapshotID := range snapshotIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Go(func() error {
+			dst, err := repository.NewNoRebuild(&dstCtx, peerCtx.GetSecret(), dstStore, dstSerializedConfig, true)
+			if err == nil {
+				defer dst.Close()
+				err = cmd.synchronize(ctx, peerCtx, srcRepository, dst, srcStoreConfig, snapshotID)
+			}
+			if err != nil {
+				ctx.GetLogger().Error("failed to synchronize snapshot %x from store %s: %s",
+					snapshotID[:4], srcRepository.Origin(), err)
+			} else {
+				synced.Add(1)
+			}
+			return nil
+		})
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return int(synced.Load()), err
+	}
+	return int(synced.Load()), nil
+}
+
+// splitConcurrency splits budget between n snapshots: it returns how
+// many are synchronized at once and the concurrency each one gets.
+func splitConcurrency(budget, n int) (workers, share int) {
+	workers = max(1, min(budget, n))
+	share = max(1, budget/workers)
+	return workers, share
+}
+
 func (cmd *Sync) synchronize(ctx, peerCtx *appcontext.AppContext, srcRepository, dstRepository *repository.Repository, srcStoreConfig map[string]string, snapshotID objects.MAC) error {
 	srcLocation := srcRepository.Origin()
 	dstLocation := dstRepository.Origin()
@@ -368,6 +508,11 @@ func (cmd *Sync) synchronize(ctx, peerCtx *appcontext.AppContext, srcRepository,
 	}
 	defer srcSnapshot.Close()
 
+	if len(srcSnapshot.Header.Sources) > 1 {
+		return fmt.Errorf("snapshot %x has %d sources, synchronizing multi-source snapshots is not supported yet",
+			snapshotID[:4], len(srcSnapshot.Header.Sources))
+	}
+
 	dstSnapshot, err := snapshot.Create(dstRepository, repository.DefaultType, cmd.PackfileTempStorage, srcSnapshot.Header.Identifier, &snapshot.BuilderOptions{
 		NoCommit:       false,
 		NoCheckpoint:   false,
@@ -0,0 +1,298 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package tag
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/google/uuid"
+)
+
+// Tag edits the metadata of snapshots.  A header can't be modified in
+// place, so each snapshot is replaced by a copy with the edited header,
+// sharing its content, and gets a new identifier.
+type Tag struct {
+	subcommands.SubcommandBase
+
+	Add        []string
+	Remove     []string
+	Fields     map[string]string
+	Annotate   map[string]string
+	Unannotate []string
+
+	Snapshots []string
+}
+
+// fields are the header fields which can be set, they are those of the
+// backup flags.
+var fields = []string{"name", "category", "environment", "perimeter", "job"}
+
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlags) Set(value string) error {
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Tag{} }, 0, "tag")
+}
+
+func (cmd *Tag) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.Fields = make(map[string]string)
+	cmd.Annotate = make(map[string]string)
+
+	var add, remove, unannotate listFlags
+	values := make(map[string]*string)
+
+	flags := flag.NewFlagSet("tag", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.Var(&add, "add", "comma-separated list of tags to add, can be specified multiple times")
+	flags.Var(&remove, "remove", "comma-separated list of tags to remove, can be specified multiple times")
+	for _, field := range fields {
+		values[field] = flags.String(field, "", "set the "+field+" of the snapshot")
+	}
+	flags.Var(utils.NewOptsFlag(cmd.Annotate), "annotate", "set an annotation as key=value, can be specified multiple times")
+	flags.Var(&unannotate, "unannotate", "comma-separated list of annotations to remove, can be specified multiple times")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("at least one snapshot is required")
+	}
+
+	// only the fields given are changed, they may be set to ""
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := values[f.Name]; ok {
+			cmd.Fields[f.Name] = *value
+		}
+	})
+
+	for _, tag := range add {
+		if slices.Contains(remove, tag) {
+			return fmt.Errorf("tag %q both added and removed", tag)
+		}
+	}
+	for key := range cmd.Annotate {
+		if key == "" {
+			return fmt.Errorf("empty annotation key")
+		}
+		if slices.Contains(unannotate, key) {
+			return fmt.Errorf("annotation %q both set and removed", key)
+		}
+	}
+	cmd.Add = add
+	cmd.Remove = remove
+	cmd.Unannotate = unannotate
+	if len(cmd.Add) == 0 && len(cmd.Remove) == 0 && len(cmd.Fields) == 0 &&
+		len(cmd.Annotate) == 0 && len(cmd.Unannotate) == 0 {
+		return fmt.Errorf("nothing to change")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Snapshots = flags.Args()
+
+	return nil
+}
+
+// edit applies the changes to hdr, it returns whether any was needed.
+func (cmd *Tag) edit(hdr *header.Header) bool {
+	changed := false
+
+	for _, tag := range cmd.Add {
+		if !hdr.HasTag(tag) {
+			hdr.Tags = append(hdr.Tags, tag)
+			changed = true
+		}
+	}
+	if n := len(hdr.Tags); n != 0 {
+		hdr.Tags = slices.DeleteFunc(hdr.Tags, func(tag string) bool {
+			return slices.Contains(cmd.Remove, tag)
+		})
+		changed = changed || len(hdr.Tags) != n
+	}
+
+	for field, value := range cmd.Fields {
+		var p *string
+		switch field {
+		case "name":
+			p = &hdr.Name
+		case "category":
+			p = &hdr.Category
+		case "environment":
+			p = &hdr.Environment
+		case "perimeter":
+			p = &hdr.Perimeter
+		case "job":
+			p = &hdr.Job
+		}
+		if *p != value {
+			*p = value
+			changed = true
+		}
+	}
+
+	for key, value := range cmd.Annotate {
+		if utils.SetAnnotation(hdr, key, value) {
+			changed = true
+		}
+	}
+	for _, key := range cmd.Unannotate {
+		if utils.DeleteAnnotation(hdr, key) {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// Edit replaces snap by a copy whose header is changed by edit, which
+// reports whether it changed anything.  It returns the identifier of the
+// copy, or that of snap if nothing changed.
+func Edit(ctx *appcontext.AppContext, repo *repository.Repository, snap *snapshot.Snapshot, edit func(*header.Header) bool) (objects.MAC, error) {
+	src := snap.Header
+
+	hdr := *src
+	hdr.Tags = slices.Clone(src.Tags)
+	hdr.Context = slices.Clone(src.Context)
+	if !edit(&hdr) {
+		return src.Identifier, nil
+	}
+
+	// the copy is signed again with the current key, which must then be
+	// that of the identity which signed the snapshot
+	if src.Identity.Identifier != uuid.Nil {
+		kp := ctx.GetInner().Keypair
+		if kp == nil || !bytes.Equal(kp.PublicKey, src.Identity.PublicKey) {
+			return objects.MAC{}, fmt.Errorf("snapshot is signed, editing it requires the key of its identity")
+		}
+	}
+
+	packingStrategy := repository.DefaultType
+	if repo.Type() == "ptar" {
+		packingStrategy = repository.PtarType
+	}
+
+	// the copy is written through a writer of its own rather than with
+	// Commit, which would reset its duration to that of the edit
+	stateID := objects.RandomMAC()
+	scanCache, err := repo.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+	defer scanCache.Close()
+
+	writer := repo.NewRepositoryWriter(scanCache, stateID, packingStrategy, "")
+	builder, err := snapshot.CreateWithRepositoryWriter(writer, &snapshot.BuilderOptions{
+		NoCheckpoint: true,
+		NoCommit:     true,
+	}, objects.NilMac)
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	hdr.Identifier = builder.Header.Identifier
+	builder.Header = &hdr
+
+	if _, err := builder.PutSnapshot(); err != nil {
+		writer.PackerManager.Wait()
+		builder.Close()
+		return objects.MAC{}, err
+	}
+
+	writer.PackerManager.Wait()
+	if err := writer.CommitTransaction(stateID); err != nil {
+		builder.Close()
+		return objects.MAC{}, err
+	}
+
+	// the hold of the snapshot protects its copy
+	hold, err := utils.LoadHold(repo, src.Identifier, time.Now())
+	if err == nil && hold != nil {
+		err = utils.PutHold(repo, builder.Header.Identifier, hold)
+	}
+	if err != nil {
+		builder.Close()
+		return builder.Header.Identifier, fmt.Errorf("failed to carry the hold over: %w", err)
+	}
+
+	if err := repo.DeleteSnapshot(src.Identifier); err != nil {
+		builder.Close()
+		return builder.Header.Identifier, fmt.Errorf("failed to remove the previous snapshot: %w", err)
+	}
+
+	// the next snapshot edited forks another builder
+	return builder.Header.Identifier, utils.ReleaseBuilder(repo, builder)
+}
+
+func (cmd *Tag) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	errors := 0
+	for _, snapshotID := range cmd.Snapshots {
+		snap, _, err := locate.OpenSnapshotByPath(repo, snapshotID)
+		if err != nil {
+			ctx.GetLogger().Error("tag: %s: %s", snapshotID, err)
+			errors++
+			continue
+		}
+
+		oldID := snap.Header.Identifier
+		newID, err := Edit(ctx, repo, snap, cmd.edit)
+		snap.Close()
+		if err != nil {
+			ctx.GetLogger().Error("tag: %x: %s", oldID[:4], err)
+			errors++
+			if newID == (objects.MAC{}) {
+				continue
+			}
+		}
+
+		if newID == oldID {
+			ctx.GetLogger().Info("tag: %x: unchanged", oldID[:4])
+			continue
+		}
+		fmt.Fprintf(ctx.Stdout, "%x: %x\n", oldID, newID)
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("failed to edit %d snapshots", errors)
+	}
+	return 0, nil
+}
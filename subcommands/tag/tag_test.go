@@ -0,0 +1,277 @@
+package tag
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/encryption/keypair"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/subcommands"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagRegisteredFactory(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"tag"})
+	require.NotNil(t, cmd)
+	require.IsType(t, &Tag{}, cmd)
+}
+
+func TestTagParse(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Tag{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-add", "x"}), "at least one snapshot is required")
+
+	cmd = &Tag{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"abcd"}), "nothing to change")
+
+	cmd = &Tag{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-add", "x", "-remove", "y,x", "abcd"}),
+		`tag "x" both added and removed`)
+
+	cmd = &Tag{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-annotate", "k=v", "-unannotate", "k", "abcd"}),
+		`annotation "k" both set and removed`)
+
+	cmd = &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "a,b", "-add", "c", "-remove", "d",
+		"-name", "renamed", "-job", "", "-annotate", "ticket=OPS-1", "-unannotate", "old",
+		"abcd", "ef01"}))
+	require.Equal(t, []string{"a", "b", "c"}, cmd.Add)
+	require.Equal(t, []string{"d"}, cmd.Remove)
+	require.Equal(t, map[string]string{"name": "renamed", "job": ""}, cmd.Fields)
+	require.Equal(t, map[string]string{"ticket": "OPS-1"}, cmd.Annotate)
+	require.Equal(t, []string{"old"}, cmd.Unannotate)
+	require.Equal(t, []string{"abcd", "ef01"}, cmd.Snapshots)
+}
+
+func TestTagEdit(t *testing.T) {
+	cmd := &Tag{
+		Add:        []string{"b", "c"},
+		Remove:     []string{"a"},
+		Fields:     map[string]string{"environment": "prod"},
+		Annotate:   map[string]string{"ticket": "OPS-1"},
+		Unannotate: []string{"old"},
+	}
+
+	hdr := &header.Header{Tags: []string{"a", "b"}, Environment: "default"}
+	utils.SetAnnotation(hdr, "old", "value")
+	require.True(t, cmd.edit(hdr))
+	require.Equal(t, []string{"b", "c"}, hdr.Tags)
+	require.Equal(t, "prod", hdr.Environment)
+	require.Equal(t, []header.KeyValue{{Key: "ticket", Value: "OPS-1"}}, utils.Annotations(hdr))
+
+	// applied twice, nothing changes
+	require.False(t, cmd.edit(hdr))
+}
+
+func listSnapshots(t *testing.T, repo *repository.Repository) []objects.MAC {
+	require.NoError(t, repo.RebuildState())
+	var ids []objects.MAC
+	for id, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestTagExecute(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/a.txt", 0644, "hello"),
+	}, ptesting.WithName("mistagged"), ptesting.WithTimestamp(at))
+	oldID := snap.Header.Identifier
+	oldHeader := *snap.Header
+	snap.Close()
+
+	cmd := &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "daily,prod", "-name", "web",
+		"-environment", "production", "-annotate", "ticket=OPS-1",
+		hex.EncodeToString(oldID[:4])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	ids := listSnapshots(t, repo)
+	require.Len(t, ids, 1)
+	newID := ids[0]
+	require.NotEqual(t, oldID, newID)
+	require.Equal(t, fmt.Sprintf("%x: %x\n", oldID, newID), bufOut.String())
+
+	snap, err = snapshot.Load(repo, newID)
+	require.NoError(t, err)
+	defer snap.Close()
+	require.Equal(t, "web", snap.Header.Name)
+	require.Equal(t, "production", snap.Header.Environment)
+	require.Equal(t, oldHeader.Category, snap.Header.Category)
+	require.Equal(t, []string{"daily", "prod"}, snap.Header.Tags)
+	require.Equal(t, []header.KeyValue{{Key: "ticket", Value: "OPS-1"}}, utils.Annotations(snap.Header))
+
+	// what isn't edited is kept, the content is shared
+	require.True(t, at.Equal(snap.Header.Timestamp))
+	require.Equal(t, oldHeader.GetContext("Hostname"), snap.Header.GetContext("Hostname"))
+	require.Equal(t, oldHeader.Sources, snap.Header.Sources)
+
+	// nothing to change
+	bufOut.Reset()
+	cmd = &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "daily", hex.EncodeToString(newID[:])}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "unchanged")
+	require.Equal(t, []objects.MAC{newID}, listSnapshots(t, repo))
+
+	// removing
+	cmd = &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-remove", "prod", "-unannotate", "ticket", hex.EncodeToString(newID[:])}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	ids = listSnapshots(t, repo)
+	require.Len(t, ids, 1)
+	last, err := snapshot.Load(repo, ids[0])
+	require.NoError(t, err)
+	defer last.Close()
+	require.Equal(t, []string{"daily"}, last.Header.Tags)
+	require.Empty(t, utils.Annotations(last.Header))
+}
+
+func TestTagExecuteKeepsTimes(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	})
+	oldHeader := *snap.Header
+	snap.Close()
+	require.NotZero(t, oldHeader.Duration)
+
+	cmd := &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "x", hex.EncodeToString(oldHeader.Identifier[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	ids := listSnapshots(t, repo)
+	require.Len(t, ids, 1)
+	require.NotEqual(t, oldHeader.Identifier, ids[0])
+
+	snap, err = snapshot.Load(repo, ids[0])
+	require.NoError(t, err)
+	defer snap.Close()
+	require.Equal(t, []string{"x"}, snap.Header.Tags)
+	require.Equal(t, oldHeader.Duration, snap.Header.Duration)
+	require.True(t, oldHeader.Timestamp.Equal(snap.Header.Timestamp))
+}
+
+func TestTagExecuteHeld(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	}, ptesting.WithHold("case 42", time.Time{}))
+	oldID := snap.Header.Identifier
+	snap.Close()
+
+	cmd := &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "x", hex.EncodeToString(oldID[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	ids := listSnapshots(t, repo)
+	require.Len(t, ids, 1)
+	require.NotEqual(t, oldID, ids[0])
+
+	hold, err := utils.LoadHold(repo, ids[0], time.Now())
+	require.NoError(t, err)
+	require.Equal(t, &utils.Hold{Reason: "case 42"}, hold)
+}
+
+func TestTagExecuteBadSnapshot(t *testing.T) {
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	})
+	snap.Close()
+
+	cmd := &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "x", "deadbeefdeadbeef"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.EqualError(t, err, "failed to edit 1 snapshots")
+	require.Equal(t, 1, status)
+	require.Contains(t, bufErr.String(), "tag: deadbeefdeadbeef:")
+}
+
+func TestTagExecuteSigned(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	})
+	defer snap.Close()
+
+	// a signed copy of the snapshot
+	kp, err := keypair.Generate()
+	require.NoError(t, err)
+	ctx.GetInner().Keypair = kp
+	builder, err := snap.Fork(&snapshot.BuilderOptions{})
+	require.NoError(t, err)
+	builder.Header.Identity = header.Identity{Identifier: uuid.New(), PublicKey: kp.PublicKey}
+	require.NoError(t, builder.Commit())
+	builder.Close()
+	signedID := builder.Header.Identifier
+	require.NoError(t, repo.RebuildState())
+
+	// it can't be edited without the key
+	ctx.GetInner().Keypair = nil
+	cmd := &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "x", hex.EncodeToString(signedID[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Contains(t, bufErr.String(), "editing it requires the key of its identity")
+
+	// with it, the copy is signed again
+	ctx.GetInner().Keypair = kp
+	bufOut.Reset()
+	cmd = &Tag{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-add", "x", hex.EncodeToString(signedID[:])}))
+	bufErr.Reset()
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err, bufErr.String())
+	require.Equal(t, 0, status)
+
+	prefix := hex.EncodeToString(signedID[:]) + ": "
+	require.Contains(t, bufOut.String(), prefix)
+	newHex, _, _ := strings.Cut(bufOut.String()[strings.Index(bufOut.String(), prefix)+len(prefix):], "\n")
+	newID, err := hex.DecodeString(newHex)
+	require.NoError(t, err)
+
+	require.NoError(t, repo.RebuildState())
+	signed, err := snapshot.Load(repo, objects.MAC(newID))
+	require.NoError(t, err)
+	defer signed.Close()
+	require.Equal(t, []string{"x"}, signed.Header.Tags)
+	ok, err := signed.Verify()
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -11,6 +11,7 @@ import (
 
 type MockImporter struct {
 	location string
+	origin   string
 	files    map[string]MockFile
 
 	gen func(chan<- *connectors.Record)
@@ -21,8 +22,14 @@ func init() {
 }
 
 func NewMockImporter(appCtx context.Context, opts *connectors.Options, name string, config map[string]string) (importer.Importer, error) {
+	origin := config["origin"]
+	if origin == "" {
+		origin = "mock"
+	}
+
 	return &MockImporter{
 		location: config["location"],
+		origin:   origin,
 	}, nil
 
 }
@@ -54,7 +61,7 @@ func (p *MockImporter) SetGenerator(gen func(chan<- *connectors.Record)) {
 	p.gen = gen
 }
 
-func (p *MockImporter) Origin() string        { return "mock" }
+func (p *MockImporter) Origin() string        { return p.origin }
 func (p *MockImporter) Type() string          { return "mock" }
 func (p *MockImporter) Root() string          { return "/" }
 func (p *MockImporter) Flags() location.Flags { return 0 }
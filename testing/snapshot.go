@@ -7,6 +7,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/importer"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,6 +24,7 @@ type MockFile struct {
 	IsDir   bool
 	Mode    os.FileMode
 	Content []byte
+	ModTime time.Time
 }
 
 func NewMockDir(path string) MockFile {
@@ -58,6 +61,7 @@ func (m *MockFile) ScanResult() *connectors.Record {
 			Lname:      path.Base(m.Path),
 			Lsize:      int64(len(m.Content)),
 			Lmode:      m.Mode,
+			LmodTime:   m.ModTime,
 			Lnlink:     1,
 			Lusername:  "flan",
 			Lgroupname: "hacker",
@@ -74,10 +78,19 @@ func (m *MockFile) ScanResult() *connectors.Record {
 	}
 }
 
+type mockSource struct {
+	origin string
+	files  []MockFile
+}
+
 type testingOptions struct {
 	name     string
 	excludes []string
 	gen      func(chan<- *connectors.Record)
+	sources  []mockSource
+	at       time.Time
+	hold     *utils.Hold
+	tags     []string
 }
 
 func newTestingOptions() *testingOptions {
@@ -100,6 +113,39 @@ func WithExcludes(excludes []string) TestingOptions {
 	}
 }
 
+// WithTimestamp forces the timestamp of the snapshot.
+func WithTimestamp(at time.Time) TestingOptions {
+	return func(o *testingOptions) {
+		o.at = at
+	}
+}
+
+// WithHold places a hold on the snapshot, until is zero for a hold
+// without an end.
+func WithHold(reason string, until time.Time) TestingOptions {
+	return func(o *testingOptions) {
+		o.hold = &utils.Hold{Reason: reason, Until: until}
+	}
+}
+
+// WithTags tags the snapshot.
+func WithTags(tags ...string) TestingOptions {
+	return func(o *testingOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// WaitUnlocked waits for the locks of repo to be released, for the tests
+// running a command which closes its snapshot builder without waiting, see
+// utils.ReleaseBuilder.
+func WaitUnlocked(t *testing.T, repo *repository.Repository) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		locks, err := repo.GetLocks()
+		return err == nil && len(locks) == 0
+	}, 5*time.Second, 10*time.Millisecond, "the locks of the store must be released")
+}
+
 func GenerateFiles(t *testing.T, files []MockFile) string {
 	tmpBackupDir, err := os.MkdirTemp("", "tmp_to_backup")
 	require.NoError(t, err)
@@ -126,6 +172,14 @@ func WithGenerator(gen func(chan<- *connectors.Record)) TestingOptions {
 	}
 }
 
+// WithSource adds a source imported from origin to the snapshot, after the
+// default one.
+func WithSource(origin string, files []MockFile) TestingOptions {
+	return func(o *testingOptions) {
+		o.sources = append(o.sources, mockSource{origin: origin, files: files})
+	}
+}
+
 func GenerateSnapshot(t *testing.T, repo *repository.Repository, files []MockFile, opts ...TestingOptions) *snapshot.Snapshot {
 	o := newTestingOptions()
 	for _, f := range opts {
@@ -134,7 +188,8 @@ func GenerateSnapshot(t *testing.T, repo *repository.Repository, files []MockFil
 
 	// create a snapshot
 	builder, err := snapshot.Create(repo, repository.DefaultType, "", objects.NilMac, &snapshot.BuilderOptions{
-		Name: o.name,
+		Name:            o.name,
+		ForcedTimestamp: o.at,
 	})
 	require.NoError(t, err)
 	require.NotNil(t, builder)
@@ -159,15 +214,38 @@ func GenerateSnapshot(t *testing.T, repo *repository.Repository, files []MockFil
 	err = builder.Backup(s)
 	require.NoError(t, err)
 
+	for _, src := range o.sources {
+		imp, err := NewMockImporter(repo.AppContext(), &connectors.Options{},
+			"mock", map[string]string{"location": "mock://" + src.origin, "origin": src.origin})
+		require.NoError(t, err)
+		imp.(*MockImporter).SetFiles(src.files)
+
+		s, err := snapshot.NewSource(repo.AppContext(), imp)
+		require.NoError(t, err)
+
+		err = s.SetExcludes(o.excludes)
+		require.NoError(t, err)
+
+		err = builder.Backup(s)
+		require.NoError(t, err)
+	}
+
+	builder.Header.Tags = append(builder.Header.Tags, o.tags...)
+
 	err = builder.Commit()
 	require.NoError(t, err)
 
-	err = builder.Close()
+	err = utils.ReleaseBuilder(repo, builder)
 	require.NoError(t, err)
 
 	err = builder.Repository().RebuildState()
 	require.NoError(t, err)
 
+	if o.hold != nil {
+		err = utils.PutHold(repo, builder.Header.Identifier, o.hold)
+		require.NoError(t, err)
+	}
+
 	// reopen it
 	snap, err := snapshot.Load(repo, builder.Header.Identifier)
 	require.NoError(t, err)
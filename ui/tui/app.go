@@ -28,6 +28,8 @@ type Application struct {
 
 	debounceStat time.Time
 	lastStat     string
+	lastRead     int64
+	lastWrite    int64
 }
 
 type State struct {
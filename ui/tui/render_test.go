@@ -365,6 +365,16 @@ func TestHelperFormatBytes(t *testing.T) {
 	}
 }
 
+func TestHelperFmtThrottle(t *testing.T) {
+	t.Parallel()
+	if got := fmtThrottle(1024, 0); got != "" {
+		t.Fatalf("fmtThrottle without limit = %q", got)
+	}
+	if got := fmtThrottle(1000*1024, 1024*1024); got != " (1000 KiB/s, limited to 1.0 MiB/s)" {
+		t.Fatalf("fmtThrottle = %q", got)
+	}
+}
+
 func TestHelperErrAndFmtNewReuse(t *testing.T) {
 	t.Parallel()
 	if got := err(3); !strings.Contains(got, "3") {
@@ -69,6 +69,20 @@ func formatBytes(b int64) string {
 	return humanize.IBytes(uint64(b))
 }
 
+// limitedStore is implemented by the stores whose bandwidth is limited.
+type limitedStore interface {
+	Limits() (upload, download int64)
+}
+
+// fmtThrottle describes the transfer rate of a store limited to limit
+// bytes per second.
+func fmtThrottle(rate float64, limit int64) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s/s, limited to %s/s)", formatBytes(int64(rate)), formatBytes(limit))
+}
+
 func fmtNewReuse(okCount, total uint64, progress bool) string {
 	okS := okStyle.Render(fmt.Sprintf("%d", okCount))
 	totalS := dimStyle.Render(fmt.Sprintf("%d", total))
@@ -253,14 +267,30 @@ func (m appModel) View() string {
 			r := ioStats.Read.Stats()
 			w := ioStats.Write.Stats()
 
+			read := formatBytes(r.TotalBytes)
+			write := formatBytes(w.TotalBytes)
+			if store, ok := m.repo.Store().(limitedStore); ok {
+				var readRate, writeRate float64
+				if !m.application.debounceStat.IsZero() {
+					elapsed := time.Since(m.application.debounceStat).Seconds()
+					readRate = float64(r.TotalBytes-m.application.lastRead) / elapsed
+					writeRate = float64(w.TotalBytes-m.application.lastWrite) / elapsed
+				}
+				upload, download := store.Limits()
+				read += fmtThrottle(readRate, download)
+				write += fmtThrottle(writeRate, upload)
+			}
+
 			m.application.lastStat = fmt.Sprintf(
 				"%s    store: read=%s, write=%s\n",
 				indent,
-				formatBytes(r.TotalBytes),
-				formatBytes(w.TotalBytes),
+				read,
+				write,
 			)
 
 			m.application.debounceStat = time.Now()
+			m.application.lastRead = r.TotalBytes
+			m.application.lastWrite = w.TotalBytes
 		}
 
 		fmt.Fprint(&s, m.application.lastStat)
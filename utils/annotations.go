@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+)
+
+// Annotations are free-form key/value pairs attached to a snapshot.  They
+// are kept in the context of its header, their keys prefixed so they
+// don't clash with those recorded at backup time.
+const annotationPrefix = "annotation."
+
+// Annotations returns the annotations of a snapshot, in the order they
+// were set.
+func Annotations(hdr *header.Header) []header.KeyValue {
+	var annotations []header.KeyValue
+	for _, kv := range hdr.Context {
+		if key, found := strings.CutPrefix(kv.Key, annotationPrefix); found {
+			annotations = append(annotations, header.KeyValue{Key: key, Value: kv.Value})
+		}
+	}
+	return annotations
+}
+
+// SetAnnotation sets the annotation key of a snapshot to value.  It
+// returns whether the header changed.
+func SetAnnotation(hdr *header.Header, key, value string) bool {
+	for i := range hdr.Context {
+		if hdr.Context[i].Key == annotationPrefix+key {
+			if hdr.Context[i].Value == value {
+				return false
+			}
+			hdr.Context[i].Value = value
+			return true
+		}
+	}
+	hdr.Context = append(hdr.Context, header.KeyValue{Key: annotationPrefix + key, Value: value})
+	return true
+}
+
+// DeleteAnnotation removes the annotation key of a snapshot.  It returns
+// whether the header changed.
+func DeleteAnnotation(hdr *header.Header, key string) bool {
+	n := len(hdr.Context)
+	hdr.Context = slices.DeleteFunc(hdr.Context, func(kv header.KeyValue) bool {
+		return kv.Key == annotationPrefix+key
+	})
+	return len(hdr.Context) != n
+}
@@ -0,0 +1,30 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotations(t *testing.T) {
+	hdr := &header.Header{}
+	hdr.SetContext("Hostname", "host1")
+	require.Empty(t, utils.Annotations(hdr))
+
+	require.True(t, utils.SetAnnotation(hdr, "ticket", "OPS-1"))
+	require.True(t, utils.SetAnnotation(hdr, "Hostname", "not the context"))
+	require.False(t, utils.SetAnnotation(hdr, "ticket", "OPS-1"))
+	require.True(t, utils.SetAnnotation(hdr, "ticket", "OPS-2"))
+	require.Equal(t, []header.KeyValue{
+		{Key: "ticket", Value: "OPS-2"},
+		{Key: "Hostname", Value: "not the context"},
+	}, utils.Annotations(hdr))
+	require.Equal(t, "host1", hdr.GetContext("Hostname"))
+
+	require.True(t, utils.DeleteAnnotation(hdr, "Hostname"))
+	require.False(t, utils.DeleteAnnotation(hdr, "Hostname"))
+	require.Equal(t, []header.KeyValue{{Key: "ticket", Value: "OPS-2"}}, utils.Annotations(hdr))
+	require.Equal(t, "host1", hdr.GetContext("Hostname"))
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+)
+
+// LocateAt returns the newest snapshot, among the ones matching opts,
+// taken at or before at and holding pathname, along with the index of
+// the source pathname belongs to.  If selector is set, only the source
+// it designates is considered.  An empty pathname matches the first
+// source of any snapshot.  The checkpoints are skipped unless opts
+// selects them.
+func LocateAt(repo *repository.Repository, opts *locate.LocateOptions, at time.Time, pathname, selector string) (*snapshot.Snapshot, int, error) {
+	if opts == nil {
+		opts = locate.NewDefaultLocateOptions()
+	}
+
+	atOpts := *opts
+	atOpts.Filters.Before = at
+	atOpts.Filters.Latest = false
+	IgnoreCheckpoints(&atOpts)
+
+	snapshotIDs, err := locate.LocateSnapshotIDs(repo, &atOpts)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	// snapshots are sorted from the newest to the oldest
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		if idx := sourceHolding(snap.Header, pathname, selector); idx != -1 {
+			return snap, idx, nil
+		}
+		snap.Close()
+	}
+
+	if pathname == "" {
+		return nil, -1, fmt.Errorf("no snapshot found at or before %s", at.UTC().Format(time.RFC3339))
+	}
+	return nil, -1, fmt.Errorf("no snapshot of %s found at or before %s", pathname, at.UTC().Format(time.RFC3339))
+}
+
+func sourceHolding(hdr *header.Header, pathname, selector string) int {
+	first, last := 0, len(hdr.Sources)
+	if selector != "" {
+		idx, err := FindSource(hdr, selector)
+		if err != nil {
+			// the source may not exist in older snapshots
+			return -1
+		}
+		first, last = idx, idx+1
+	}
+
+	for i := first; i < last; i++ {
+		if pathname == "" || pathWithin(hdr.Sources[i].Importer.Directory, pathname) {
+			return i
+		}
+	}
+	return -1
+}
+
+func pathWithin(dir, pathname string) bool {
+	dir = path.Clean("/" + dir)
+	if dir == "/" {
+		return true
+	}
+	return pathname == dir || strings.HasPrefix(pathname, dir+"/")
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathWithin(t *testing.T) {
+	require.True(t, pathWithin("/", "/etc/passwd"))
+	require.True(t, pathWithin("", "/etc/passwd"))
+	require.True(t, pathWithin("/etc", "/etc"))
+	require.True(t, pathWithin("/etc/", "/etc/passwd"))
+	require.False(t, pathWithin("/etc", "/etcetera"))
+	require.False(t, pathWithin("/var", "/etc/passwd"))
+}
+
+func TestSourceHolding(t *testing.T) {
+	hdr := &header.Header{
+		Sources: []header.Source{
+			{Importer: header.Importer{Type: "fs", Origin: "host1", Directory: "/etc"}},
+			{Importer: header.Importer{Type: "fs", Origin: "host1", Directory: "/var"}},
+		},
+	}
+
+	require.Equal(t, 0, sourceHolding(hdr, "", ""))
+	require.Equal(t, 0, sourceHolding(hdr, "/etc/passwd", ""))
+	require.Equal(t, 1, sourceHolding(hdr, "/var/lib", ""))
+	require.Equal(t, -1, sourceHolding(hdr, "/srv", ""))
+
+	require.Equal(t, 1, sourceHolding(hdr, "", "/var"))
+	require.Equal(t, -1, sourceHolding(hdr, "/etc/passwd", "/var"))
+	require.Equal(t, -1, sourceHolding(hdr, "/etc/passwd", "nope"))
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"slices"
+
+	"github.com/PlakarKorp/kloset/locate"
+)
+
+// CheckpointTag marks the snapshots committed periodically by a backup
+// run with -resume, and when it is interrupted.  They hold what was backed
+// up so far and are used as the parent of the next pass or of the next
+// run with -resume, so that the files already done are neither read nor
+// uploaded again.
+const CheckpointTag = "plakar-checkpoint"
+
+// IgnoreCheckpoints makes opts skip the checkpoints, as they are partial
+// backups, unless they are explicitly selected by their tag or identifier.
+func IgnoreCheckpoints(opts *locate.LocateOptions) {
+	if len(opts.Filters.IDs) != 0 ||
+		slices.Contains(opts.Filters.Tags, CheckpointTag) ||
+		slices.Contains(opts.Filters.IgnoreTags, CheckpointTag) {
+		return
+	}
+	// opts may be a copy sharing its filters with the caller's
+	opts.Filters.IgnoreTags = append(slices.Clip(opts.Filters.IgnoreTags), CheckpointTag)
+}
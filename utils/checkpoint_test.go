@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreCheckpoints(t *testing.T) {
+	opts := locate.NewDefaultLocateOptions()
+	IgnoreCheckpoints(opts)
+	IgnoreCheckpoints(opts)
+	require.Equal(t, []string{CheckpointTag}, opts.Filters.IgnoreTags)
+
+	opts = locate.NewDefaultLocateOptions(locate.WithTag(CheckpointTag))
+	IgnoreCheckpoints(opts)
+	require.Empty(t, opts.Filters.IgnoreTags)
+
+	opts = locate.NewDefaultLocateOptions(locate.WithID("abcd"))
+	IgnoreCheckpoints(opts)
+	require.Empty(t, opts.Filters.IgnoreTags)
+
+	// a copy doesn't change the filters of the original
+	opts = locate.NewDefaultLocateOptions()
+	opts.Filters.IgnoreTags = make([]string, 1, 4)
+	opts.Filters.IgnoreTags[0] = "draft"
+	copied := *opts
+	IgnoreCheckpoints(&copied)
+	require.Equal(t, []string{"draft", CheckpointTag}, copied.Filters.IgnoreTags)
+	require.Equal(t, []string{"draft"}, opts.Filters.IgnoreTags)
+	require.Empty(t, opts.Filters.IgnoreTags[:2][1])
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+)
+
+// A legal hold protects a snapshot from removal.  It is kept in records
+// of its own keyed by the snapshot ID, so that placing or releasing it
+// leaves the snapshot untouched.  Records are never rewritten: each
+// change of the hold of a snapshot adds the next record, and the last one
+// holds the current state.
+//
+// kloset has no resource type for the records of its users, the hold
+// records are stored as random blobs, which it never reads, under
+// identifiers derived from the snapshot ID.
+const (
+	holdType = resources.RT_RANDOM
+
+	HOLD_VERSION = 1
+)
+
+type Hold struct {
+	Reason string
+	// Until is the end of the hold, zero if it has none.
+	Until time.Time
+}
+
+type holdRecord struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	// Reason is empty once the hold is released.
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// holdMAC returns the identifier of the n-th record of the hold of a
+// snapshot.
+func holdMAC(repo *repository.Repository, snapshotID objects.MAC, n uint32) objects.MAC {
+	buf := make([]byte, len(snapshotID)+4)
+	copy(buf, snapshotID[:])
+	binary.BigEndian.PutUint32(buf[len(snapshotID):], n)
+	return repo.ComputeMAC(buf)
+}
+
+// holdRecords returns the identifiers of the records of the hold of a
+// snapshot, oldest first.
+func holdRecords(repo *repository.Repository, snapshotID objects.MAC) []objects.MAC {
+	var records []objects.MAC
+	for n := uint32(0); ; n++ {
+		mac := holdMAC(repo, snapshotID, n)
+		if !repo.BlobExists(holdType, mac) {
+			return records
+		}
+		records = append(records, mac)
+	}
+}
+
+// LoadHold returns the hold of the snapshot snapshotID at now, nil if it
+// has none or if it expired.  The snapshot may have been removed.
+func LoadHold(repo *repository.Repository, snapshotID objects.MAC, now time.Time) (*Hold, error) {
+	records := holdRecords(repo, snapshotID)
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	data, err := repo.GetBlobBytes(holdType, records[len(records)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	var record holdRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid hold record: %w", err)
+	}
+	if record.Version > HOLD_VERSION {
+		return nil, fmt.Errorf("hold record version %d is newer than current version %d",
+			record.Version, HOLD_VERSION)
+	}
+
+	if record.Reason == "" {
+		return nil, nil
+	}
+	if !record.Until.IsZero() && !now.Before(record.Until) {
+		return nil, nil
+	}
+	return &Hold{Reason: record.Reason, Until: record.Until}, nil
+}
+
+// PutHold places a hold on the snapshot snapshotID, replacing any previous
+// one, or releases it if hold is nil.
+func PutHold(repo *repository.Repository, snapshotID objects.MAC, hold *Hold) error {
+	record := holdRecord{
+		Version:   HOLD_VERSION,
+		Timestamp: time.Now(),
+	}
+	if hold != nil {
+		record.Reason = hold.Reason
+		record.Until = hold.Until.UTC()
+	}
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := SharedLock(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stateID := objects.RandomMAC()
+	scanCache, err := repo.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return err
+	}
+	defer scanCache.Close()
+
+	mac := holdMAC(repo, snapshotID, uint32(len(holdRecords(repo, snapshotID))))
+	writer := repo.NewRepositoryWriter(scanCache, stateID, repository.DefaultType, "")
+	if err := writer.PutBlob(holdType, mac, data, false); err != nil {
+		writer.PackerManager.Wait()
+		return err
+	}
+	writer.PackerManager.Wait()
+	if err := writer.CommitTransaction(stateID); err != nil {
+		return err
+	}
+
+	// the next lookups see the new record
+	return repo.RebuildState()
+}
+
+// HoldPackfiles returns the packfiles holding the records of the hold of
+// a snapshot, which maintenance keeps along with those of the snapshot.
+func HoldPackfiles(repo *repository.Repository, snapshotID objects.MAC) ([]objects.MAC, error) {
+	var packfiles []objects.MAC
+	for _, mac := range holdRecords(repo, snapshotID) {
+		packfile, exists, err := repo.GetPackfileForBlob(holdType, mac)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			packfiles = append(packfiles, packfile)
+		}
+	}
+	return packfiles, nil
+}
+
+func (h *Hold) String() string {
+	reason := SanitizeText(h.Reason)
+	if h.Until.IsZero() {
+		return reason
+	}
+	return fmt.Sprintf("%s (until %s)", reason, h.Until.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,64 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHold(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("a.txt", 0644, "x"),
+	})
+	id := snap.Header.Identifier
+	hdr := *snap.Header
+	snap.Close()
+
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	hold, err := utils.LoadHold(repo, id, now)
+	require.NoError(t, err)
+	require.Nil(t, hold)
+
+	require.NoError(t, utils.PutHold(repo, id, &utils.Hold{Reason: "case 42"}))
+	hold, err = utils.LoadHold(repo, id, now)
+	require.NoError(t, err)
+	require.Equal(t, &utils.Hold{Reason: "case 42"}, hold)
+	require.Equal(t, "case 42", hold.String())
+
+	until := now.Add(24 * time.Hour)
+	require.NoError(t, utils.PutHold(repo, id, &utils.Hold{Reason: "case 42", Until: until}))
+	hold, err = utils.LoadHold(repo, id, now)
+	require.NoError(t, err)
+	require.Equal(t, &utils.Hold{Reason: "case 42", Until: until}, hold)
+	require.Equal(t, "case 42 (until 2026-10-18T12:00:00Z)", hold.String())
+	hold, err = utils.LoadHold(repo, id, until)
+	require.NoError(t, err)
+	require.Nil(t, hold)
+
+	// removing the end makes the hold permanent again
+	require.NoError(t, utils.PutHold(repo, id, &utils.Hold{Reason: "case 42"}))
+	hold, err = utils.LoadHold(repo, id, until)
+	require.NoError(t, err)
+	require.NotNil(t, hold)
+
+	packfiles, err := utils.HoldPackfiles(repo, id)
+	require.NoError(t, err)
+	require.Len(t, packfiles, 3)
+
+	require.NoError(t, utils.PutHold(repo, id, nil))
+	hold, err = utils.LoadHold(repo, id, now)
+	require.NoError(t, err)
+	require.Nil(t, hold)
+
+	// the snapshot itself is left untouched
+	loaded, err := snapshot.Load(repo, id)
+	require.NoError(t, err)
+	defer loaded.Close()
+	require.Equal(t, hdr.Duration, loaded.Header.Duration)
+	require.Empty(t, utils.Annotations(loaded.Header))
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// JSONVersion is the version of the JSON output of the commands.  It is
+// bumped whenever a field is removed or changes meaning, adding fields is
+// not considered a breaking change.
+const JSONVersion = 1
+
+// JSONRecord is what the commands output with -json, one per line.  Items
+// have the same shape as the ones returned by the HTTP API.
+type JSONRecord struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	Item    any    `json:"item"`
+}
+
+// SnapshotEntry locates an entry within a snapshot.
+type SnapshotEntry struct {
+	Snapshot header.Header `json:"snapshot"`
+	Entry    vfs.Entry     `json:"vfs_entry"`
+}
+
+type JSONWriter struct {
+	enc *json.Encoder
+}
+
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{enc: json.NewEncoder(w)}
+}
+
+// Write outputs item as a record of the given type on its own line.
+func (w *JSONWriter) Write(typ string, item any) error {
+	return w.enc.Encode(JSONRecord{
+		Version: JSONVersion,
+		Type:    typ,
+		Item:    item,
+	})
+}
@@ -0,0 +1,18 @@
+package utils_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	out := utils.NewJSONWriter(&buf)
+	require.NoError(t, out.Write("entry", map[string]int{"a": 1}))
+	require.NoError(t, out.Write("entry", map[string]int{"b": 2}))
+	require.Equal(t, `{"version":1,"type":"entry","item":{"a":1}}`+"\n"+
+		`{"version":1,"type":"entry","item":{"b":2}}`+"\n", buf.String())
+}
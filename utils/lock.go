@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+)
+
+// releaseTimeout bounds the wait for the lock of a builder to be removed.
+const releaseTimeout = 10 * time.Second
+
+// ReleaseBuilder closes a snapshot builder and waits for its lock to be
+// removed from the store.  The builder deletes it in the background, and
+// the next builder fails if a lock vanishes while it checks the existing
+// ones, so builders created one after the other must wait for it.
+func ReleaseBuilder(repo *repository.Repository, builder *snapshot.Builder) error {
+	closeErr := builder.Close()
+
+	deadline := time.Now().Add(releaseTimeout)
+	for {
+		locks, err := repo.GetLocks()
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(locks, builder.Header.Identifier) {
+			return closeErr
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("lock %x was not released", builder.Header.Identifier[:4])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// SharedLock takes a shared lock on the repository, as a snapshot builder
+// does, for a write short enough not to need it to be refreshed.  It
+// fails if maintenance holds the repository.  The returned function
+// releases the lock.
+func SharedLock(repo *repository.Repository) (func(), error) {
+	if lockless, _ := strconv.ParseBool(os.Getenv("PLAKAR_LOCKLESS")); lockless {
+		return func() {}, nil
+	}
+
+	lockID := objects.RandomMAC()
+	buffer := &bytes.Buffer{}
+	if err := repository.NewSharedLock(repo.AppContext().Hostname).SerializeToStream(buffer); err != nil {
+		return nil, err
+	}
+	if _, err := repo.PutLock(lockID, buffer); err != nil {
+		return nil, err
+	}
+	unlock := func() {
+		repo.DeleteLock(lockID)
+	}
+
+	locks, err := repo.GetLocks()
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	for _, id := range locks {
+		if id == lockID {
+			continue
+		}
+
+		rd, err := repo.GetLock(id)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		lock, err := repository.NewLockFromStream(rd)
+		rd.Close()
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+
+		if lock.Exclusive && !lock.IsStale() {
+			unlock()
+			return nil, fmt.Errorf("can't take repository lock, it's already locked by maintenance")
+		}
+	}
+
+	return unlock, nil
+}
@@ -0,0 +1,33 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseBuilder(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	// builders created one after the other, each finding the lock of the
+	// previous one gone
+	for range 5 {
+		builder, err := snapshot.Create(repo, repository.DefaultType, "", objects.NilMac, &snapshot.BuilderOptions{})
+		require.NoError(t, err)
+
+		locks, err := repo.GetLocks()
+		require.NoError(t, err)
+		require.Contains(t, locks, builder.Header.Identifier)
+
+		require.NoError(t, utils.ReleaseBuilder(repo, builder))
+
+		locks, err = repo.GetLocks()
+		require.NoError(t, err)
+		require.NotContains(t, locks, builder.Header.Identifier)
+	}
+}
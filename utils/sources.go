@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2025 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"iter"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+)
+
+// FindSource resolves selector to the index of one of the sources of a
+// snapshot.  The selector is either the index of the source, as shown
+// by plakar info, or its origin, its directory or its type:origin.
+func FindSource(hdr *header.Header, selector string) (int, error) {
+	if len(hdr.Sources) == 0 {
+		return -1, fmt.Errorf("snapshot has no source")
+	}
+
+	if idx, err := strconv.Atoi(selector); err == nil {
+		if idx < 0 || idx >= len(hdr.Sources) {
+			return -1, fmt.Errorf("invalid source index %d: snapshot has %d source(s)", idx, len(hdr.Sources))
+		}
+		return idx, nil
+	}
+
+	found := -1
+	for i := range hdr.Sources {
+		imp := &hdr.Sources[i].Importer
+		if selector != imp.Origin && selector != imp.Directory && selector != imp.Type+":"+imp.Origin {
+			continue
+		}
+		if found != -1 {
+			return -1, fmt.Errorf("source %q is ambiguous, use its index instead", selector)
+		}
+		found = i
+	}
+	if found == -1 {
+		return -1, fmt.Errorf("no source matching %q in snapshot", selector)
+	}
+	return found, nil
+}
+
+// MatchSource returns the index of the source imported from the given
+// type, origin and directory, or -1 if there is none.
+func MatchSource(hdr *header.Header, typ, origin, directory string) int {
+	for i := range hdr.Sources {
+		imp := &hdr.Sources[i].Importer
+		if imp.Type == typ && imp.Origin == origin && imp.Directory == directory {
+			return i
+		}
+	}
+	return -1
+}
+
+// SnapshotSource returns a view of snap in which the idx-th source is
+// the first one, which is the source kloset operates on when reading
+// the filesystem, the indexes or exporting.  The view is loaded afresh
+// so that no state cached for another source leaks into it; it must
+// only be used for reading.
+func SnapshotSource(repo *repository.Repository, snap *snapshot.Snapshot, idx int) (*snapshot.Snapshot, error) {
+	if idx < 0 || idx >= len(snap.Header.Sources) {
+		return nil, fmt.Errorf("invalid source index %d: snapshot has %d source(s)", idx, len(snap.Header.Sources))
+	}
+	if idx == 0 {
+		return snap, nil
+	}
+
+	view, err := snapshot.Load(repo, snap.Header.Identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := *view.Header
+	hdr.Sources = make([]header.Source, 0, len(view.Header.Sources))
+	hdr.Sources = append(hdr.Sources, view.Header.Sources[idx])
+	for i := range view.Header.Sources {
+		if i != idx {
+			hdr.Sources = append(hdr.Sources, view.Header.Sources[i])
+		}
+	}
+	view.Header = &hdr
+	return view, nil
+}
+
+// SelectSource is a convenience wrapper around FindSource and
+// SnapshotSource.  An empty selector designates the first source.
+func SelectSource(repo *repository.Repository, snap *snapshot.Snapshot, selector string) (*snapshot.Snapshot, error) {
+	if selector == "" {
+		return snap, nil
+	}
+	idx, err := FindSource(snap.Header, selector)
+	if err != nil {
+		return nil, err
+	}
+	return SnapshotSource(repo, snap, idx)
+}
+
+// SnapshotSources iterates over a view of snap for each of its sources.
+func SnapshotSources(repo *repository.Repository, snap *snapshot.Snapshot) iter.Seq2[*snapshot.Snapshot, error] {
+	return func(yield func(*snapshot.Snapshot, error) bool) {
+		for i := range snap.Header.Sources {
+			if !yield(SnapshotSource(repo, snap, i)) {
+				return
+			}
+		}
+	}
+}
+
+// OpenSnapshotSource behaves like locate.OpenSnapshotByPathRelative but
+// returns a view on the source designated by selector, relative paths
+// being resolved against the directory of that source.
+func OpenSnapshotSource(repo *repository.Repository, snapshotPath, selector string) (*snapshot.Snapshot, string, string, error) {
+	prefix, pathname := locate.ParseSnapshotPath(snapshotPath)
+
+	snapshotID, err := locate.LocateSnapshotByPrefix(repo, prefix)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	view, err := SelectSource(repo, snap, selector)
+	if err != nil {
+		snap.Close()
+		return nil, "", "", err
+	}
+	if view != snap {
+		snap.Close()
+	}
+
+	if strings.HasPrefix(pathname, "/") {
+		return view, path.Clean(pathname), "", nil
+	}
+
+	relative := pathname
+	if relative == "" {
+		relative = "."
+	}
+	snapRoot := path.Join(view.Header.GetSource(0).Importer.Directory, pathname)
+	return view, path.Clean(snapRoot), relative, nil
+}
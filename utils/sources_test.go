@@ -0,0 +1,104 @@
+package utils_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func multiSourceHeader() *header.Header {
+	return &header.Header{
+		Sources: []header.Source{
+			{Importer: header.Importer{Type: "fs", Origin: "host1", Directory: "/etc"}},
+			{Importer: header.Importer{Type: "fs", Origin: "host1", Directory: "/var"}},
+			{Importer: header.Importer{Type: "s3", Origin: "bucket", Directory: "/"}},
+		},
+	}
+}
+
+func TestFindSource(t *testing.T) {
+	hdr := multiSourceHeader()
+
+	for selector, expected := range map[string]int{
+		"0":         0,
+		"2":         2,
+		"/var":      1,
+		"bucket":    2,
+		"s3:bucket": 2,
+	} {
+		idx, err := utils.FindSource(hdr, selector)
+		require.NoError(t, err, selector)
+		require.Equal(t, expected, idx, selector)
+	}
+
+	_, err := utils.FindSource(hdr, "3")
+	require.ErrorContains(t, err, "invalid source index")
+
+	_, err = utils.FindSource(hdr, "host1")
+	require.ErrorContains(t, err, "ambiguous")
+
+	_, err = utils.FindSource(hdr, "nope")
+	require.ErrorContains(t, err, "no source matching")
+
+	_, err = utils.FindSource(&header.Header{}, "0")
+	require.ErrorContains(t, err, "no source")
+}
+
+func TestMatchSource(t *testing.T) {
+	hdr := multiSourceHeader()
+	require.Equal(t, 1, utils.MatchSource(hdr, "fs", "host1", "/var"))
+	require.Equal(t, -1, utils.MatchSource(hdr, "fs", "host2", "/var"))
+}
+
+func TestSnapshotSources(t *testing.T) {
+	repo, _ := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("first.txt", 0644, "first"),
+	}, ptesting.WithSource("other", []ptesting.MockFile{
+		ptesting.NewMockFile("second.txt", 0644, "second"),
+	}))
+	defer snap.Close()
+
+	require.Len(t, snap.Header.Sources, 2)
+
+	view, err := utils.SelectSource(repo, snap, "")
+	require.NoError(t, err)
+	require.Same(t, snap, view)
+
+	view, err = utils.SelectSource(repo, snap, "other")
+	require.NoError(t, err)
+	require.Equal(t, "other", view.Header.GetSource(0).Importer.Origin)
+	// the original snapshot is left untouched
+	require.Equal(t, "mock", snap.Header.GetSource(0).Importer.Origin)
+
+	fs, err := view.Filesystem()
+	require.NoError(t, err)
+	_, err = fs.GetEntry("/second.txt")
+	require.NoError(t, err)
+	_, err = fs.GetEntry("/first.txt")
+	require.Error(t, err)
+
+	origins := []string{}
+	for view, err := range utils.SnapshotSources(repo, snap) {
+		require.NoError(t, err)
+		origins = append(origins, view.Header.GetSource(0).Importer.Origin)
+	}
+	require.Equal(t, []string{"mock", "other"}, origins)
+
+	_, err = utils.SnapshotSource(repo, snap, 2)
+	require.Error(t, err)
+
+	id := hex.EncodeToString(snap.Header.Identifier[:])
+	view, pathname, relative, err := utils.OpenSnapshotSource(repo, id+":second.txt", "1")
+	require.NoError(t, err)
+	require.Equal(t, "/second.txt", pathname)
+	require.Equal(t, "second.txt", relative)
+	require.Equal(t, "other", view.Header.GetSource(0).Importer.Origin)
+
+	_, _, _, err = utils.OpenSnapshotSource(repo, id, "missing")
+	require.Error(t, err)
+}